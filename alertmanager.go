@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Deployments covered by an active Alertmanager silence
+	deploymentSilencedByAlertmanager = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_silenced_by_alertmanager",
+			Help: "Whether an active Alertmanager silence matches the deployment's alert labels (1 = silenced)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// alertmanagerMatcher is a silence matcher as returned by the Alertmanager
+// v2 API.
+type alertmanagerMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual *bool  `json:"isEqual"`
+
+	regex *regexp.Regexp
+}
+
+func (m *alertmanagerMatcher) matches(labels map[string]string) bool {
+	value := labels[m.Name]
+	matched := value == m.Value
+	if m.IsRegex {
+		matched = m.regex.MatchString(value)
+	}
+	if m.IsEqual != nil && !*m.IsEqual {
+		return !matched
+	}
+	return matched
+}
+
+// alertmanagerSilences mirrors the active silences of an Alertmanager. A
+// silence applies to a deployment when all its matchers match the labels
+// its alerts would carry: namespace, deployment and --alertmanager-alert-labels.
+type alertmanagerSilences struct {
+	url    string
+	labels map[string]string
+	client *http.Client
+
+	mu       sync.RWMutex
+	silences [][]alertmanagerMatcher
+}
+
+func newAlertmanagerSilences(url string, labels map[string]string) *alertmanagerSilences {
+	return &alertmanagerSilences{
+		url:    strings.TrimSuffix(url, "/"),
+		labels: labels,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// parseAlertLabels parses comma-separated name=value pairs.
+func parseAlertLabels(list string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range splitList(list) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %q, must be name=value", pair)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// sync fetches the active silences. On error the previous ones are kept.
+func (a *alertmanagerSilences) sync() error {
+	resp, err := a.client.Get(a.url + "/api/v2/silences")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("alertmanager returned %s", resp.Status)
+	}
+
+	var response []struct {
+		Status struct {
+			State string `json:"state"`
+		} `json:"status"`
+		Matchers []alertmanagerMatcher `json:"matchers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("decoding silences: %w", err)
+	}
+
+	var silences [][]alertmanagerMatcher
+	for _, s := range response {
+		if s.Status.State != "active" {
+			continue
+		}
+		valid := true
+		for i := range s.Matchers {
+			if !s.Matchers[i].IsRegex {
+				continue
+			}
+			// Alertmanager anchors regex matchers
+			if s.Matchers[i].regex, err = regexp.Compile("^(?:" + s.Matchers[i].Value + ")$"); err != nil {
+				valid = false
+			}
+		}
+		if valid {
+			silences = append(silences, s.Matchers)
+		}
+	}
+
+	a.mu.Lock()
+	a.silences = silences
+	a.mu.Unlock()
+	debugf("Synced %d active Alertmanager silences", len(silences))
+	return nil
+}
+
+// run syncs the silences every interval.
+func (a *alertmanagerSilences) run(interval time.Duration) {
+	for {
+		if err := a.sync(); err != nil {
+			log.Printf("Error syncing Alertmanager silences from %s: %v", a.url, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// silenced reports whether an active silence matches the deployment.
+func (a *alertmanagerSilences) silenced(namespace, deployment string) bool {
+	labels := map[string]string{"namespace": namespace, "deployment": deployment}
+	for name, value := range a.labels {
+		labels[name] = value
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, matchers := range a.silences {
+		matched := len(matchers) > 0
+		for i := range matchers {
+			if !matchers[i].matches(labels) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// collectAlertmanagerSilenceMetrics reports whether an Alertmanager silence
+// covers the deployment.
+func (t *DeploymentTracker) collectAlertmanagerSilenceMetrics(namespace, deploymentName string) {
+	if t.alertmanager == nil {
+		return
+	}
+	silenced := float64(0)
+	if t.alertmanager.silenced(namespace, deploymentName) {
+		silenced = 1
+	}
+	deploymentSilencedByAlertmanager.WithLabelValues(namespace, deploymentName).Set(silenced)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Weight of each new usage sample in the baselines, i.e. they follow
+	// roughly the last 20 samples
+	baselineAlpha = 0.05
+
+	// Samples a baseline needs before deviations from it count
+	baselineWarmup = 20
+
+	// Deviations under this fraction of the baseline mean never count, so
+	// deployments with almost constant usage don't flag noise
+	baselineMinDeviation = 0.05
+)
+
+var (
+	// Usage far off the deployment's own recent behavior
+	deploymentCPUUsageAnomaly = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_cpu_usage_anomaly",
+			Help: "Whether the deployment's CPU usage deviates from its rolling baseline by more than --anomaly-sigmas standard deviations (1 = anomaly)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentMemoryUsageAnomaly = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_memory_usage_anomaly",
+			Help: "Whether the deployment's memory usage deviates from its rolling baseline by more than --anomaly-sigmas standard deviations (1 = anomaly)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// baseline is an exponentially weighted mean and variance of a series.
+type baseline struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// deviates reports whether value is more than sigmas standard deviations
+// off the baseline, once it is warmed up.
+func (b *baseline) deviates(value, sigmas float64) bool {
+	if b.samples < baselineWarmup {
+		return false
+	}
+	deviation := math.Abs(value - b.mean)
+	return deviation > sigmas*math.Sqrt(b.variance) && deviation > baselineMinDeviation*b.mean
+}
+
+// update adds a sample to the baseline.
+func (b *baseline) update(value float64) {
+	b.samples++
+	if b.samples == 1 {
+		b.mean = value
+		return
+	}
+	diff := value - b.mean
+	increment := baselineAlpha * diff
+	b.mean += increment
+	b.variance = (1 - baselineAlpha) * (b.variance + diff*increment)
+}
+
+// usageBaselines keeps the CPU and memory usage baselines of each
+// deployment.
+type usageBaselines struct {
+	sigmas float64
+
+	mu     sync.Mutex
+	cpu    map[string]*baseline // namespace/deployment -> baseline
+	memory map[string]*baseline
+}
+
+func newUsageBaselines(sigmas float64) *usageBaselines {
+	return &usageBaselines{
+		sigmas: sigmas,
+		cpu:    make(map[string]*baseline),
+		memory: make(map[string]*baseline),
+	}
+}
+
+// forget drops the usage baselines of a deleted deployment.
+func (u *usageBaselines) forget(ns, name string) {
+	if u == nil {
+		return
+	}
+	key := ns + "/" + name
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.cpu, key)
+	delete(u.memory, key)
+}
+
+// observe checks a usage sample against the deployment's baseline and then
+// adds it, so lasting level shifts become the new normal.
+func (u *usageBaselines) observe(baselines map[string]*baseline, key string, value float64) bool {
+	b, ok := baselines[key]
+	if !ok {
+		b = &baseline{}
+		baselines[key] = b
+	}
+	anomaly := b.deviates(value, u.sigmas)
+	b.update(value)
+	return anomaly
+}
+
+// collectAnomalyMetrics reports whether the deployment's current usage
+// (millicores and bytes) is anomalous.
+func (t *DeploymentTracker) collectAnomalyMetrics(key string, gauges *deploymentGauges, cpu, memory float64) {
+	u := t.baselines
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	cpuAnomaly := u.observe(u.cpu, key, cpu)
+	memoryAnomaly := u.observe(u.memory, key, memory)
+	u.mu.Unlock()
+
+	for vec, anomaly := range map[*prometheus.GaugeVec]bool{
+		deploymentCPUUsageAnomaly:    cpuAnomaly,
+		deploymentMemoryUsageAnomaly: memoryAnomaly,
+	} {
+		value := float64(0)
+		if anomaly {
+			value = 1
+		}
+		gauges.gauge(vec).Set(value)
+	}
+}
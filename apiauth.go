@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/yaml"
+)
+
+// Scopes of API tokens: read allows GET requests, write also the mutating
+// control endpoints (silences, debug inject, reload, quit, log level)
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+// Per-client rate limiters idle for this long are dropped
+const apiLimiterIdle = 10 * time.Minute
+
+var (
+	// API requests turned away by authentication or rate limits
+	exporterAPIRequestsRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_api_requests_rejected_total",
+			Help: "Number of JSON and control API requests rejected, by reason (unauthorized, forbidden, rate_limited)",
+		},
+		[]string{"reason"},
+	)
+)
+
+// apiToken is one entry of --api-tokens-file.
+type apiToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Scope string `json:"scope"`
+}
+
+// loadAPITokens reads a --api-tokens-file:
+//
+//	tokens:
+//	  - name: dashboards
+//	    token: s3cr3t
+//	    scope: read
+func loadAPITokens(path string) ([]apiToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Tokens []apiToken `json:"tokens"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	names := make(map[string]bool, len(file.Tokens))
+	for i, token := range file.Tokens {
+		switch {
+		case token.Name == "":
+			return nil, fmt.Errorf("%s: token %d has no name", path, i+1)
+		case names[token.Name]:
+			return nil, fmt.Errorf("%s: duplicate token name %q", path, token.Name)
+		case token.Token == "":
+			return nil, fmt.Errorf("%s: token %q is empty", path, token.Name)
+		case token.Scope != scopeRead && token.Scope != scopeWrite:
+			return nil, fmt.Errorf("%s: token %q has scope %q, must be read or write", path, token.Name, token.Scope)
+		}
+		names[token.Name] = true
+	}
+	return file.Tokens, nil
+}
+
+// apiClientKey is the context key of the authenticated API token.
+type apiClientKey struct{}
+
+// writeAuthorized reports whether the request was authenticated with a
+// write-scoped API token.
+func writeAuthorized(r *http.Request) bool {
+	token, ok := r.Context().Value(apiClientKey{}).(apiToken)
+	return ok && token.Scope == scopeWrite
+}
+
+// clientLimiter is the rate limiter of one API client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// apiGuard authenticates JSON and control API requests with the tokens of
+// --api-tokens-file and rate limits them per client (token, or remote
+// address without tokens), after applying the CORS policy. All are
+// optional; /metrics is not guarded.
+type apiGuard struct {
+	tokens []apiToken
+	limit  rate.Limit
+	burst  int
+	cors   *corsPolicy
+
+	mu       sync.Mutex
+	limiters map[string]*clientLimiter
+	pruned   time.Time
+}
+
+func newAPIGuard(tokens []apiToken, requestsPerSecond float64, burst int, cors *corsPolicy) *apiGuard {
+	return &apiGuard{
+		tokens:   tokens,
+		limit:    rate.Limit(requestsPerSecond),
+		burst:    burst,
+		cors:     cors,
+		limiters: make(map[string]*clientLimiter),
+	}
+}
+
+// authenticate returns the token the request carries.
+func (g *apiGuard) authenticate(r *http.Request) (apiToken, bool) {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return apiToken{}, false
+	}
+	for _, token := range g.tokens {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token.Token)) == 1 {
+			return token, true
+		}
+	}
+	return apiToken{}, false
+}
+
+// allow takes a request from the client's rate limit.
+func (g *apiGuard) allow(client string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.pruned) > apiLimiterIdle {
+		for key, l := range g.limiters {
+			if now.Sub(l.lastSeen) > apiLimiterIdle {
+				delete(g.limiters, key)
+			}
+		}
+		g.pruned = now
+	}
+	l, ok := g.limiters[client]
+	if !ok {
+		l = &clientLimiter{limiter: rate.NewLimiter(g.limit, g.burst)}
+		g.limiters[client] = l
+	}
+	l.lastSeen = now
+	return l.limiter.AllowN(now, 1)
+}
+
+// wrap guards an API handler. GET and HEAD requests need a read or write
+// token, all others a write token.
+func (g *apiGuard) wrap(handler http.HandlerFunc) http.HandlerFunc {
+	if len(g.tokens) == 0 && g.limit <= 0 && g.cors == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.cors != nil && g.cors.handle(w, r) {
+			return
+		}
+
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		if len(g.tokens) > 0 {
+			token, ok := g.authenticate(r)
+			if !ok {
+				exporterAPIRequestsRejected.WithLabelValues("unauthorized").Inc()
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && token.Scope != scopeWrite {
+				exporterAPIRequestsRejected.WithLabelValues("forbidden").Inc()
+				http.Error(w, fmt.Sprintf("token %q is read-only", token.Name), http.StatusForbidden)
+				return
+			}
+			client = "token:" + token.Name
+			r = r.WithContext(context.WithValue(r.Context(), apiClientKey{}, token))
+		}
+
+		if g.limit > 0 && !g.allow(client, time.Now()) {
+			exporterAPIRequestsRejected.WithLabelValues("rate_limited").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
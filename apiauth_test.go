@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAPIGuardWrap(t *testing.T) {
+	tokens := []apiToken{
+		{Name: "dashboards", Token: "r3ad", Scope: scopeRead},
+		{Name: "ops", Token: "wr1te", Scope: scopeWrite},
+	}
+
+	tests := []struct {
+		name          string
+		tokens        []apiToken
+		limit         float64
+		burst         int
+		cors          string
+		method        string
+		authorization string
+		origin        string
+		preflight     bool
+		requests      int
+		status        int
+		rejected      string
+		writeScope    bool
+	}{
+		{
+			name:   "unguarded",
+			method: http.MethodPost,
+			status: http.StatusOK,
+		},
+		{
+			name:     "no token",
+			tokens:   tokens,
+			method:   http.MethodGet,
+			status:   http.StatusUnauthorized,
+			rejected: "unauthorized",
+		},
+		{
+			name:          "unknown token",
+			tokens:        tokens,
+			method:        http.MethodGet,
+			authorization: "Bearer guess",
+			status:        http.StatusUnauthorized,
+			rejected:      "unauthorized",
+		},
+		{
+			name:          "token without Bearer",
+			tokens:        tokens,
+			method:        http.MethodGet,
+			authorization: "r3ad",
+			status:        http.StatusUnauthorized,
+			rejected:      "unauthorized",
+		},
+		{
+			name:          "read token reads",
+			tokens:        tokens,
+			method:        http.MethodGet,
+			authorization: "Bearer r3ad",
+			status:        http.StatusOK,
+		},
+		{
+			name:          "read token HEAD",
+			tokens:        tokens,
+			method:        http.MethodHead,
+			authorization: "Bearer r3ad",
+			status:        http.StatusOK,
+		},
+		{
+			name:          "read token writes",
+			tokens:        tokens,
+			method:        http.MethodPost,
+			authorization: "Bearer r3ad",
+			status:        http.StatusForbidden,
+			rejected:      "forbidden",
+		},
+		{
+			name:          "read token deletes",
+			tokens:        tokens,
+			method:        http.MethodDelete,
+			authorization: "Bearer r3ad",
+			status:        http.StatusForbidden,
+			rejected:      "forbidden",
+		},
+		{
+			name:          "write token writes",
+			tokens:        tokens,
+			method:        http.MethodPut,
+			authorization: "Bearer wr1te",
+			status:        http.StatusOK,
+			writeScope:    true,
+		},
+		{
+			name:          "write token reads",
+			tokens:        tokens,
+			method:        http.MethodGet,
+			authorization: "Bearer wr1te",
+			status:        http.StatusOK,
+			writeScope:    true,
+		},
+		{
+			name:     "within burst",
+			limit:    0.001,
+			burst:    3,
+			method:   http.MethodGet,
+			requests: 3,
+			status:   http.StatusOK,
+		},
+		{
+			name:     "rate limited",
+			limit:    0.001,
+			burst:    3,
+			method:   http.MethodGet,
+			requests: 4,
+			status:   http.StatusTooManyRequests,
+			rejected: "rate_limited",
+		},
+		{
+			name:          "rate limited by token",
+			tokens:        tokens,
+			limit:         0.001,
+			burst:         1,
+			method:        http.MethodGet,
+			authorization: "Bearer r3ad",
+			requests:      2,
+			status:        http.StatusTooManyRequests,
+			rejected:      "rate_limited",
+		},
+		{
+			name:      "preflight skips authentication",
+			tokens:    tokens,
+			cors:      "https://a.example.com",
+			method:    http.MethodOptions,
+			origin:    "https://a.example.com",
+			preflight: true,
+			status:    http.StatusNoContent,
+		},
+		{
+			name:     "CORS request still needs a token",
+			tokens:   tokens,
+			cors:     "https://a.example.com",
+			method:   http.MethodGet,
+			origin:   "https://a.example.com",
+			status:   http.StatusUnauthorized,
+			rejected: "unauthorized",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var writeScope bool
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				writeScope = writeAuthorized(r)
+			}
+			guard := newAPIGuard(tt.tokens, tt.limit, tt.burst, newCORSPolicy(tt.cors))
+			wrapped := guard.wrap(handler)
+
+			var rejectedBefore float64
+			if tt.rejected != "" {
+				rejectedBefore = testutil.ToFloat64(exporterAPIRequestsRejected.WithLabelValues(tt.rejected))
+			}
+
+			requests := tt.requests
+			if requests == 0 {
+				requests = 1
+			}
+			var w *httptest.ResponseRecorder
+			for i := 0; i < requests; i++ {
+				r := httptest.NewRequest(tt.method, "/api/v1/silences", nil)
+				if tt.authorization != "" {
+					r.Header.Set("Authorization", tt.authorization)
+				}
+				if tt.origin != "" {
+					r.Header.Set("Origin", tt.origin)
+				}
+				if tt.preflight {
+					r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				}
+				w = httptest.NewRecorder()
+				wrapped(w, r)
+			}
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.rejected != "" {
+				if got := testutil.ToFloat64(exporterAPIRequestsRejected.WithLabelValues(tt.rejected)) - rejectedBefore; got != 1 {
+					t.Errorf("%s rejections = %v, want 1", tt.rejected, got)
+				}
+			}
+			if tt.status == http.StatusOK && writeScope != tt.writeScope {
+				t.Errorf("writeAuthorized() = %v, want %v", writeScope, tt.writeScope)
+			}
+			if tt.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After not set")
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAPIGuardAllow(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	guard := newAPIGuard(nil, 1, 2, nil)
+
+	// Each client has its own burst
+	for _, client := range []string{"10.0.0.1", "10.0.0.2"} {
+		for i := 0; i < 2; i++ {
+			if !guard.allow(client, now) {
+				t.Fatalf("request %d of %s rejected within the burst", i+1, client)
+			}
+		}
+		if guard.allow(client, now) {
+			t.Errorf("request of %s allowed above the burst", client)
+		}
+	}
+
+	// The limit refills at one request per second
+	if !guard.allow("10.0.0.1", now.Add(time.Second)) {
+		t.Error("request rejected after the limit refilled")
+	}
+
+	// Limiters idle for apiLimiterIdle are dropped on the next request
+	later := now.Add(time.Second + apiLimiterIdle + time.Minute)
+	guard.allow("10.0.0.3", later)
+	guard.mu.Lock()
+	_, kept1 := guard.limiters["10.0.0.1"]
+	_, kept2 := guard.limiters["10.0.0.2"]
+	n := len(guard.limiters)
+	guard.mu.Unlock()
+	if kept1 || kept2 || n != 1 {
+		t.Errorf("limiters after idle period: 10.0.0.1 kept %v, 10.0.0.2 kept %v, %d total, want only 10.0.0.3", kept1, kept2, n)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Long-term incident archive health
+	exporterArchiveFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "exporter_incident_archive_failures_total",
+			Help: "Number of failed uploads of resolved incidents to --archive-bucket",
+		},
+	)
+
+	exporterArchiveLastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "exporter_incident_archive_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful upload of resolved incidents to --archive-bucket",
+		},
+	)
+)
+
+// archiveLocation is a parsed --archive-bucket URL, s3://bucket/prefix or
+// gs://bucket/prefix.
+type archiveLocation struct {
+	endpoint string // scheme://host of the S3-compatible API
+	region   string
+	bucket   string
+	prefix   string
+}
+
+func parseArchiveBucket(bucketURL, endpoint, region string) (archiveLocation, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return archiveLocation{}, err
+	}
+	if u.Host == "" {
+		return archiveLocation{}, fmt.Errorf("%q has no bucket", bucketURL)
+	}
+	loc := archiveLocation{endpoint: endpoint, region: region, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}
+	switch u.Scheme {
+	case "s3":
+		if loc.endpoint == "" {
+			loc.endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+	case "gs":
+		// Cloud Storage's XML API accepts SigV4 requests signed with HMAC
+		// keys, with region auto
+		if loc.endpoint == "" {
+			loc.endpoint = "https://storage.googleapis.com"
+		}
+		loc.region = "auto"
+	default:
+		return archiveLocation{}, fmt.Errorf("%q must start with s3:// or gs://", bucketURL)
+	}
+	return loc, nil
+}
+
+// incidentArchiver periodically uploads newly resolved incidents as a JSON
+// Lines object to S3 or Cloud Storage, so the history outlives the
+// in-memory incident store. Each incident is uploaded once per process;
+// incidents re-imported with --import-state may be uploaded again and can
+// be deduplicated by namespace, deployment and start.
+type incidentArchiver struct {
+	incidents   *incidentStore
+	location    archiveLocation
+	credentials *awsCredentialsSource
+	host        string
+	client      *http.Client
+
+	mu       sync.Mutex
+	archived map[uint64]bool
+}
+
+func newIncidentArchiver(incidents *incidentStore, opts *options) (*incidentArchiver, error) {
+	location, err := parseArchiveBucket(opts.archiveBucket, opts.archiveEndpoint, opts.archiveRegion)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := newAWSCredentialsSource(location.region)
+	if err != nil {
+		return nil, fmt.Errorf("credentials to upload to %s: %w", opts.archiveBucket, err)
+	}
+	host, _ := os.Hostname()
+	return &incidentArchiver{
+		incidents:   incidents,
+		location:    location,
+		credentials: credentials,
+		host:        host,
+		client:      &http.Client{Timeout: 60 * time.Second},
+		archived:    make(map[uint64]bool),
+	}, nil
+}
+
+func (a *incidentArchiver) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := a.archive(now); err != nil {
+			exporterArchiveFailures.Inc()
+			log.Printf("Error archiving incidents to %s: %v", a.location.bucket, err)
+		}
+	}
+}
+
+// archive uploads the resolved incidents that weren't uploaded yet. They are
+// only marked as archived once the upload succeeded, so failed uploads are
+// retried with the next interval.
+func (a *incidentArchiver) archive(now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	resolved := a.incidents.list("", "resolved")
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	var pending []uint64
+	current := make(map[uint64]bool, len(resolved))
+	// Oldest first, as list returns newest first
+	for i := len(resolved) - 1; i >= 0; i-- {
+		inc := resolved[i]
+		current[inc.ID] = true
+		if a.archived[inc.ID] {
+			continue
+		}
+		inc.GroupID = 0
+		if err := encoder.Encode(inc); err != nil {
+			return err
+		}
+		pending = append(pending, inc.ID)
+	}
+	// Incidents dropped from the store can't come back
+	for id := range a.archived {
+		if !current[id] {
+			delete(a.archived, id)
+		}
+	}
+	if len(pending) == 0 {
+		exporterArchiveLastSuccess.Set(float64(now.Unix()))
+		return nil
+	}
+
+	// Partitioned by day for data lake tables, named by replica so shards
+	// and per-namespace instances don't overwrite each other
+	key := fmt.Sprintf("incidents/dt=%s/%s-%s.jsonl", now.UTC().Format("2006-01-02"), a.host, now.UTC().Format("20060102T150405Z"))
+	if a.location.prefix != "" {
+		key = a.location.prefix + "/" + key
+	}
+	if err := a.put(key, body.Bytes(), now); err != nil {
+		return err
+	}
+	for _, id := range pending {
+		a.archived[id] = true
+	}
+	exporterArchiveLastSuccess.Set(float64(now.Unix()))
+	log.Printf("Archived %d incidents to %s/%s", len(pending), a.location.bucket, key)
+	return nil
+}
+
+// put uploads an object with a path-style PUT, signed with AWS Signature
+// Version 4.
+func (a *incidentArchiver) put(key string, body []byte, now time.Time) error {
+	credentials, err := a.credentials.get(now)
+	if err != nil {
+		return err
+	}
+	objectURL, err := url.Parse(a.location.endpoint)
+	if err != nil {
+		return err
+	}
+	objectURL.Path = "/" + a.location.bucket + "/" + key
+	objectURL.RawPath = uriEncodePath(objectURL.Path)
+
+	req, err := http.NewRequest(http.MethodPut, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, payloadHash, credentials, a.location.region, "s3", now)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("upload of %s returned %s", key, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Index of HPAs and ScaledObjects by the namespace/name of the deployment
+// they scale
+const scaleTargetIndex = "scaleTarget"
+
+// KEDA ScaledObjects, watched through the dynamic client since KEDA may not
+// be installed
+var scaledObjectResource = schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledobjects"}
+
+var (
+	// Larger deployments scaled by neither an HPA nor KEDA
+	deploymentAutoscalerMissing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_autoscaler_missing",
+			Help: "Whether the deployment has at least --autoscaler-missing-replicas replicas and no HPA or KEDA ScaledObject targeting it (1 = missing)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+func hpaScaleTargetIndexFunc(obj interface{}) ([]string, error) {
+	hpa, ok := obj.(*autoscalingv2.HorizontalPodAutoscaler)
+	if !ok || hpa.Spec.ScaleTargetRef.Kind != "Deployment" {
+		return nil, nil
+	}
+	return []string{hpa.Namespace + "/" + hpa.Spec.ScaleTargetRef.Name}, nil
+}
+
+func scaledObjectScaleTargetIndexFunc(obj interface{}) ([]string, error) {
+	so, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	name, _, _ := unstructured.NestedString(so.Object, "spec", "scaleTargetRef", "name")
+	kind, _, _ := unstructured.NestedString(so.Object, "spec", "scaleTargetRef", "kind")
+	if name == "" || (kind != "" && kind != "Deployment") {
+		return nil, nil
+	}
+	return []string{so.GetNamespace() + "/" + name}, nil
+}
+
+// scaledObjectsInstalled reports whether the KEDA ScaledObject CRD is
+// installed, so ScaledObjects can be watched.
+func (t *DeploymentTracker) scaledObjectsInstalled() bool {
+	if _, err := t.clientset.Discovery().ServerResourcesForGroupVersion(scaledObjectResource.GroupVersion().String()); err != nil {
+		log.Printf("KEDA ScaledObjects not available, only HPAs are considered: %v", err)
+		return false
+	}
+	return true
+}
+
+// scaledObjects returns the KEDA ScaledObjects targeting the deployment.
+func (t *DeploymentTracker) scaledObjects(deployment *appsv1.Deployment) []*unstructured.Unstructured {
+	informer := t.cachesFor(deployment.Namespace).scaledObjects
+	if informer == nil {
+		return nil
+	}
+	objs, err := informer.GetIndexer().ByIndex(scaleTargetIndex, deployment.Namespace+"/"+deployment.Name)
+	if err != nil {
+		return nil
+	}
+	scaledObjects := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		scaledObjects = append(scaledObjects, obj.(*unstructured.Unstructured))
+	}
+	return scaledObjects
+}
+
+// autoscaled reports whether an HPA or KEDA ScaledObject targets the
+// deployment.
+func (t *DeploymentTracker) autoscaled(deployment *appsv1.Deployment) bool {
+	if len(t.scaledObjects(deployment)) > 0 {
+		return true
+	}
+	hpas, err := t.cachesFor(deployment.Namespace).hpas.GetIndexer().ByIndex(scaleTargetIndex, deployment.Namespace+"/"+deployment.Name)
+	return err == nil && len(hpas) > 0
+}
+
+// collectAutoscalerMetrics flags deployments large enough to need an
+// autoscaler that don't have one.
+func (t *DeploymentTracker) collectAutoscalerMetrics(deployment *appsv1.Deployment) {
+	if t.autoscaleReplicas <= 0 {
+		return
+	}
+	missing := float64(0)
+	if deployment.Spec.Replicas != nil && int(*deployment.Spec.Replicas) >= t.autoscaleReplicas && !t.autoscaled(deployment) {
+		missing = 1
+	}
+	deploymentAutoscalerMissing.WithLabelValues(deployment.Namespace, deployment.Name).Set(missing)
+}
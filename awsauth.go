@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Web identity credentials are renewed this long before they expire
+const awsCredentialsRefreshMargin = 5 * time.Minute
+
+// Session name of the assumed role unless AWS_ROLE_SESSION_NAME is set
+const defaultAWSRoleSessionName = "deployment-exporter"
+
+// awsCredentials are the credentials requests are signed with.
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string    // empty for long-term keys
+	expires         time.Time // zero for credentials that don't expire
+}
+
+// awsCredentialsSource resolves credentials from the environment like the
+// AWS SDKs do, in order: static keys (AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and optionally AWS_SESSION_TOKEN), then a web
+// identity token exchanged with STS (AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE, as injected for IAM roles for service
+// accounts). Web identity credentials are cached until shortly before they
+// expire, re-reading the rotated token file.
+type awsCredentialsSource struct {
+	static *awsCredentials
+
+	roleARN     string
+	tokenFile   string
+	sessionName string
+	stsEndpoint string
+	client      *http.Client
+
+	mu     sync.Mutex
+	cached awsCredentials
+}
+
+func newAWSCredentialsSource(region string) (*awsCredentialsSource, error) {
+	if accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); accessKey != "" && secretKey != "" {
+		return &awsCredentialsSource{static: &awsCredentials{
+			accessKeyID:     accessKey,
+			secretAccessKey: secretKey,
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}}, nil
+	}
+	roleARN, tokenFile := os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE must be set")
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = defaultAWSRoleSessionName
+	}
+	stsEndpoint := "https://sts.amazonaws.com"
+	if region != "" && region != "auto" {
+		stsEndpoint = "https://sts." + region + ".amazonaws.com"
+	}
+	return &awsCredentialsSource{
+		roleARN:     roleARN,
+		tokenFile:   tokenFile,
+		sessionName: sessionName,
+		stsEndpoint: stsEndpoint,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// get returns credentials valid at now.
+func (s *awsCredentialsSource) get(now time.Time) (awsCredentials, error) {
+	if s.static != nil {
+		return *s.static, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached.accessKeyID != "" && now.Add(awsCredentialsRefreshMargin).Before(s.cached.expires) {
+		return s.cached, nil
+	}
+	credentials, err := s.assumeRoleWithWebIdentity()
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("assuming role %s with web identity: %w", s.roleARN, err)
+	}
+	s.cached = credentials
+	return credentials, nil
+}
+
+// assumeRoleWithWebIdentity exchanges the web identity token for temporary
+// credentials. The STS call itself is authenticated by the token and not
+// signed.
+func (s *awsCredentialsSource) assumeRoleWithWebIdentity() (awsCredentials, error) {
+	token, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {s.roleARN},
+		"RoleSessionName":  {s.sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := s.client.PostForm(s.stsEndpoint, form)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("STS returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &response); err != nil {
+		return awsCredentials{}, fmt.Errorf("parsing STS response: %w", err)
+	}
+	if response.Credentials.AccessKeyID == "" {
+		return awsCredentials{}, errors.New("STS response has no credentials")
+	}
+	return awsCredentials{
+		accessKeyID:     response.Credentials.AccessKeyID,
+		secretAccessKey: response.Credentials.SecretAccessKey,
+		sessionToken:    response.Credentials.SessionToken,
+		expires:         response.Credentials.Expiration,
+	}, nil
+}
+
+// signV4 adds the AWS Signature Version 4 headers for the service to req.
+// The host and all X-Amz-* headers are signed, including the session token
+// of temporary credentials. S3 additionally expects X-Amz-Content-Sha256 to
+// be set to payloadHash before signing.
+func signV4(req *http.Request, payloadHash string, credentials awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.RawQuery),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.accessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the SigV4 canonical query string: the parameters
+// sorted by name and value, each encoded with uriEncode and with an empty
+// value where it has none.
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	var params []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncodePath percent-encodes everything but unreserved characters and
+// slashes, as SigV4 expects of the canonical URI (url.URL leaves e.g. = as
+// is).
+func uriEncodePath(path string) string {
+	return uriEncode(path, false)
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
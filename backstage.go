@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Annotations mapping deployments to Backstage components
+const (
+	ownerAnnotation     = "deployment-exporter/owner"
+	componentAnnotation = "deployment-exporter/component"
+)
+
+// backstageEntity is a Backstage catalog Component entity.
+type backstageEntity struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   backstageMetadata `json:"metadata"`
+	Spec       backstageSpec     `json:"spec"`
+}
+
+type backstageMetadata struct {
+	Name        string            `json:"name"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type backstageSpec struct {
+	Type      string `json:"type"`
+	Lifecycle string `json:"lifecycle"`
+	Owner     string `json:"owner"`
+}
+
+// backstageEntities maps the tracked deployments to Backstage components
+// carrying their live health in annotations. The component name defaults
+// to the deployment name and the owner to "unknown".
+func (t *DeploymentTracker) backstageEntities() ([]backstageEntity, error) {
+	deployments, err := t.trackedDeployments()
+	if err != nil {
+		return nil, err
+	}
+	down := t.downDeployments()
+
+	entities := make([]backstageEntity, 0, len(deployments))
+	for _, deployment := range deployments {
+		name := deployment.Name
+		if component := deployment.Annotations[componentAnnotation]; component != "" {
+			name = component
+		}
+		owner := "unknown"
+		if value := deployment.Annotations[ownerAnnotation]; value != "" {
+			owner = value
+		}
+		health := "up"
+		if down[deployment.Namespace+"/"+deployment.Name] {
+			health = "down"
+		}
+		desired := int32(0)
+		if deployment.Spec.Replicas != nil {
+			desired = *deployment.Spec.Replicas
+		}
+
+		entities = append(entities, backstageEntity{
+			APIVersion: "backstage.io/v1alpha1",
+			Kind:       "Component",
+			Metadata: backstageMetadata{
+				Name: name,
+				Annotations: map[string]string{
+					"backstage.io/kubernetes-id":        deployment.Name,
+					"backstage.io/kubernetes-namespace": deployment.Namespace,
+					"deployment-exporter/health":        health,
+					"deployment-exporter/replicas":      fmt.Sprintf("%d/%d", deployment.Status.ReadyReplicas, desired),
+				},
+			},
+			Spec: backstageSpec{
+				Type:      "service",
+				Lifecycle: "production",
+				Owner:     owner,
+			},
+		})
+	}
+	sort.Slice(entities, func(i, j int) bool { return entities[i].Metadata.Name < entities[j].Metadata.Name })
+	return entities, nil
+}
+
+// handleBackstage serves GET /api/v1/backstage as multi-document YAML a
+// Backstage catalog location can point to, or as JSON with ?format=json.
+func (t *DeploymentTracker) handleBackstage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entities, err := t.backstageEntities()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "yaml":
+		var b bytes.Buffer
+		for _, entity := range entities {
+			doc, err := yaml.Marshal(entity)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			b.WriteString("---\n")
+			b.Write(doc)
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(b.Bytes())
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entities)
+	default:
+		http.Error(w, fmt.Sprintf("invalid format %q, must be yaml or json", format), http.StatusBadRequest)
+	}
+}
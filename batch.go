@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventBatcher logs and notifies deployment events, collapsing mass events:
+// once more than threshold deployments of a namespace changed state the same
+// way within window, the rest are suppressed and one summary is emitted when
+// the window ends. The details stay available through the incidents API.
+type eventBatcher struct {
+	window     time.Duration
+	threshold  int
+	dispatcher *dispatcher
+
+	mu       sync.Mutex
+	batches  map[string]*eventBatch
+	reported map[string]bool // type/namespace/deployment of emitted events that get resolved later
+}
+
+type eventBatch struct {
+	count      int
+	suppressed int
+}
+
+func newEventBatcher(window time.Duration, threshold int, dispatcher *dispatcher) *eventBatcher {
+	return &eventBatcher{
+		window:     window,
+		threshold:  threshold,
+		dispatcher: dispatcher,
+		batches:    make(map[string]*eventBatch),
+		reported:   make(map[string]bool),
+	}
+}
+
+// forget drops the events reported for a deleted deployment.
+func (b *eventBatcher) forget(ns, name string) {
+	if b == nil {
+		return
+	}
+	key := ns + "/" + name
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for reported := range b.reported {
+		if _, deployment, _ := strings.Cut(reported, "/"); deployment == key {
+			delete(b.reported, reported)
+		}
+	}
+}
+
+func (b *eventBatcher) add(ev event) {
+	if b.threshold <= 0 {
+		b.emit(ev)
+		return
+	}
+
+	// Recoveries of individually reported downtimes (and the like) are
+	// always reported, so alerts opened for them get closed
+	deployment := ev.Namespace + "/" + ev.Deployment
+	b.mu.Lock()
+	if opened, ok := resolvedEvents[ev.Type]; ok && b.reported[opened+"/"+deployment] {
+		delete(b.reported, opened+"/"+deployment)
+		b.mu.Unlock()
+		b.emit(ev)
+		return
+	}
+
+	key := ev.Type + "/" + ev.Namespace
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &eventBatch{}
+		b.batches[key] = batch
+		time.AfterFunc(b.window, func() { b.flush(key, ev) })
+	}
+	batch.count++
+	suppress := batch.count > b.threshold
+	if suppress {
+		batch.suppressed++
+	} else if _, ok := resolvedEvents[ev.Type]; !ok {
+		b.reported[ev.Type+"/"+deployment] = true
+	}
+	b.mu.Unlock()
+
+	if !suppress {
+		b.emit(ev)
+	}
+}
+
+// flush closes the batch window and emits the summary if events were
+// suppressed.
+func (b *eventBatcher) flush(key string, first event) {
+	b.mu.Lock()
+	batch := b.batches[key]
+	delete(b.batches, key)
+	b.mu.Unlock()
+
+	if batch == nil || batch.suppressed == 0 {
+		return
+	}
+	verb := eventVerbs[first.Type]
+	b.emit(event{
+		Type:      first.Type,
+		Namespace: first.Namespace,
+		Time:      time.Now(),
+		Count:     batch.count,
+		Message: fmt.Sprintf("%d deployments %s in namespace %s within %s (%d not logged individually, see /api/v1/incidents)",
+			batch.count, verb, first.Namespace, b.window, batch.suppressed),
+	})
+}
+
+// emit logs an event and passes it on to the notifiers.
+func (b *eventBatcher) emit(ev event) {
+	// Display time in WIB (UTC+7)
+	wibTime := ev.Time.UTC().Add(7 * time.Hour).Format("2006/01/02 15:04:05")
+	log.Printf("[%s WIB] %s", wibTime, ev.Message)
+	b.dispatcher.send(ev)
+}
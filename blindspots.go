@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Number of blind spots kept for availability calculations
+const maxBlindSpots = 1000
+
+var (
+	// Time the exporter couldn't observe the deployment, which is neither
+	// uptime nor downtime
+	deploymentUnmonitoredSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_deployment_unmonitored_seconds_total",
+			Help: "Total seconds the deployment was not monitored because the exporter was down or couldn't list deployments",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// blindSpot is a period in which the exporter had no heartbeat, i.e. no
+// successful scrape cycle.
+type blindSpot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// blindSpots detects gaps in the exporter's own heartbeat: successful
+// scrape cycles further apart than threshold, or the time between a state
+// snapshot and the first cycle of the instance importing it.
+type blindSpots struct {
+	threshold time.Duration
+
+	mu    sync.Mutex
+	last  time.Time
+	spots []blindSpot // oldest first
+}
+
+func newBlindSpots(threshold time.Duration) *blindSpots {
+	return &blindSpots{threshold: threshold}
+}
+
+// seed sets the last heartbeat, e.g. to the time of an imported snapshot.
+func (b *blindSpots) seed(last time.Time, spots []blindSpot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = last
+	b.spots = append(b.spots, spots...)
+}
+
+// beat records a heartbeat and returns the blind spot it ended, if any.
+func (b *blindSpots) beat(now time.Time) (blindSpot, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	last := b.last
+	b.last = now
+	if last.IsZero() || now.Sub(last) <= b.threshold {
+		return blindSpot{}, false
+	}
+	spot := blindSpot{Start: last, End: now}
+	b.spots = append(b.spots, spot)
+	if len(b.spots) > maxBlindSpots {
+		b.spots = b.spots[len(b.spots)-maxBlindSpots:]
+	}
+	return spot, true
+}
+
+// list returns a copy of the blind spots, oldest first.
+func (b *blindSpots) list() []blindSpot {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]blindSpot(nil), b.spots...)
+}
+
+// overlap returns how much of [start, end) the exporter was blind for.
+func (b *blindSpots) overlap(start, end time.Time) time.Duration {
+	var blind time.Duration
+	for _, spot := range b.list() {
+		blind += overlapDuration(start, end, spot.Start, spot.End)
+	}
+	return blind
+}
+
+// heartbeat records a successful scrape cycle over the listed deployments
+// and attributes a preceding gap to the tracked ones as unmonitored time.
+func (t *DeploymentTracker) heartbeat(now time.Time, deployments []appsv1.Deployment) {
+	if t.blindSpots == nil {
+		return
+	}
+	spot, ok := t.blindSpots.beat(now)
+	if !ok {
+		return
+	}
+	gap := spot.End.Sub(spot.Start)
+	log.Printf("Warning: No successful scrape cycle between %s and %s (%s), counting it as unmonitored",
+		spot.Start.Format(time.RFC3339), spot.End.Format(time.RFC3339), gap.Round(time.Second))
+	for _, d := range deployments {
+		if t.ownsDeployment(d.Namespace, d.Name) {
+			deploymentUnmonitoredSeconds.WithLabelValues(d.Namespace, d.Name).Add(gap.Seconds())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Annotations declaring a deployment as one side of a blue/green pair that
+// is switched by changing the selector of a Service
+const (
+	blueGreenServiceAnnotation = "deployment-exporter/blue-green-service"
+	blueGreenColorAnnotation   = "deployment-exporter/color"
+)
+
+var (
+	// Which side of a blue/green pair the Service currently selects
+	deploymentReceivingTraffic = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_receiving_traffic",
+			Help: "Whether the blue/green Service selector currently selects the deployment's pods (1 = receiving traffic)",
+		},
+		[]string{"namespace", "deployment", "color"},
+	)
+)
+
+func validateBlueGreenService(value string) error {
+	if errs := validation.IsDNS1035Label(value); len(errs) > 0 {
+		return fmt.Errorf("invalid service name %q: %v", value, errs)
+	}
+	return nil
+}
+
+func validateBlueGreenColor(value string) error {
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 || value == "" {
+		return fmt.Errorf("invalid color %q: must be a non-empty label value", value)
+	}
+	return nil
+}
+
+// collectTrafficMetrics reports whether the blue/green Service routes to the
+// deployment, i.e. whether the Service selector matches its pod template
+// labels. The color defaults to the deployment name. Nothing is reported
+// until the Service cache synced.
+func (t *DeploymentTracker) collectTrafficMetrics(deployment *appsv1.Deployment) {
+	serviceName, ok := deployment.Annotations[blueGreenServiceAnnotation]
+	if !ok || serviceName == "" {
+		return
+	}
+	ns := deployment.Namespace
+	services := t.cachesFor(ns).services
+	if services == nil || !services.HasSynced() {
+		return
+	}
+	name := deployment.Name
+	color := deployment.Annotations[blueGreenColorAnnotation]
+	if color == "" {
+		color = name
+	}
+
+	receiving := float64(0)
+	obj, exists, err := services.GetIndexer().GetByKey(ns + "/" + serviceName)
+	if err != nil || !exists {
+		debugf("Blue/green service %s/%s of deployment %s not found", ns, serviceName, name)
+	} else {
+		service := obj.(*corev1.Service)
+		// An empty selector selects no pods (endpoints are managed manually)
+		if len(service.Spec.Selector) > 0 &&
+			labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(deployment.Spec.Template.Labels)) {
+			receiving = 1
+		}
+	}
+	deploymentReceivingTraffic.WithLabelValues(ns, name, color).Set(receiving)
+}
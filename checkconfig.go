@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// annotationValidators check the syntax of deployment-exporter annotations.
+var annotationValidators = map[string]func(value string) error{
+	podSelectorAnnotation: func(value string) error {
+		_, err := labels.Parse(value)
+		return err
+	},
+	blueGreenServiceAnnotation: validateBlueGreenService,
+	blueGreenColorAnnotation:   validateBlueGreenColor,
+	autoRollbackAnnotation: func(value string) error {
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false, got %q", value)
+		}
+		return nil
+	},
+	minAvailableAnnotation:    validateMinAvailable,
+	replicaScheduleAnnotation: validateReplicaSchedule,
+	dependsOnAnnotation:       validateDependsOn,
+
+	memoryAlertThresholdAnnotation: validateAlertThreshold,
+	cpuAlertThresholdAnnotation:    validateAlertThreshold,
+	globalServiceAnnotation:        validateGlobalService,
+	identityAnnotation:             validateIdentity,
+	logicalServiceAnnotation:       validateLogicalService,
+}
+
+// runCheckConfig implements `check-config`: it validates a config file (and
+// optionally the annotations on the cluster's deployments) and returns the
+// process exit code, so bad configs fail CI before rollout.
+func runCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	opts := &options{}
+	opts.bindFlags(fs)
+	checkAnnotations := fs.Bool("check-annotations", false, "Also validate deployment-exporter annotations on deployments in the cluster (uses --kubeconfig)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if opts.configFile == "" {
+		fmt.Fprintln(os.Stderr, "check-config: --config is required")
+		return 2
+	}
+
+	var errs []error
+	if err := loadConfigFile(fs, opts.configFile); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := loadMetricOverrides(opts.configFile); err != nil {
+		errs = append(errs, err)
+	}
+	if err := opts.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if *checkAnnotations {
+		if err := checkClusterAnnotations(opts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid configuration:\n%v\n", opts.configFile, err)
+		return 1
+	}
+	fmt.Printf("%s: OK\n", opts.configFile)
+	return 0
+}
+
+// checkClusterAnnotations validates the annotations of every deployment the
+// configured exporter would track.
+func checkClusterAnnotations(opts *options) error {
+	config, err := getKubeConfig(opts.kubeconfig, opts.kubeContext)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes config: %w", err)
+	}
+	config, err = applyCredentials(config, opts)
+	if err != nil {
+		return fmt.Errorf("applying credentials: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	tenants, err := newTenantClients(config, opts)
+	if err != nil {
+		return fmt.Errorf("creating namespace clients: %w", err)
+	}
+
+	clientsetFor := func(namespace string) kubernetes.Interface { return tenants.clientsetFor(namespace, clientset) }
+	deployments, failed := listDeployments(context.Background(), clientsetFor, opts.trackedNamespaces())
+	if err := listError(failed); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, deployment := range deployments {
+		for annotation, validate := range annotationValidators {
+			value, ok := deployment.Annotations[annotation]
+			if !ok {
+				continue
+			}
+			if err := validate(value); err != nil {
+				errs = append(errs, fmt.Errorf("deployment %s/%s: annotation %s: %w", deployment.Namespace, deployment.Name, annotation, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
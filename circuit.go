@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Metrics API circuit breaker state
+	metricsAPICircuitOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "exporter_metrics_api_circuit_open",
+			Help: "Whether the metrics-server circuit breaker is open (1=open, usage collection skipped, 0=closed)",
+		},
+	)
+)
+
+// circuitBreaker stops calling a flaky dependency after a number of
+// consecutive failures and lets a single trial call through once the
+// cooldown has elapsed.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	halfOpen  bool
+	gauge     prometheus.Gauge
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, gauge prometheus.Gauge) *circuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	gauge.Set(0)
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		gauge:     gauge,
+	}
+}
+
+// Allow reports whether a call may be made right now.
+func (c *circuitBreaker) Allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.halfOpen {
+		return false
+	}
+	if c.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(c.openUntil) {
+		return false
+	}
+
+	// Cooldown elapsed: half-open, let one trial call through. Any failure
+	// re-opens the circuit immediately.
+	c.openUntil = time.Time{}
+	c.halfOpen = true
+	return true
+}
+
+// Success records a successful call and closes the circuit.
+func (c *circuitBreaker) Success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.halfOpen {
+		log.Printf("Metrics API circuit closed")
+	}
+	c.failures = 0
+	c.halfOpen = false
+	c.gauge.Set(0)
+}
+
+// Failure records a failed call and opens the circuit once the threshold of
+// consecutive failures is reached.
+func (c *circuitBreaker) Failure(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures++
+	if !c.openUntil.IsZero() || (c.failures < c.threshold && !c.halfOpen) {
+		return
+	}
+
+	c.halfOpen = false
+	c.openUntil = time.Now().Add(c.cooldown)
+	c.gauge.Set(1)
+	log.Printf("Metrics API circuit opened after %d consecutive failures, skipping usage collection for %s: %v", c.failures, c.cooldown, err)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Reason of the event the cluster-autoscaler records on a pending pod it
+// adds nodes for
+const scaleUpEventReason = "TriggeredScaleUp"
+
+// Incident cause of deployments with pods waiting for new nodes, which
+// groups the incidents of one slow scale-up
+const nodeCapacityCause = "node-capacity"
+
+var (
+	// Pending pods the cluster-autoscaler is provisioning nodes for, so slow
+	// recoveries can be attributed to infrastructure rather than the
+	// application
+	deploymentWaitingForNodeCapacity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_waiting_for_node_capacity",
+			Help: "Number of the deployment's pending pods that triggered a cluster-autoscaler scale-up and aren't scheduled yet",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentNodeScaleUps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_deployment_node_scale_ups_triggered_total",
+			Help: "Pods of the deployment that triggered a cluster-autoscaler scale-up",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentNodeCapacitySeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "k8s_deployment_node_capacity_wait_seconds",
+			Help:    "Time in seconds from a pod triggering a cluster-autoscaler scale-up until it was scheduled",
+			Buckets: []float64{10, 30, 60, 90, 120, 180, 300, 600, 900, 1800},
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// capacityWait is a pending pod waiting for the nodes of a scale-up.
+type capacityWait struct {
+	deployment string // namespace/deployment
+	since      time.Time
+}
+
+// capacityWaits tracks the pods waiting for cluster-autoscaler scale-ups,
+// from the TriggeredScaleUp event until the pod is scheduled.
+type capacityWaits struct {
+	mu      sync.Mutex
+	waiting map[types.UID]capacityWait
+}
+
+func newCapacityWaits() *capacityWaits {
+	return &capacityWaits{waiting: make(map[types.UID]capacityWait)}
+}
+
+// startScaleUpInformer watches the namespace's TriggeredScaleUp events.
+func (t *DeploymentTracker) startScaleUpInformer(namespace string, stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(t.clientsetFor(namespace), 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = "reason=" + scaleUpEventReason
+		}))
+	informer := factory.Core().V1().Events().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    t.onScaleUpEvent,
+		UpdateFunc: func(_, obj interface{}) { t.onScaleUpEvent(obj) },
+	}); err != nil {
+		log.Fatalf("Error adding scale-up event handler: %v", err)
+	}
+	factory.Start(stopCh)
+}
+
+// onScaleUpEvent starts the wait of the pod the event is about, unless it
+// is already scheduled or waiting.
+func (t *DeploymentTracker) onScaleUpEvent(obj interface{}) {
+	ev, ok := obj.(*corev1.Event)
+	if !ok || ev.Reason != scaleUpEventReason || ev.InvolvedObject.Kind != "Pod" {
+		return
+	}
+	podObj, exists, err := t.cachesFor(ev.Namespace).pods.GetIndexer().GetByKey(ev.Namespace + "/" + ev.InvolvedObject.Name)
+	if err != nil || !exists {
+		return
+	}
+	pod := podObj.(*corev1.Pod)
+	if pod.UID != ev.InvolvedObject.UID || pod.Spec.NodeName != "" {
+		return
+	}
+	ns, name, ok := t.podDeployment(pod)
+	if !ok || !t.ownsDeployment(ns, name) {
+		return
+	}
+
+	since := ev.FirstTimestamp.Time
+	if since.IsZero() {
+		since = ev.EventTime.Time
+	}
+	if since.IsZero() {
+		since = ev.CreationTimestamp.Time
+	}
+
+	t.capacity.mu.Lock()
+	defer t.capacity.mu.Unlock()
+	if _, waiting := t.capacity.waiting[pod.UID]; waiting {
+		return
+	}
+	t.capacity.waiting[pod.UID] = capacityWait{deployment: ns + "/" + name, since: since}
+	deploymentNodeScaleUps.WithLabelValues(ns, name).Inc()
+	debugf("Pod %s/%s of deployment %s triggered a node scale-up", pod.Namespace, pod.Name, name)
+}
+
+// podScheduled ends the pod's wait for node capacity, if it had one.
+func (c *capacityWaits) podScheduled(pod *corev1.Pod, ns, name string, at time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wait, ok := c.waiting[pod.UID]
+	if !ok {
+		return
+	}
+	delete(c.waiting, pod.UID)
+	if at.After(wait.since) {
+		deploymentNodeCapacitySeconds.WithLabelValues(ns, name).Observe(at.Sub(wait.since).Seconds())
+	}
+}
+
+// podDeleted drops the wait of a pod deleted before it was scheduled.
+func (c *capacityWaits) podDeleted(pod *corev1.Pod) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.waiting, pod.UID)
+}
+
+// waitingPods returns the number of the deployment's pods waiting for node
+// capacity.
+func (c *capacityWaits) waitingPods(key string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	for _, wait := range c.waiting {
+		if wait.deployment == key {
+			count++
+		}
+	}
+	return count
+}
+
+// collectCapacityMetrics reports the deployment's pods waiting for node
+// capacity.
+func (t *DeploymentTracker) collectCapacityMetrics(ns, name string, gauges *deploymentGauges) {
+	if t.capacity == nil {
+		return
+	}
+	gauges.gauge(deploymentWaitingForNodeCapacity).Set(float64(t.capacity.waitingPods(ns + "/" + name)))
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Annotation naming the global service a deployment serves in every
+// cluster it runs in
+const globalServiceAnnotation = "deployment-exporter/global-service"
+
+var (
+	// Same deployment running different images across clusters, e.g. a
+	// multi-region rollout that stopped halfway
+	deploymentVersionSkew = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_version_skew",
+			Help: "Whether the deployment runs different container images in the peer clusters it also runs in (1 = skew)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	exporterPeerClusterUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_peer_cluster_up",
+			Help: "Whether the last sync with the peer cluster's exporter succeeded (1 = up)",
+		},
+		[]string{"cluster"},
+	)
+
+	// Global services down everywhere, i.e. with user impact rather than a
+	// regional event
+	globalServiceDown = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_global_service_down",
+			Help: "Whether the global service is down in all participating clusters (1 = down everywhere)",
+		},
+		[]string{"service"},
+	)
+
+	globalServiceClustersDown = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_global_service_clusters_down",
+			Help: "Number of participating clusters in which a deployment of the global service is down",
+		},
+		[]string{"service"},
+	)
+
+	globalServiceClusters = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_global_service_clusters",
+			Help: "Number of reachable clusters running deployments of the global service",
+		},
+		[]string{"service"},
+	)
+)
+
+// clusterDeployment is a deployment as one cluster's exporter reports it to
+// its peers.
+type clusterDeployment struct {
+	Namespace  string            `json:"namespace"`
+	Deployment string            `json:"deployment"`
+	Images     map[string]string `json:"images"` // container -> image
+	Down       bool              `json:"down"`
+	Service    string            `json:"globalService,omitempty"` // global-service annotation
+}
+
+// clusterDeployments is the response of /api/v1/deployments.
+type clusterDeployments struct {
+	Cluster     string              `json:"cluster"`
+	Deployments []clusterDeployment `json:"deployments"`
+}
+
+// versionSkew is a deployment whose images differ between clusters.
+type versionSkew struct {
+	Namespace  string                       `json:"namespace"`
+	Deployment string                       `json:"deployment"`
+	Images     map[string]map[string]string `json:"images"` // cluster -> container -> image
+}
+
+func validateGlobalService(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+// parsePeerClusters parses comma-separated name=url pairs.
+func parsePeerClusters(list string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, pair := range splitList(list) {
+		name, url, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("invalid peer %q, must be name=http(s)://host:port", pair)
+		}
+		peers[name] = strings.TrimSuffix(url, "/")
+	}
+	return peers, nil
+}
+
+// localDeployments reports the tracked deployments of this cluster.
+func (t *DeploymentTracker) localDeployments() ([]clusterDeployment, error) {
+	deployments, err := t.trackedDeployments()
+	if err != nil {
+		return nil, err
+	}
+	down := t.downDeployments()
+	result := make([]clusterDeployment, 0, len(deployments))
+	for i := range deployments {
+		d := &deployments[i]
+		result = append(result, clusterDeployment{
+			Namespace:  d.Namespace,
+			Deployment: d.Name,
+			Images:     deploymentImages(d),
+			Down:       down[d.Namespace+"/"+d.Name],
+			Service:    d.Annotations[globalServiceAnnotation],
+		})
+	}
+	return result, nil
+}
+
+// deploymentImages maps the deployment's containers to their images.
+func deploymentImages(d *appsv1.Deployment) map[string]string {
+	images := make(map[string]string)
+	for _, container := range d.Spec.Template.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+	return images
+}
+
+// handleDeployments serves GET /api/v1/deployments, which peer clusters'
+// exporters poll in multi-cluster mode.
+func (t *DeploymentTracker) handleDeployments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deployments, err := t.localDeployments()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cluster := ""
+	if t.peers != nil {
+		cluster = t.peers.cluster
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clusterDeployments{Cluster: cluster, Deployments: deployments})
+}
+
+// peerClusters polls the exporters of the other clusters and compares their
+// deployments with the local ones.
+type peerClusters struct {
+	tracker *DeploymentTracker
+	cluster string
+	peers   map[string]string // name -> exporter URL
+	token   string
+	client  *http.Client
+
+	mu        sync.Mutex
+	snapshots map[string][]clusterDeployment // cluster -> deployments, including the local one
+	skews     []versionSkew
+	published map[string]bool // namespace/deployment keys with a skew series
+	services  map[string]bool // global services with series
+}
+
+func newPeerClusters(tracker *DeploymentTracker, opts *options) *peerClusters {
+	peers, _ := parsePeerClusters(opts.peerClusters)
+	return &peerClusters{
+		tracker:   tracker,
+		cluster:   opts.clusterName,
+		peers:     peers,
+		token:     opts.peerToken,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		snapshots: make(map[string][]clusterDeployment),
+		published: make(map[string]bool),
+		services:  make(map[string]bool),
+	}
+}
+
+func (p *peerClusters) run(interval time.Duration) {
+	for {
+		p.sync()
+		time.Sleep(interval)
+	}
+}
+
+// sync refreshes the snapshots of all clusters. The last snapshot of an
+// unreachable peer is dropped rather than compared, as it may be outdated.
+func (p *peerClusters) sync() {
+	snapshots := make(map[string][]clusterDeployment, len(p.peers)+1)
+	local, err := p.tracker.localDeployments()
+	if err != nil {
+		log.Printf("Error listing local deployments for peer comparison: %v", err)
+		return
+	}
+	snapshots[p.cluster] = local
+
+	for name, url := range p.peers {
+		deployments, err := p.fetch(url)
+		if err != nil {
+			log.Printf("Error syncing peer cluster %s (%s): %v", name, url, err)
+			exporterPeerClusterUp.WithLabelValues(name).Set(0)
+			continue
+		}
+		exporterPeerClusterUp.WithLabelValues(name).Set(1)
+		snapshots[name] = deployments
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snapshots = snapshots
+	p.updateVersionSkew()
+	p.updateGlobalServices()
+}
+
+func (p *peerClusters) fetch(url string) ([]clusterDeployment, error) {
+	req, err := http.NewRequest(http.MethodGet, url+"/api/v1/deployments", nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned %s", resp.Status)
+	}
+	var response clusterDeployments
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decoding deployments: %w", err)
+	}
+	return response.Deployments, nil
+}
+
+// updateVersionSkew compares the images of the local deployments with the
+// same namespace/deployment in the peer clusters. Deployments that only run
+// in one cluster have no skew series. Called with p.mu held.
+func (p *peerClusters) updateVersionSkew() {
+	images := make(map[string]map[string]map[string]string) // namespace/deployment -> cluster -> images
+	for cluster, deployments := range p.snapshots {
+		for _, d := range deployments {
+			key := d.Namespace + "/" + d.Deployment
+			if images[key] == nil {
+				images[key] = make(map[string]map[string]string)
+			}
+			images[key][cluster] = d.Images
+		}
+	}
+
+	var skews []versionSkew
+	current := make(map[string]bool)
+	for _, d := range p.snapshots[p.cluster] {
+		key := d.Namespace + "/" + d.Deployment
+		clusters := images[key]
+		if len(clusters) < 2 {
+			continue
+		}
+		current[key] = true
+		skewed := false
+		for _, other := range clusters {
+			if !sameImages(d.Images, other) {
+				skewed = true
+				break
+			}
+		}
+		value := float64(0)
+		if skewed {
+			value = 1
+			skews = append(skews, versionSkew{Namespace: d.Namespace, Deployment: d.Deployment, Images: clusters})
+		}
+		deploymentVersionSkew.WithLabelValues(d.Namespace, d.Deployment).Set(value)
+	}
+	for key := range p.published {
+		if !current[key] {
+			namespace, name, _ := strings.Cut(key, "/")
+			deploymentVersionSkew.DeleteLabelValues(namespace, name)
+		}
+	}
+	p.published = current
+
+	sort.Slice(skews, func(i, j int) bool {
+		a, b := skews[i], skews[j]
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Deployment < b.Deployment
+	})
+	p.skews = skews
+}
+
+// updateGlobalServices reports each global service as down only while it is
+// down in every cluster running it. A service is down in a cluster when any
+// of its deployments there is down. Called with p.mu held.
+func (p *peerClusters) updateGlobalServices() {
+	down := make(map[string]map[string]bool) // service -> cluster -> down
+	for cluster, deployments := range p.snapshots {
+		for _, d := range deployments {
+			if d.Service == "" {
+				continue
+			}
+			if down[d.Service] == nil {
+				down[d.Service] = make(map[string]bool)
+			}
+			down[d.Service][cluster] = down[d.Service][cluster] || d.Down
+		}
+	}
+
+	current := make(map[string]bool, len(down))
+	for service, clusters := range down {
+		current[service] = true
+		clustersDown := 0
+		for _, isDown := range clusters {
+			if isDown {
+				clustersDown++
+			}
+		}
+		value := float64(0)
+		if clustersDown == len(clusters) {
+			value = 1
+		}
+		globalServiceDown.WithLabelValues(service).Set(value)
+		globalServiceClustersDown.WithLabelValues(service).Set(float64(clustersDown))
+		globalServiceClusters.WithLabelValues(service).Set(float64(len(clusters)))
+	}
+	for service := range p.services {
+		if !current[service] {
+			globalServiceDown.DeleteLabelValues(service)
+			globalServiceClustersDown.DeleteLabelValues(service)
+			globalServiceClusters.DeleteLabelValues(service)
+		}
+	}
+	p.services = current
+}
+
+func sameImages(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for container, image := range a {
+		if b[container] != image {
+			return false
+		}
+	}
+	return true
+}
+
+// handleVersionSkew serves GET /api/v1/version-skew[?namespace=X]: the
+// deployments whose images differ between clusters as of the last sync.
+func (p *peerClusters) handleVersionSkew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if p == nil {
+		http.Error(w, "Multi-cluster mode is not enabled (--peer-clusters).", http.StatusNotFound)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+
+	p.mu.Lock()
+	result := make([]versionSkew, 0, len(p.skews))
+	for _, skew := range p.skews {
+		if namespace == "" || skew.Namespace == namespace {
+			result = append(result, skew)
+		}
+	}
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collection steps reported by k8s_deployment_collection_error
+const (
+	collectionErrorReplicaSets = "replicaset_list"
+	collectionErrorPods        = "pod_list"
+	collectionErrorMetricsAPI  = "metrics_api"
+)
+
+var (
+	// Failed collection steps, so missing or zero values can be told apart
+	// from ones that couldn't be measured
+	deploymentCollectionError = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_collection_error",
+			Help: "Whether the last attempt of a collection step for the deployment failed (1 = failed); metrics_api is also 1 while usage is not queried (circuit open)",
+		},
+		[]string{"namespace", "deployment", "error_type"},
+	)
+)
+
+// setCollectionError records whether a collection step for a deployment
+// failed.
+func setCollectionError(namespace, deploymentName, errorType string, failed bool) {
+	value := float64(0)
+	if failed {
+		value = 1
+	}
+	deploymentCollectionError.WithLabelValues(namespace, deploymentName, errorType).Set(value)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Deployment condition status as a single series per condition, replacing
+// the status-labelled deploymentConditionStatus with --normalize-condition-metric
+var deploymentConditionState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "k8s_deployment_condition_status",
+		Help: "Deployment condition status (1=true, 0=false, -1=unknown)",
+	},
+	[]string{"namespace", "deployment", "condition"},
+)
+
+// registerConditionMetric registers the legacy or the normalized condition
+// metric. Both share a name, so only one of them can be registered.
+func registerConditionMetric(reg prometheus.Registerer, normalized bool) {
+	if normalized {
+		reg.MustRegister(deploymentConditionState)
+	} else {
+		reg.MustRegister(deploymentConditionStatus)
+	}
+}
+
+// conditionSeries remembers which conditions of each deployment have a
+// normalized series, so the series of conditions that disappeared from the
+// status (e.g. ReplicaFailure once resolved) can be deleted.
+type conditionSeries struct {
+	mu       sync.Mutex
+	exported map[string]map[string]bool // namespace/deployment -> condition types
+}
+
+func newConditionSeries() *conditionSeries {
+	return &conditionSeries{exported: make(map[string]map[string]bool)}
+}
+
+// forget drops the exported condition types of a deleted deployment.
+func (c *conditionSeries) forget(ns, name string) {
+	if c == nil {
+		return
+	}
+	key := ns + "/" + name
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.exported, key)
+}
+
+// update records the deployment's current condition types and returns the
+// ones that were exported before but are gone now.
+func (c *conditionSeries) update(key string, types map[string]bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var gone []string
+	for conditionType := range c.exported[key] {
+		if !types[conditionType] {
+			gone = append(gone, conditionType)
+		}
+	}
+	if len(types) == 0 {
+		delete(c.exported, key)
+	} else {
+		c.exported[key] = types
+	}
+	return gone
+}
+
+// conditionValue encodes a condition status as 1 (True), 0 (False) or -1
+// (Unknown).
+func conditionValue(status string) float64 {
+	switch status {
+	case "True":
+		return 1
+	case "False":
+		return 0
+	default: // "Unknown"
+		return -1
+	}
+}
+
+// collectConditionMetrics reports the deployment's conditions (Available,
+// Progressing, ReplicaFailure) and when they last transitioned.
+func (t *DeploymentTracker) collectConditionMetrics(deployment *appsv1.Deployment, gauges *deploymentGauges) {
+	ns, name := deployment.Namespace, deployment.Name
+	types := make(map[string]bool)
+	for _, condition := range deployment.Status.Conditions {
+		conditionType := string(condition.Type)
+		conditionStatus := string(condition.Status)
+		types[conditionType] = true
+
+		if t.conditions != nil {
+			gauges.labelled(deploymentConditionState, conditionType).Set(conditionValue(conditionStatus))
+		} else {
+			deploymentConditionStatus.WithLabelValues(ns, name, conditionType, conditionStatus).Set(conditionValue(conditionStatus))
+		}
+		if !condition.LastTransitionTime.IsZero() {
+			gauges.labelled(deploymentConditionLastTransition, conditionType).Set(float64(condition.LastTransitionTime.Unix()))
+		}
+	}
+
+	if t.conditions == nil {
+		return
+	}
+	gone := t.conditions.update(ns+"/"+name, types)
+	for _, conditionType := range gone {
+		deploymentConditionState.DeleteLabelValues(ns, name, conditionType)
+		deploymentConditionLastTransition.DeleteLabelValues(ns, name, conditionType)
+	}
+	if len(gone) > 0 {
+		// The cached children of the deleted series must not be reused
+		t.gauges.forget(ns, name)
+	}
+}
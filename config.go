@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// loadConfigFile applies settings from a YAML file to every flag that was
+// not given on the command line. Keys are flag names, lists are joined with
+// commas:
+//
+//	scrape-interval: 30
+//	sidecar-containers: [istio-proxy, linkerd-proxy]
+//
+// The metrics section is read by loadMetricOverrides.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if key == metricsConfigKey {
+			continue
+		}
+		if key == "config" || fs.Lookup(key) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+			continue
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := fs.Set(key, configValue(settings[key])); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value for %q: %w", path, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configValue renders a decoded YAML value the way it would be written on
+// the command line.
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, configValue(item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeLock keeps scrapes from observing a half-updated deployment. Updates
+// of different deployments hold it shared, so the watch and the periodic
+// scrape don't block each other; a scrape holds it exclusively and waits for
+// in-progress deployment updates to finish. As a waiting scrape also holds
+// up the updates that come after it, updates only hold it while setting
+// series, never across API or kubelet calls.
+var scrapeLock sync.RWMutex
+
+// consistentGatherer gathers while no deployment update is in progress, so
+// e.g. desired and ready replicas of a deployment come from the same update.
+type consistentGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g consistentGatherer) Gather() ([]*dto.MetricFamily, error) {
+	scrapeLock.Lock()
+	defer scrapeLock.Unlock()
+	return g.Gatherer.Gather()
+}
+
+// deploymentLocks serializes the updates of each deployment. The watch and
+// the periodic scrape may process the same deployment at the same time, and
+// both would e.g. start a downtime for it; updates of different deployments
+// still run concurrently.
+type deploymentLocks struct {
+	mu    sync.Mutex
+	locks map[string]*deploymentLock
+}
+
+type deploymentLock struct {
+	mu    sync.Mutex
+	users int // holders and waiters, the lock is dropped without any
+}
+
+func newDeploymentLocks() *deploymentLocks {
+	return &deploymentLocks{locks: make(map[string]*deploymentLock)}
+}
+
+// lock locks the deployment and returns the function unlocking it.
+func (l *deploymentLocks) lock(key string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &deploymentLock{}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// timeMap maps namespace/deployment keys to times, safe for concurrent use.
+type timeMap struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+func newTimeMap() *timeMap {
+	return &timeMap{times: make(map[string]time.Time)}
+}
+
+func (m *timeMap) get(key string) (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.times[key]
+	return t, ok
+}
+
+func (m *timeMap) set(key string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.times[key] = t
+}
+
+// remove deletes the key and reports whether it was present.
+func (m *timeMap) remove(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.times[key]
+	delete(m.times, key)
+	return ok
+}
+
+func (m *timeMap) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.times)
+}
+
+// all returns a copy of the map.
+func (m *timeMap) all() map[string]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := make(map[string]time.Time, len(m.times))
+	for k, v := range m.times {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The watch and the periodic scrape share these structures, so the tests
+// are meant to run with -race.
+const (
+	hammerKeys       = 8
+	hammerGoroutines = 16
+	hammerRounds     = 200
+)
+
+// TestDeploymentLocksSerializeUpdates has the watch and the scrape paths
+// observe the same deployments down at once and checks that each downtime
+// is started exactly once and no two updates of a deployment overlap.
+func TestDeploymentLocksSerializeUpdates(t *testing.T) {
+	locks := newDeploymentLocks()
+	downtimeStart := newTimeMap()
+	var inside [hammerKeys]int32
+	var starts [hammerKeys]int32
+
+	update := func(key int) {
+		name := fmt.Sprintf("default/app-%d", key)
+		unlock := locks.lock(name)
+		defer unlock()
+
+		if n := atomic.AddInt32(&inside[key], 1); n != 1 {
+			t.Errorf("%d concurrent updates of %s", n, name)
+		}
+		defer atomic.AddInt32(&inside[key], -1)
+
+		if _, down := downtimeStart.get(name); !down {
+			downtimeStart.set(name, time.Now())
+			atomic.AddInt32(&starts[key], 1)
+		}
+	}
+
+	// Half of the goroutines stand for the watch, half for the scrape
+	var wg sync.WaitGroup
+	for g := 0; g < 2*hammerGoroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < hammerRounds; round++ {
+				for key := 0; key < hammerKeys; key++ {
+					update(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for key := range starts {
+		if starts[key] != 1 {
+			t.Errorf("downtime of default/app-%d started %d times, want 1", key, starts[key])
+		}
+	}
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("%d deployment locks left after all updates finished", len(locks.locks))
+	}
+}
+
+// TestTimeMapConcurrentAccess mixes all timeMap operations of the watch,
+// the scrape and the API handlers.
+func TestTimeMapConcurrentAccess(t *testing.T) {
+	m := newTimeMap()
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for g := 0; g < hammerGoroutines; g++ {
+		wg.Add(3)
+		go func(g int) {
+			defer wg.Done()
+			for round := 0; round < hammerRounds; round++ {
+				key := fmt.Sprintf("default/app-%d", (g+round)%hammerKeys)
+				m.set(key, now.Add(time.Duration(round)*time.Second))
+				m.remove(key)
+			}
+		}(g)
+		go func(g int) {
+			defer wg.Done()
+			for round := 0; round < hammerRounds; round++ {
+				key := fmt.Sprintf("default/app-%d", (g+round)%hammerKeys)
+				if t0, ok := m.get(key); ok && t0.Before(now) {
+					t.Errorf("%s has time %s before any set", key, t0)
+				}
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < hammerRounds; round++ {
+				if all := m.all(); len(all) > hammerKeys {
+					t.Errorf("%d keys, want at most %d", len(all), hammerKeys)
+				}
+				if n := m.len(); n > hammerKeys {
+					t.Errorf("len %d, want at most %d", n, hammerKeys)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestConsistentGathererSeesWholeUpdates has each deployment update two
+// gauges together under the shared scrape lock, as processDeployment does,
+// while scrapes gather, and checks that no scrape sees one gauge of a
+// deployment updated without the other.
+func TestConsistentGathererSeesWholeUpdates(t *testing.T) {
+	desired := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_desired_replicas", Help: "Desired replicas"}, []string{"deployment"})
+	ready := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_ready_replicas", Help: "Ready replicas"}, []string{"deployment"})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(desired, ready)
+	gatherer := consistentGatherer{reg}
+
+	// One goroutine per deployment, as the deployment locks serialize the
+	// updates of each deployment
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+	for key := 0; key < hammerKeys; key++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for round := 0; !stop.Load(); round++ {
+				scrapeLock.RLock()
+				desired.WithLabelValues(name).Set(float64(round))
+				ready.WithLabelValues(name).Set(float64(round))
+				scrapeLock.RUnlock()
+			}
+		}(fmt.Sprintf("app-%d", key))
+	}
+	defer wg.Wait()
+	defer stop.Store(true)
+
+	for round := 0; round < hammerRounds; round++ {
+		families, err := gatherer.Gather()
+		if err != nil {
+			t.Fatalf("gather: %v", err)
+		}
+		values := make(map[string]map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				name := metric.Label[0].GetValue()
+				if values[name] == nil {
+					values[name] = make(map[string]float64)
+				}
+				values[name][family.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+		for name, gauges := range values {
+			if gauges["test_desired_replicas"] != gauges["test_ready_replicas"] {
+				t.Errorf("scrape saw desired %v and ready %v of %s from different updates", gauges["test_desired_replicas"], gauges["test_ready_replicas"], name)
+			}
+		}
+	}
+}
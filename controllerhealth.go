@@ -0,0 +1,227 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Controller health signals
+const (
+	signalGenerationLag      = "generation_lag"
+	signalDeadlineUnreported = "progress_deadline_unreported"
+	signalWatchDelay         = "watch_delay"
+)
+
+const (
+	// Time the controller gets to mark a passed progress deadline, as it
+	// only checks on its own resync
+	progressDeadlineSlack = time.Minute
+
+	// Window and minimum number of watch event delays the watch delay
+	// signal is computed from
+	watchDelayWindow  = 5 * time.Minute
+	watchDelaySamples = 3
+)
+
+var (
+	// The deployment controller itself misbehaving, which is escalated to
+	// the platform team rather than the application owners
+	controllerHealthSuspect = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "k8s_controller_health_suspect",
+			Help: "Whether the deployment controller appears unhealthy because any controller health signal fires (1 = suspect)",
+		},
+	)
+
+	controllerHealthSignal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_controller_health_signal",
+			Help: "Number of deployments (or for watch_delay, the median delay in seconds) currently over the threshold of each controller health signal, 0 if it doesn't fire",
+		},
+		[]string{"signal"},
+	)
+
+	exporterWatchEventDelay = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "exporter_watch_event_delay_seconds",
+			Help:    "Time from the deployment controller's last condition update to the exporter receiving the watch event carrying it",
+			Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+		},
+	)
+)
+
+// watchDelay is the delay of one watch event.
+type watchDelay struct {
+	at    time.Time
+	delay time.Duration
+}
+
+// controllerHealth combines symptoms no single application causes into a
+// cluster-level verdict on the deployment controller: spec changes it
+// doesn't observe, progress deadlines it doesn't mark as exceeded, and
+// status updates reaching the exporter late.
+type controllerHealth struct {
+	lagThreshold   time.Duration
+	delayThreshold time.Duration
+
+	mu         sync.Mutex
+	lastUpdate map[string]time.Time // namespace/deployment -> newest condition update seen
+	delays     []watchDelay         // oldest first
+}
+
+func newControllerHealth(lagThreshold, delayThreshold time.Duration) *controllerHealth {
+	return &controllerHealth{
+		lagThreshold:   lagThreshold,
+		delayThreshold: delayThreshold,
+		lastUpdate:     make(map[string]time.Time),
+	}
+}
+
+// forget drops the last update of a deleted deployment.
+func (c *controllerHealth) forget(ns, name string) {
+	if c == nil {
+		return
+	}
+	key := ns + "/" + name
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.lastUpdate, key)
+}
+
+// newestConditionUpdate returns the latest lastUpdateTime of the
+// deployment's conditions.
+func newestConditionUpdate(d *appsv1.Deployment) time.Time {
+	var newest time.Time
+	for _, condition := range d.Status.Conditions {
+		if condition.LastUpdateTime.After(newest) {
+			newest = condition.LastUpdateTime.Time
+		}
+	}
+	return newest
+}
+
+// observeEvent measures the delay of a watch event that carries a new
+// condition update. The first event of a deployment only seeds it, as its
+// conditions may have been updated long before the watch started.
+func (c *controllerHealth) observeEvent(d *appsv1.Deployment, received time.Time) {
+	if c == nil {
+		return
+	}
+	updated := newestConditionUpdate(d)
+	if updated.IsZero() {
+		return
+	}
+	key := d.Namespace + "/" + d.Name
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, seen := c.lastUpdate[key]
+	c.lastUpdate[key] = updated
+	if !seen || !updated.After(last) {
+		return
+	}
+	// Condition timestamps have second resolution
+	delay := received.Sub(updated)
+	if delay < 0 {
+		delay = 0
+	}
+	exporterWatchEventDelay.Observe(delay.Seconds())
+	c.delays = append(c.delays, watchDelay{at: received, delay: delay})
+}
+
+// medianDelay drops delays that left the window and returns the median of
+// the remaining ones, or false with too few of them to judge.
+func (c *controllerHealth) medianDelay(now time.Time) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := 0
+	for i < len(c.delays) && now.Sub(c.delays[i].at) > watchDelayWindow {
+		i++
+	}
+	c.delays = c.delays[i:]
+	if len(c.delays) < watchDelaySamples {
+		return 0, false
+	}
+	delays := make([]time.Duration, len(c.delays))
+	for i, d := range c.delays {
+		delays[i] = d.delay
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	return delays[len(delays)/2], true
+}
+
+// progressDeadlineUnreported reports whether the deployment's rollout made
+// no progress for longer than its progress deadline without the controller
+// marking the Progressing condition ProgressDeadlineExceeded.
+func progressDeadlineUnreported(d *appsv1.Deployment, now time.Time) bool {
+	if d.Spec.ProgressDeadlineSeconds == nil || d.Spec.Paused {
+		return false
+	}
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	// Only rollouts in progress are expected to make progress
+	if d.Status.UpdatedReplicas >= desired && d.Status.AvailableReplicas >= desired && d.Generation <= d.Status.ObservedGeneration {
+		return false
+	}
+	for _, condition := range d.Status.Conditions {
+		if condition.Type != appsv1.DeploymentProgressing {
+			continue
+		}
+		if condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded" {
+			return false
+		}
+		// The controller never times out a completed rollout that later
+		// lost replicas
+		if condition.Reason == "NewReplicaSetAvailable" {
+			return false
+		}
+		deadline := time.Duration(*d.Spec.ProgressDeadlineSeconds) * time.Second
+		return now.Sub(condition.LastUpdateTime.Time) > deadline+progressDeadlineSlack
+	}
+	return false
+}
+
+// updateControllerHealth evaluates the controller health signals over the
+// tracked deployments of a scrape cycle.
+func (t *DeploymentTracker) updateControllerHealth(now time.Time, deployments []appsv1.Deployment) {
+	c := t.controller
+	if c == nil {
+		return
+	}
+	signals := map[string]float64{
+		signalGenerationLag:      0,
+		signalDeadlineUnreported: 0,
+		signalWatchDelay:         0,
+	}
+	for i := range deployments {
+		d := &deployments[i]
+		if !t.ownsDeployment(d.Namespace, d.Name) {
+			continue
+		}
+		if t.generations.duration(d.Namespace+"/"+d.Name, now) > c.lagThreshold {
+			signals[signalGenerationLag]++
+		}
+		if progressDeadlineUnreported(d, now) {
+			signals[signalDeadlineUnreported]++
+		}
+	}
+	if delay, ok := c.medianDelay(now); ok && delay > c.delayThreshold {
+		signals[signalWatchDelay] = delay.Seconds()
+	}
+
+	suspect := float64(0)
+	for signal, value := range signals {
+		controllerHealthSignal.WithLabelValues(signal).Set(value)
+		if value > 0 {
+			suspect = 1
+		}
+	}
+	controllerHealthSuspect.Set(suspect)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsPolicy lets browser apps on the allowed origins call the JSON API
+// directly, without a proxy.
+type corsPolicy struct {
+	origins map[string]bool
+	any     bool
+}
+
+// newCORSPolicy returns the policy for the comma-separated origins, or nil
+// when none are allowed. "*" allows every origin.
+func newCORSPolicy(origins string) *corsPolicy {
+	list := splitList(origins)
+	if len(list) == 0 {
+		return nil
+	}
+	p := &corsPolicy{origins: make(map[string]bool, len(list))}
+	for _, origin := range list {
+		if origin == "*" {
+			p.any = true
+		}
+		p.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	return p
+}
+
+// handle sets the CORS headers for allowed origins and answers preflight
+// requests, which carry no credentials and must not reach authentication.
+// It reports whether the request was handled.
+func (p *corsPolicy) handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !p.any && !p.origins[origin] {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCORSPolicy(t *testing.T) {
+	if p := newCORSPolicy(" , "); p != nil {
+		t.Errorf("newCORSPolicy of no origins = %+v, want nil", p)
+	}
+
+	tests := []struct {
+		name      string
+		origins   string
+		method    string
+		origin    string
+		preflight string
+		handled   bool
+		allowed   bool
+	}{
+		{
+			name:    "allowed origin",
+			origins: "https://a.example.com,https://b.example.com",
+			method:  http.MethodGet,
+			origin:  "https://b.example.com",
+			allowed: true,
+		},
+		{
+			name:    "configured with a trailing slash",
+			origins: "https://a.example.com/",
+			method:  http.MethodGet,
+			origin:  "https://a.example.com",
+			allowed: true,
+		},
+		{
+			name:    "other origin",
+			origins: "https://a.example.com",
+			method:  http.MethodGet,
+			origin:  "https://evil.example.com",
+		},
+		{
+			name:    "no origin",
+			origins: "https://a.example.com",
+			method:  http.MethodGet,
+		},
+		{
+			name:    "any origin",
+			origins: "*",
+			method:  http.MethodGet,
+			origin:  "https://evil.example.com",
+			allowed: true,
+		},
+		{
+			name:      "preflight",
+			origins:   "https://a.example.com",
+			method:    http.MethodOptions,
+			origin:    "https://a.example.com",
+			preflight: http.MethodPost,
+			handled:   true,
+			allowed:   true,
+		},
+		{
+			name:      "preflight from other origin",
+			origins:   "https://a.example.com",
+			method:    http.MethodOptions,
+			origin:    "https://evil.example.com",
+			preflight: http.MethodPost,
+		},
+		{
+			name:    "OPTIONS without Access-Control-Request-Method",
+			origins: "https://a.example.com",
+			method:  http.MethodOptions,
+			origin:  "https://a.example.com",
+			allowed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/state", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			w := httptest.NewRecorder()
+
+			if handled := newCORSPolicy(tt.origins).handle(w, r); handled != tt.handled {
+				t.Errorf("handle() = %v, want %v", handled, tt.handled)
+			}
+			if vary := w.Header().Get("Vary"); vary != "Origin" {
+				t.Errorf("Vary = %q, want Origin", vary)
+			}
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.allowed && allowOrigin != tt.origin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", allowOrigin, tt.origin)
+			}
+			if !tt.allowed && allowOrigin != "" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want none", allowOrigin)
+			}
+			if tt.handled {
+				if w.Code != http.StatusNoContent {
+					t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+				}
+				if methods := w.Header().Get("Access-Control-Allow-Methods"); methods == "" {
+					t.Error("Access-Control-Allow-Methods not set on preflight")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// applyCredentials adjusts the client config for strict multi-tenant
+// clusters, where requests can impersonate a tenant user/groups.
+func applyCredentials(config *rest.Config, opts *options) (*rest.Config, error) {
+	if opts.impersonateUser != "" || opts.impersonateGroups != "" {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: opts.impersonateUser,
+			Groups:   splitList(opts.impersonateGroups),
+		}
+	}
+	return config, nil
+}
+
+// kubeClients are the clients of one identity.
+type kubeClients struct {
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+	metricsClient *metricsv.Clientset
+}
+
+// tenantClients are the clients of the watched namespaces by namespace when
+// a per-namespace service-account token (e.g. a projected token file) of
+// --namespace-token-dir replaces the exporter's own credentials for the
+// namespace. Requests for cluster-scoped resources keep using the exporter's
+// own credentials.
+type tenantClients map[string]*kubeClients
+
+// newTenantClients creates the clients of each watched namespace from the
+// token file <namespace-token-dir>/<namespace>, nil without
+// --namespace-token-dir.
+func newTenantClients(config *rest.Config, opts *options) (tenantClients, error) {
+	if opts.namespaceTokenDir == "" {
+		return nil, nil
+	}
+	tenants := make(tenantClients)
+	for _, namespace := range opts.trackedNamespaces() {
+		tokenFile := filepath.Join(opts.namespaceTokenDir, namespace)
+		if _, err := os.Stat(tokenFile); err != nil {
+			return nil, fmt.Errorf("token for namespace %q: %w", namespace, err)
+		}
+
+		// Drop every other credential so the tenant token is the only
+		// identity; client-go re-reads the file as the token is rotated
+		tenant := rest.AnonymousClientConfig(config)
+		tenant.BearerTokenFile = tokenFile
+		tenant.Impersonate = config.Impersonate
+		tenant.WrapTransport = config.WrapTransport
+
+		clients := &kubeClients{}
+		var err error
+		if clients.clientset, err = kubernetes.NewForConfig(tenant); err != nil {
+			return nil, fmt.Errorf("kubernetes client of namespace %q: %w", namespace, err)
+		}
+		if clients.dynamicClient, err = dynamic.NewForConfig(tenant); err != nil {
+			return nil, fmt.Errorf("dynamic kubernetes client of namespace %q: %w", namespace, err)
+		}
+		if clients.metricsClient, err = metricsv.NewForConfig(tenant); err != nil {
+			return nil, fmt.Errorf("metrics client of namespace %q: %w", namespace, err)
+		}
+		tenants[namespace] = clients
+	}
+	return tenants, nil
+}
+
+// clientsetFor returns the clientset of the namespace's token, or own for
+// namespaces without one.
+func (c tenantClients) clientsetFor(namespace string, own kubernetes.Interface) kubernetes.Interface {
+	if clients, ok := c[namespace]; ok {
+		return clients.clientset
+	}
+	return own
+}
+
+// clientsetFor returns the clientset for namespaced requests in the
+// namespace.
+func (t *DeploymentTracker) clientsetFor(namespace string) kubernetes.Interface {
+	return t.tenants.clientsetFor(namespace, t.clientset)
+}
+
+// dynamicClientFor returns the dynamic client for namespaced requests in the
+// namespace.
+func (t *DeploymentTracker) dynamicClientFor(namespace string) dynamic.Interface {
+	if clients, ok := t.tenants[namespace]; ok {
+		return clients.dynamicClient
+	}
+	return t.dynamicClient
+}
+
+// metricsClientFor returns the metrics client for the namespace's pod
+// metrics, nil if the exporter's own couldn't be created.
+func (t *DeploymentTracker) metricsClientFor(namespace string) *metricsv.Clientset {
+	if clients, ok := t.tenants[namespace]; ok {
+		return clients.metricsClient
+	}
+	return t.metricsClient
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
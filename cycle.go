@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Time spent on one deployment (pod listing, metrics-server calls, ...)
+	deploymentProcessingDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_last_processing_duration_seconds",
+			Help: "Duration in seconds of the last processing of the deployment",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Time spent on a whole periodic scrape
+	exporterScrapeCycleDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "exporter_scrape_cycle_duration_seconds",
+			Help:    "Duration in seconds of periodic scrape cycles over all tracked deployments",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 30, 60, 120},
+		},
+	)
+
+	// Current periodic scrape interval after adapting to load and throttling
+	exporterScrapeInterval = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "exporter_scrape_interval_seconds",
+			Help: "Current interval in seconds between periodic scrape cycles",
+		},
+	)
+)
+
+// scrapeSchedule adapts the periodic scrape interval: it is stretched when a
+// cycle takes longer than the interval or the API server throttles us, and
+// shrunk back towards min once the load drops, always within [min, max].
+type scrapeSchedule struct {
+	min, max time.Duration
+	current  time.Duration
+}
+
+func newScrapeSchedule(interval, min, max time.Duration) *scrapeSchedule {
+	s := &scrapeSchedule{min: min, max: max, current: interval}
+	exporterScrapeInterval.Set(interval.Seconds())
+	return s
+}
+
+// next returns the interval until the next cycle given the duration of the
+// last one.
+func (s *scrapeSchedule) next(duration time.Duration, throttled bool) time.Duration {
+	previous := s.current
+	switch {
+	case throttled:
+		// Back off while the API server is throttling requests instead of
+		// adding to its load
+		s.current *= 2
+	case duration > s.current:
+		// Leave headroom so cycles don't run back to back
+		s.current = duration * 3 / 2
+	default:
+		// Shrink back gradually, keeping the same headroom
+		s.current = s.current / 2
+		if floor := duration * 3 / 2; s.current < floor {
+			s.current = floor
+		}
+	}
+	if s.current > s.max {
+		s.current = s.max
+	}
+	if s.current < s.min {
+		s.current = s.min
+	}
+
+	if s.current != previous {
+		switch {
+		case throttled:
+			log.Printf("Warning: Kubernetes API throttling, periodic scrape interval stretched from %s to %s", previous, s.current)
+		case s.current > previous:
+			log.Printf("Warning: Periodic scrape took %s, interval stretched from %s to %s", duration.Round(time.Millisecond), previous, s.current)
+		default:
+			log.Printf("Periodic scrape load dropped, interval shrunk from %s to %s", previous, s.current)
+		}
+		exporterScrapeInterval.Set(s.current.Seconds())
+	}
+	return s.current
+}
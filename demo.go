@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Image of the sample workloads, and a tag of it that doesn't exist to
+	// break one
+	demoImage       = "registry.k8s.io/pause:3.9"
+	demoBrokenImage = "registry.k8s.io/pause:does-not-exist"
+
+	// Sample deployment that is broken and fixed on a timer
+	demoVictim = "checkout"
+
+	// Label marking the namespace and deployments the demo created; it
+	// never touches or deletes objects without it
+	demoOwnerLabel = "deployment-exporter-demo"
+
+	// How long the exporter must keep running after its start before the
+	// demo counts it as up, to catch e.g. rejected flags right away
+	demoStartupCheck = 3 * time.Second
+)
+
+// Sample workloads: name -> replicas
+var demoDeployments = map[string]int32{
+	"frontend": 3,
+	"checkout": 2,
+	"worker":   1,
+}
+
+// demoConfig holds the settings of `demo`.
+type demoConfig struct {
+	kindCluster   string
+	kubeconfig    string
+	kubeContext   string
+	namespace     string
+	breakInterval time.Duration
+	metricsAddr   string
+	uiAddr        string
+	keep          bool
+	exporterArgs  []string
+}
+
+// runDemo implements `demo`: it provisions sample deployments in a kind
+// cluster (created if needed) or the given context, runs the exporter
+// against them, breaks and fixes one of them on a timer and serves a status
+// page, so the exporter can be evaluated without writing any config.
+// Arguments after -- are passed to the exporter.
+func runDemo(args []string) int {
+	fs := flag.NewFlagSet("demo", flag.ContinueOnError)
+	cfg := demoConfig{}
+	fs.StringVar(&cfg.kindCluster, "kind-cluster", "deployment-exporter-demo", "kind cluster to use, created if it doesn't exist (ignored with --kube-context)")
+	fs.StringVar(&cfg.kubeconfig, "kubeconfig", "", "Path to kubeconfig file (default ~/.kube/config)")
+	fs.StringVar(&cfg.kubeContext, "kube-context", "", "Existing kubeconfig context to use instead of a kind cluster")
+	fs.StringVar(&cfg.namespace, "namespace", "deployment-exporter-demo", "Namespace of the sample deployments")
+	interval := fs.Int("break-interval", 120, "Seconds between breaking and fixing the checkout deployment")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", "127.0.0.1:9101", "Address the exporter serves metrics and its API on")
+	fs.StringVar(&cfg.uiAddr, "ui-addr", "127.0.0.1:8081", "Address of the demo status page")
+	fs.BoolVar(&cfg.keep, "keep", false, "Keep the sample namespace on exit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *interval < 10 {
+		fmt.Fprintf(os.Stderr, "demo: break-interval must be at least 10 seconds, got %d\n", *interval)
+		return 2
+	}
+	cfg.breakInterval = time.Duration(*interval) * time.Second
+	cfg.exporterArgs = fs.Args()
+
+	if err := demo(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "demo: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func demo(cfg demoConfig) error {
+	if cfg.kubeContext == "" {
+		if err := ensureKindCluster(cfg.kindCluster); err != nil {
+			return err
+		}
+		cfg.kubeContext = "kind-" + cfg.kindCluster
+	}
+	config, err := getKubeConfig(cfg.kubeconfig, cfg.kubeContext)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes client: %w", err)
+	}
+
+	ctx := context.Background()
+	created, err := provisionDemo(ctx, clientset, cfg.namespace)
+	if err != nil {
+		return err
+	}
+	// A namespace that existed before the demo isn't the demo's to delete
+	if created && !cfg.keep {
+		defer func() {
+			log.Printf("Deleting namespace %s", cfg.namespace)
+			if err := clientset.CoreV1().Namespaces().Delete(ctx, cfg.namespace, metav1.DeleteOptions{}); err != nil {
+				log.Printf("Error deleting namespace %s: %v", cfg.namespace, err)
+			}
+		}()
+	}
+
+	// The exporter runs as a child process with the demo's settings first,
+	// so flags after -- override them
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exporterArgs := []string{
+		"--context=" + cfg.kubeContext,
+		"--namespace=" + cfg.namespace,
+		"--metrics-addr=" + cfg.metricsAddr,
+		"--scrape-interval=5",
+		"--startup-grace-period=0",
+	}
+	if cfg.kubeconfig != "" {
+		exporterArgs = append(exporterArgs, "--kubeconfig="+cfg.kubeconfig)
+	}
+	exporter := exec.Command(executable, append(exporterArgs, cfg.exporterArgs...)...)
+	exporter.Stdout = os.Stdout
+	exporter.Stderr = os.Stderr
+	if err := exporter.Start(); err != nil {
+		return fmt.Errorf("starting exporter: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- exporter.Wait() }()
+	select {
+	case err := <-exited:
+		return fmt.Errorf("exporter exited during startup: %v", err)
+	case <-time.After(demoStartupCheck):
+	}
+
+	go serveDemoUI(cfg)
+	go breakOnTimer(ctx, clientset, cfg.namespace, cfg.breakInterval)
+
+	log.Printf("Demo running: status page on http://%s, metrics on http://%s/metrics; %s/%s alternates between broken and fixed every %s. Press Ctrl+C to stop.",
+		cfg.uiAddr, cfg.metricsAddr, cfg.namespace, demoVictim, cfg.breakInterval)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-signals:
+		exporter.Process.Signal(syscall.SIGTERM)
+		<-exited
+		return nil
+	case err := <-exited:
+		return fmt.Errorf("exporter exited: %v", err)
+	}
+}
+
+// ensureKindCluster creates the kind cluster unless it already exists.
+func ensureKindCluster(name string) error {
+	if _, err := exec.LookPath("kind"); err != nil {
+		return fmt.Errorf("kind is not installed (https://kind.sigs.k8s.io), install it or pass --kube-context")
+	}
+	out, err := exec.Command("kind", "get", "clusters").Output()
+	if err != nil {
+		return fmt.Errorf("listing kind clusters: %w", err)
+	}
+	for _, cluster := range strings.Fields(string(out)) {
+		if cluster == name {
+			log.Printf("Using existing kind cluster %s", name)
+			return nil
+		}
+	}
+	log.Printf("Creating kind cluster %s, delete it with `kind delete cluster --name %s`", name, name)
+	create := exec.Command("kind", "create", "cluster", "--name", name, "--wait", "120s")
+	create.Stdout = os.Stdout
+	create.Stderr = os.Stderr
+	if err := create.Run(); err != nil {
+		return fmt.Errorf("creating kind cluster: %w", err)
+	}
+	return nil
+}
+
+// provisionDemo creates the namespace and the sample deployments, resetting
+// ones left over from an earlier run, and reports whether it created the
+// namespace. Deployments of the same name that the demo didn't create are
+// left alone and fail the demo.
+func provisionDemo(ctx context.Context, clientset *kubernetes.Clientset, namespace string) (bool, error) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: map[string]string{demoOwnerLabel: "true"}}}
+	created := true
+	if _, err := clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); apierrors.IsAlreadyExists(err) {
+		created = false
+		log.Printf("Using existing namespace %s, it is kept on exit", namespace)
+	} else if err != nil {
+		return false, fmt.Errorf("creating namespace %s: %w", namespace, err)
+	}
+
+	deployments := clientset.AppsV1().Deployments(namespace)
+	for name, replicas := range demoDeployments {
+		deployment := demoDeployment(namespace, name, replicas)
+		_, err := deployments.Create(ctx, deployment, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			var existing *appsv1.Deployment
+			existing, err = deployments.Get(ctx, name, metav1.GetOptions{})
+			if err == nil && existing.Labels[demoOwnerLabel] != "true" {
+				return created, fmt.Errorf("deployment %s/%s exists and wasn't created by the demo (no %s label), refusing to change it; use another --namespace",
+					namespace, name, demoOwnerLabel)
+			}
+			if err == nil {
+				deployment.ResourceVersion = existing.ResourceVersion
+				_, err = deployments.Update(ctx, deployment, metav1.UpdateOptions{})
+			}
+		}
+		if err != nil {
+			return created, fmt.Errorf("creating deployment %s/%s: %w", namespace, name, err)
+		}
+	}
+	log.Printf("Provisioned %d sample deployments in namespace %s", len(demoDeployments), namespace)
+	return created, nil
+}
+
+func demoDeployment(namespace, name string, replicas int32) *appsv1.Deployment {
+	labels := map[string]string{"app": name}
+	progressDeadline := int32(60)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": name, demoOwnerLabel: "true"}},
+		Spec: appsv1.DeploymentSpec{
+			Replicas:                &replicas,
+			ProgressDeadlineSeconds: &progressDeadline,
+			Selector:                &metav1.LabelSelector{MatchLabels: labels},
+			// Replaces all pods at once, so a broken image takes the
+			// deployment down rather than stalling the rollout
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: name, Image: demoImage}},
+				},
+			},
+		},
+	}
+}
+
+// breakOnTimer alternates the victim deployment between an image that
+// doesn't exist and the working one.
+func breakOnTimer(ctx context.Context, clientset *kubernetes.Clientset, namespace string, interval time.Duration) {
+	broken := false
+	for range time.Tick(interval) {
+		broken = !broken
+		image := demoImage
+		if broken {
+			image = demoBrokenImage
+		}
+		deployments := clientset.AppsV1().Deployments(namespace)
+		d, err := deployments.Get(ctx, demoVictim, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Error getting deployment %s/%s: %v", namespace, demoVictim, err)
+			continue
+		}
+		d.Spec.Template.Spec.Containers[0].Image = image
+		if _, err := deployments.Update(ctx, d, metav1.UpdateOptions{}); err != nil {
+			log.Printf("Error updating deployment %s/%s: %v", namespace, demoVictim, err)
+			continue
+		}
+		if broken {
+			log.Printf("Demo: broke %s/%s (image %s), it should go down shortly", namespace, demoVictim, image)
+		} else {
+			log.Printf("Demo: fixed %s/%s, it should recover shortly", namespace, demoVictim)
+		}
+	}
+}
+
+var demoPage = template.Must(template.New("demo").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>deployment-exporter demo</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.up { color: #080; } .down { color: #c00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>deployment-exporter demo</h1>
+<p>Namespace <code>{{.Namespace}}</code>. <code>{{.Victim}}</code> alternates between broken and fixed every {{.Interval}}.
+Raw data: <a href="{{.MetricsURL}}/metrics">/metrics</a>, <a href="{{.MetricsURL}}/api/v1/state">/api/v1/state</a>,
+<a href="{{.MetricsURL}}/api/v1/incidents">/api/v1/incidents</a>.</p>
+{{if .Error}}<p class="down">{{.Error}}</p>{{end}}
+<h2>Deployments</h2>
+<table>
+<tr><th>Deployment</th><th>Status</th><th>Down since</th></tr>
+{{range .State.Deployments}}<tr><td>{{.Deployment}}</td>
+{{if .Ready}}<td class="up">up</td><td></td>{{else}}<td class="down">down</td><td>{{with .CorrectedDownSince}}{{.Format "15:04:05"}}{{end}}</td>{{end}}</tr>
+{{end}}</table>
+<h2>Incidents</h2>
+<table>
+<tr><th>Deployment</th><th>Start</th><th>End</th><th>Causes</th></tr>
+{{range .Incidents}}<tr><td>{{.Deployment}}</td><td>{{.Start.Format "15:04:05"}}</td>
+<td>{{with .End}}{{.Format "15:04:05"}}{{else}}ongoing{{end}}</td><td>{{range .Causes}}{{.}} {{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveDemoUI serves a status page rendered from the exporter's API.
+func serveDemoUI(cfg demoConfig) {
+	host, port, _ := net.SplitHostPort(cfg.metricsAddr)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	metricsURL := "http://" + net.JoinHostPort(host, port)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Namespace  string
+			Victim     string
+			Interval   time.Duration
+			MetricsURL string
+			State      trackerState
+			Incidents  []incident
+			Error      string
+		}{Namespace: cfg.namespace, Victim: demoVictim, Interval: cfg.breakInterval, MetricsURL: metricsURL}
+
+		err := getJSON(client, metricsURL+"/api/v1/state", &data.State)
+		if err == nil {
+			err = getJSON(client, metricsURL+"/api/v1/incidents", &data.Incidents)
+		}
+		if err != nil {
+			data.Error = fmt.Sprintf("Exporter not reachable yet: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		demoPage.Execute(w, data)
+	})
+	if err := http.ListenAndServe(cfg.uiAddr, handler); err != nil {
+		log.Printf("Error serving demo status page: %v", err)
+	}
+}
+
+func getJSON(client *http.Client, url string, v any) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// Downtime start back-dated to the deployment's Available condition
+	// transition
+	deploymentCorrectedDowntimeStart = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_corrected_downtime_start_timestamp_seconds",
+			Help: "Unix timestamp when the deployment went down, from the Available condition's last transition where known",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentCorrectedDowntimeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_corrected_downtime_duration_seconds",
+			Help: "Duration in seconds that a deployment was down, measured from the corrected downtime start",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// correctedDowntimeStart back-dates a downtime observed at observed to when
+// the deployment's Available condition turned false, which is earlier when
+// watch events were delayed or the exporter started mid-outage. It never
+// goes back past the previous recovery, since the condition can stay false
+// across a short recovery (e.g. with minReadySeconds).
+func correctedDowntimeStart(deployment *appsv1.Deployment, observed, lastRecovery time.Time) time.Time {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type != appsv1.DeploymentAvailable || condition.Status != corev1.ConditionFalse {
+			continue
+		}
+		transition := condition.LastTransitionTime.Time
+		if transition.IsZero() || transition.After(observed) || transition.Before(lastRecovery) {
+			return observed
+		}
+		return transition
+	}
+	return observed
+}
+
+// recoveryTime returns when the deployment's last pod became Ready, which is
+// when a recovery noticed at observed actually happened. It is kept between
+// downSince and observed, falling back to observed when the pods can't be
+// listed.
+func (t *DeploymentTracker) recoveryTime(deployment *appsv1.Deployment, downSince, observed time.Time) time.Time {
+	pods, err := t.ownedPods(deployment)
+	if err != nil {
+		return observed
+	}
+	var latest time.Time
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if ready, since := podReady(pod); ready && since.After(latest) {
+			latest = since
+		}
+	}
+	if latest.IsZero() || latest.After(observed) {
+		return observed
+	}
+	if latest.Before(downSince) {
+		return downSince
+	}
+	return latest
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// dryRunReporter logs the metric values the exporter would expose instead of
+// serving them, so filters and readiness policies can be validated against
+// production without publishing anything.
+type dryRunReporter struct {
+	gatherer prometheus.Gatherer
+	last     map[string]float64
+}
+
+func newDryRunReporter(gatherer prometheus.Gatherer) *dryRunReporter {
+	return &dryRunReporter{
+		gatherer: gatherer,
+		last:     make(map[string]float64),
+	}
+}
+
+// report logs every series whose value changed since the previous report.
+func (d *dryRunReporter) report() {
+	families, err := d.gatherer.Gather()
+	if err != nil {
+		log.Printf("[dry-run] Error gathering metrics: %v", err)
+		return
+	}
+
+	changed := 0
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			series := seriesName(family.GetName(), metric.GetLabel())
+			value := sampleValue(family.GetType(), metric)
+			if previous, ok := d.last[series]; ok && previous == value {
+				continue
+			}
+			d.last[series] = value
+			changed++
+			log.Printf("[dry-run] would set %s = %g", series, value)
+		}
+	}
+	debugf("[dry-run] %d series changed", changed)
+}
+
+func seriesName(name string, labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sampleValue returns the value of a gauge or counter, and the observation
+// count of a histogram or summary.
+func sampleValue(metricType dto.MetricType, metric *dto.Metric) float64 {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return metric.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return metric.GetGauge().GetValue()
+	case dto.MetricType_HISTOGRAM:
+		return float64(metric.GetHistogram().GetSampleCount())
+	case dto.MetricType_SUMMARY:
+		return float64(metric.GetSummary().GetSampleCount())
+	default:
+		return metric.GetUntyped().GetValue()
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Largest StatsD datagram that fits an Ethernet MTU without fragmentation
+const statsdMaxPacket = 1432
+
+// graphiteUnsafe replaces characters that separate or break Graphite path
+// components.
+var graphiteUnsafe = strings.NewReplacer(".", "_", " ", "_", "/", "_", ":", "_", "|", "_", "\n", "_")
+
+// emitter pushes the selected metric families to Graphite (plaintext
+// protocol) and/or StatsD (gauges) on an interval, for monitoring stacks that
+// don't scrape Prometheus endpoints. Series are named
+// <prefix>.<family>.<label values...>, e.g.
+// k8s.k8s_deployment_status.shop.checkout.
+type emitter struct {
+	gatherer     prometheus.Gatherer
+	families     []string // names, or prefixes ending in *
+	prefix       string
+	graphiteAddr string
+	statsdAddr   string
+}
+
+func newEmitter(opts *options, gatherer prometheus.Gatherer) *emitter {
+	return &emitter{
+		gatherer:     gatherer,
+		families:     splitList(opts.emitMetrics),
+		prefix:       opts.emitPrefix,
+		graphiteAddr: opts.graphiteAddr,
+		statsdAddr:   opts.statsdAddr,
+	}
+}
+
+// selected reports whether a metric family is configured to be emitted.
+func (e *emitter) selected(name string) bool {
+	for _, pattern := range e.families {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(name, prefix) || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// emitSample is one series value to push.
+type emitSample struct {
+	path  string
+	value float64
+}
+
+// samples gathers the selected series. Histograms and summaries are sent as
+// their observation count.
+func (e *emitter) samples() ([]emitSample, error) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var samples []emitSample
+	for _, family := range families {
+		if !e.selected(family.GetName()) {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			value := sampleValue(family.GetType(), metric)
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			samples = append(samples, emitSample{path: e.path(family.GetName(), metric.GetLabel()), value: value})
+		}
+	}
+	return samples, nil
+}
+
+func (e *emitter) path(name string, labels []*dto.LabelPair) string {
+	parts := make([]string, 0, len(labels)+2)
+	if e.prefix != "" {
+		parts = append(parts, e.prefix)
+	}
+	parts = append(parts, name)
+	for _, label := range labels {
+		value := label.GetValue()
+		if value == "" {
+			value = "none"
+		}
+		parts = append(parts, graphiteUnsafe.Replace(value))
+	}
+	return strings.Join(parts, ".")
+}
+
+// sendGraphite writes the samples over one TCP connection in the plaintext
+// protocol.
+func (e *emitter) sendGraphite(samples []emitSample, now time.Time) error {
+	conn, err := net.DialTimeout("tcp", e.graphiteAddr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(now.Add(30 * time.Second))
+
+	var buf bytes.Buffer
+	for _, s := range samples {
+		fmt.Fprintf(&buf, "%s %g %d\n", s.path, s.value, now.Unix())
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// sendStatsD sends the samples as gauges in datagrams of at most
+// statsdMaxPacket bytes. StatsD reads a leading minus as a decrement, so
+// negative values are sent as a reset to 0 followed by the delta.
+func (e *emitter) sendStatsD(samples []emitSample) error {
+	conn, err := net.Dial("udp", e.statsdAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, s := range samples {
+		line := fmt.Sprintf("%s:%g|g\n", s.path, s.value)
+		if s.value < 0 {
+			line = fmt.Sprintf("%s:0|g\n%s:%g|g\n", s.path, s.path, s.value)
+		}
+		if packet.Len()+len(line) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
+
+// run emits every interval.
+func (e *emitter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		samples, err := e.samples()
+		if err != nil {
+			log.Printf("Error gathering metrics to emit: %v", err)
+			continue
+		}
+		if e.graphiteAddr != "" {
+			if err := e.sendGraphite(samples, now); err != nil {
+				log.Printf("Error sending metrics to Graphite %s: %v", e.graphiteAddr, err)
+			}
+		}
+		if e.statsdAddr != "" {
+			if err := e.sendStatsD(samples); err != nil {
+				log.Printf("Error sending metrics to StatsD %s: %v", e.statsdAddr, err)
+			}
+		}
+		debugf("Emitted %d series", len(samples))
+	}
+}
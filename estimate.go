@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Number of metric families listed by series count in the estimate report
+const estimateTopFamilies = 15
+
+// runEstimate implements `estimate`: it runs one scrape of the deployments
+// the given flags (or --config) select into a private registry and reports
+// the tracked deployments and pods, the series produced and the memory
+// taken, so collectors can be sized before enabling them in huge clusters.
+// Nothing is served and no notifications are sent.
+func runEstimate(args []string) int {
+	fs := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	opts := &options{}
+	opts.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if opts.configFile != "" {
+		if err := loadConfigFile(fs, opts.configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if err := opts.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "estimate: invalid configuration: %v\n", err)
+		return 1
+	}
+	setLogLevel(opts.logLevel)
+
+	if err := estimate(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "estimate: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func estimate(opts *options) error {
+	config, err := getKubeConfig(opts.kubeconfig, opts.kubeContext)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes config: %w", err)
+	}
+	config, err = applyCredentials(config, opts)
+	if err != nil {
+		return fmt.Errorf("applying credentials: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating dynamic kubernetes client: %w", err)
+	}
+	metricsClient, err := metricsv.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating metrics client: %w", err)
+	}
+	tenants, err := newTenantClients(config, opts)
+	if err != nil {
+		return fmt.Errorf("creating namespace clients: %w", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registerMetrics(registry)
+	registerConditionMetric(registry, opts.normalizeConditions)
+
+	location, _ := time.LoadLocation(opts.scheduleTimezone)
+	tracker := &DeploymentTracker{
+		clientset:         clientset,
+		dynamicClient:     dynamicClient,
+		metricsClient:     metricsClient,
+		tenants:           tenants,
+		metricsCircuit:    newCircuitBreaker(opts.metricsFailureThreshold, time.Duration(opts.metricsCooldown)*time.Second, metricsAPICircuitOpen),
+		downtimeStart:     newTimeMap(),
+		correctedStart:    newTimeMap(),
+		lastRecovery:      newTimeMap(),
+		locks:             newDeploymentLocks(),
+		namespaces:        opts.trackedNamespaces(),
+		matchByOwner:      opts.matchByOwner,
+		sidecarContainers: parseSidecarContainers(opts.sidecarContainers),
+		shard:             opts.shard,
+		totalShards:       opts.totalShards,
+		shardByNamespace:  opts.shardBy == shardByNamespace,
+		staleRolloutAge:   time.Duration(opts.staleRolloutDays) * 24 * time.Hour,
+		meshHealth:        opts.meshHealth,
+		nodeOS:            opts.nodeOS,
+		pvcMetrics:        opts.pvcMetrics,
+		watchServices:     opts.watchServices,
+		autoscaleReplicas: opts.autoscalerMinReplicas,
+		keda:              opts.keda,
+		schedules:         newReplicaSchedules(opts.replicaScheduleFile, location),
+		silences:          newSilenceStore(),
+		incidents:         newIncidentStore(time.Duration(opts.incidentGroupWindow) * time.Second),
+		restarts:          newRestartTracker(time.Duration(opts.restartStormWindow)*time.Second, opts.restartStormThreshold),
+		generations:       newGenerationLag(),
+		controller:        newControllerHealth(time.Duration(opts.controllerLagThreshold)*time.Second, time.Duration(opts.watchDelayThreshold)*time.Second),
+		rates:             newRollingRates(),
+		gauges:            newGaugeCache(),
+		events:            newEventBatcher(time.Duration(opts.notifyBatchWindow)*time.Second, opts.notifyBatchThreshold, newDispatcher(nil)),
+	}
+	if opts.failurePrediction || opts.memoryForecast {
+		tracker.memory = newMemoryHistory(opts.memoryHistoryWindow())
+		tracker.forecast = opts.memoryForecast
+	}
+	if opts.failurePrediction {
+		tracker.predictor = newFailurePredictor(tracker.memory)
+	}
+	if opts.anomalySigmas > 0 {
+		tracker.baselines = newUsageBaselines(opts.anomalySigmas)
+	}
+	if opts.normalizeConditions {
+		tracker.conditions = newConditionSeries()
+	}
+	if err := tracker.schedules.reload(); err != nil {
+		return fmt.Errorf("loading replica schedules: %w", err)
+	}
+	if opts.pvcUsage {
+		tracker.volumeStats = newVolumeStatsCache(time.Duration(opts.scrapeInterval) * time.Second)
+	}
+	if opts.cpuThrottling {
+		tracker.cadvisor = newCadvisorCache(time.Duration(opts.scrapeInterval) * time.Second)
+	}
+
+	baseline := heapInUse()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	tracker.startInformers(stopCh)
+	informers := heapInUse()
+
+	start := time.Now()
+	tracker.scrapeDeployments()
+	duration := time.Since(start)
+
+	families, err := registry.Gather()
+	if err != nil {
+		return fmt.Errorf("gathering metrics: %w", err)
+	}
+	total := heapInUse()
+
+	deployments, failed := listDeployments(context.Background(), tracker.clientsetFor, tracker.namespaces)
+	if err := listError(failed); err != nil {
+		return err
+	}
+	tracked := 0
+	for _, deployment := range deployments {
+		if tracker.ownsDeployment(deployment.Namespace, deployment.Name) {
+			tracked++
+		}
+	}
+	pods, cached := 0, 0
+	for _, caches := range tracker.caches {
+		for _, obj := range caches.pods.GetStore().List() {
+			cached++
+			ns, name, ok := tracker.podDeployment(obj.(*corev1.Pod))
+			if ok && tracker.ownsDeployment(ns, name) {
+				pods++
+			}
+		}
+	}
+
+	type familySeries struct {
+		name   string
+		series int
+	}
+	var bySeries []familySeries
+	series := 0
+	for _, family := range families {
+		count := familySeriesCount(family.GetType(), family.GetMetric())
+		series += count
+		bySeries = append(bySeries, familySeries{family.GetName(), count})
+	}
+	sort.Slice(bySeries, func(i, j int) bool {
+		if bySeries[i].series != bySeries[j].series {
+			return bySeries[i].series > bySeries[j].series
+		}
+		return bySeries[i].name < bySeries[j].name
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Deployments\t%d tracked of %d listed\n", tracked, len(deployments))
+	fmt.Fprintf(w, "Pods\t%d of tracked deployments, %d in informer cache\n", pods, cached)
+	fmt.Fprintf(w, "Series\t%d (%.1f per deployment)\n", series, perDeployment(series, tracked))
+	fmt.Fprintf(w, "Scrape duration\t%s\n", duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "Memory (informer caches)\t%s\n", formatBytes(informers-baseline))
+	fmt.Fprintf(w, "Memory (metrics and state)\t%s\n", formatBytes(total-informers))
+	fmt.Fprintf(w, "Memory (total heap)\t%s\n", formatBytes(total))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "FAMILY\tSERIES")
+	for i, family := range bySeries {
+		if i == estimateTopFamilies || family.series == 0 {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%d\n", family.name, family.series)
+	}
+	return w.Flush()
+}
+
+// familySeriesCount counts the series a metric family exposes: one per
+// gauge or counter, and one per bucket plus _sum and _count for histograms.
+func familySeriesCount(metricType dto.MetricType, metrics []*dto.Metric) int {
+	count := 0
+	for _, metric := range metrics {
+		switch metricType {
+		case dto.MetricType_HISTOGRAM:
+			// +Inf bucket, _sum and _count
+			count += len(metric.GetHistogram().GetBucket()) + 3
+		case dto.MetricType_SUMMARY:
+			count += len(metric.GetSummary().GetQuantile()) + 2
+		default:
+			count++
+		}
+	}
+	return count
+}
+
+func perDeployment(series, deployments int) float64 {
+	if deployments == 0 {
+		return 0
+	}
+	return float64(series) / float64(deployments)
+}
+
+// heapInUse returns the live heap after a garbage collection.
+func heapInUse() int64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
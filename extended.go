@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	// HugePages and extended resources (GPUs, SR-IOV NICs, ...) in the
+	// resource's own unit: bytes for hugepages, devices for the rest
+	deploymentExtendedResourceRequest = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_extended_resource_request",
+			Help: "Total requests of a hugepages or extended resource for all pods in the deployment (bytes for hugepages)",
+		},
+		[]string{"namespace", "deployment", "resource"},
+	)
+
+	deploymentExtendedResourceLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_extended_resource_limit",
+			Help: "Total limits of a hugepages or extended resource for all pods in the deployment (bytes for hugepages)",
+		},
+		[]string{"namespace", "deployment", "resource"},
+	)
+)
+
+// isExtendedResource reports whether a resource is a hugepages size or an
+// extended resource advertised by a device plugin, i.e. a domain-prefixed
+// name outside the kubernetes.io namespace.
+func isExtendedResource(name corev1.ResourceName) bool {
+	if strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix) {
+		return true
+	}
+	return strings.Contains(string(name), "/") &&
+		!strings.Contains(string(name), "kubernetes.io/") &&
+		!strings.HasPrefix(string(name), corev1.DefaultResourceRequestsPrefix)
+}
+
+// collectExtendedResourceMetrics sums hugepages and extended resource
+// requests and limits per resource. Series of resources the pods no longer
+// use are removed.
+func collectExtendedResourceMetrics(namespace, deploymentName string, pods []*corev1.Pod) {
+	requests := make(map[corev1.ResourceName]*resource.Quantity)
+	limits := make(map[corev1.ResourceName]*resource.Quantity)
+	add := func(totals map[corev1.ResourceName]*resource.Quantity, list corev1.ResourceList) {
+		for name, quantity := range list {
+			if !isExtendedResource(name) {
+				continue
+			}
+			if totals[name] == nil {
+				totals[name] = resource.NewQuantity(0, quantity.Format)
+			}
+			totals[name].Add(quantity)
+		}
+	}
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			add(requests, container.Resources.Requests)
+			add(limits, container.Resources.Limits)
+		}
+	}
+
+	labels := prometheus.Labels{"namespace": namespace, "deployment": deploymentName}
+	deploymentExtendedResourceRequest.DeletePartialMatch(labels)
+	deploymentExtendedResourceLimit.DeletePartialMatch(labels)
+	for name, total := range requests {
+		deploymentExtendedResourceRequest.WithLabelValues(namespace, deploymentName, string(name)).Set(float64(total.Value()))
+	}
+	for name, total := range limits {
+		deploymentExtendedResourceLimit.WithLabelValues(namespace, deploymentName, string(name)).Set(float64(total.Value()))
+	}
+}
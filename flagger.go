@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Flagger names the deployment it creates to serve traffic after the target
+// deployment, which becomes the canary
+const flaggerPrimarySuffix = "-primary"
+
+var (
+	// Flagger canaries, which are scaled to zero between analyses and would
+	// otherwise count as down
+	flaggerCanaryReplicas = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_flagger_canary_replicas",
+			Help: "Desired replicas of the Flagger canary deployment; 0 between analyses",
+		},
+		[]string{"namespace", "canary"},
+	)
+
+	flaggerCanaryReplicasReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_flagger_canary_replicas_ready",
+			Help: "Ready replicas of the Flagger canary deployment",
+		},
+		[]string{"namespace", "canary"},
+	)
+
+	flaggerCanaryActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_flagger_canary_active",
+			Help: "Whether the Flagger canary deployment is scaled up for an analysis (1) or idle (0)",
+		},
+		[]string{"namespace", "canary"},
+	)
+
+	flaggerPrimary = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_flagger_primary",
+			Help: "Always 1; the Flagger primary deployment serving the traffic of the canary",
+		},
+		[]string{"namespace", "deployment", "canary"},
+	)
+)
+
+// flaggerCanaries keeps the canary deployments of Flagger's primaries. The
+// primaries are tracked like any deployment; the canaries only get the
+// k8s_flagger_canary_* series.
+type flaggerCanaries struct {
+	mu       sync.Mutex
+	canaries map[string]bool // namespace/deployment
+}
+
+func newFlaggerCanaries() *flaggerCanaries {
+	return &flaggerCanaries{canaries: make(map[string]bool)}
+}
+
+// flaggerCanaryOf returns the canary of a Flagger primary deployment, ok is
+// false for other deployments.
+func flaggerCanaryOf(d *appsv1.Deployment) (canary string, ok bool) {
+	ref := metav1.GetControllerOf(d)
+	if ref == nil || ref.Kind != "Canary" || !strings.HasPrefix(ref.APIVersion, "flagger.app/") {
+		return "", false
+	}
+	if !strings.HasSuffix(d.Name, flaggerPrimarySuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(d.Name, flaggerPrimarySuffix), true
+}
+
+// observe records the canary of a primary seen through the watch before the
+// next periodic list.
+func (f *flaggerCanaries) observe(d *appsv1.Deployment) {
+	if f == nil {
+		return
+	}
+	canary, ok := flaggerCanaryOf(d)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.canaries[d.Namespace+"/"+canary] = true
+	flaggerPrimary.WithLabelValues(d.Namespace, d.Name, canary).Set(1)
+}
+
+// isCanary reports whether the deployment is the canary of a Flagger
+// primary.
+func (f *flaggerCanaries) isCanary(ns, name string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.canaries[ns+"/"+name]
+}
+
+// updateFlaggerCanaries rebuilds the canaries from the periodic list before
+// the deployments are processed. Canaries the exporter tracked as plain
+// deployments before their primary showed up are forgotten, and the series
+// of canaries whose primary is gone are deleted. Namespaces that failed to
+// list keep their canaries.
+func (t *DeploymentTracker) updateFlaggerCanaries(deployments []appsv1.Deployment, failed map[string]error, now time.Time) {
+	f := t.flagger
+	if f == nil {
+		return
+	}
+
+	canaries := make(map[string]bool)
+	for _, deployment := range deployments {
+		if canary, ok := flaggerCanaryOf(&deployment); ok {
+			canaries[deployment.Namespace+"/"+canary] = true
+			flaggerPrimary.WithLabelValues(deployment.Namespace, deployment.Name, canary).Set(1)
+		}
+	}
+
+	f.mu.Lock()
+	var gone []string
+	for key := range f.canaries {
+		ns, _, _ := strings.Cut(key, "/")
+		if _, ok := failed[ns]; ok {
+			canaries[key] = true
+		} else if !canaries[key] {
+			gone = append(gone, key)
+		}
+	}
+	f.canaries = canaries
+	f.mu.Unlock()
+
+	for _, key := range gone {
+		ns, canary, _ := strings.Cut(key, "/")
+		labels := prometheus.Labels{"namespace": ns, "canary": canary}
+		flaggerCanaryReplicas.Delete(labels)
+		flaggerCanaryReplicasReady.Delete(labels)
+		flaggerCanaryActive.Delete(labels)
+		flaggerPrimary.DeletePartialMatch(labels)
+	}
+
+	tracked := t.trackedKeys()
+	for key := range canaries {
+		if !tracked[key] {
+			continue
+		}
+		ns, name, _ := strings.Cut(key, "/")
+		log.Printf("Deployment %s is a Flagger canary, only tracking it with the k8s_flagger_canary_* metrics", key)
+		t.forgetDeployment(ns, name, now, resolutionFlaggerCanary)
+		t.deleteDeploymentSeries(ns, name)
+	}
+}
+
+// collectCanaryMetrics reports a Flagger canary deployment.
+func collectCanaryMetrics(deployment *appsv1.Deployment) {
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	desired := int32(0)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	active := float64(0)
+	if desired > 0 {
+		active = 1
+	}
+	flaggerCanaryReplicas.WithLabelValues(ns, name).Set(float64(desired))
+	flaggerCanaryReplicasReady.WithLabelValues(ns, name).Set(float64(deployment.Status.ReadyReplicas))
+	flaggerCanaryActive.WithLabelValues(ns, name).Set(active)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Pre-emptive right-sizing: when memory usage will reach the limit if it
+	// keeps growing like it did
+	deploymentMemoryLimitETA = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_memory_limit_eta_seconds",
+			Help: "Seconds until the deployment's memory usage reaches its limit by a linear forecast of --forecast-window of usage history, 0 if already there; absent while usage isn't growing",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// memoryForecast is a deployment's entry of /api/v1/forecast.
+type memoryForecast struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	// Fitted memory usage of the containers with limits as a fraction of
+	// those limits, and its growth per hour
+	LimitRatio    float64 `json:"limitRatio"`
+	GrowthPerHour float64 `json:"growthPerHour"`
+	Samples       int     `json:"samples"`
+	// Forecast time the limit is reached, absent while usage isn't growing
+	LimitReached *time.Time `json:"limitReached,omitempty"`
+}
+
+// keys returns the deployments with samples.
+func (h *memoryHistory) keys() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.samples))
+	for key := range h.samples {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// forecast fits the deployment's memory history. ok is false with too few
+// samples to tell.
+func (h *memoryHistory) forecast(key string) (f memoryForecast, ok bool) {
+	samples := h.get(key)
+	if len(samples) < memoryTrendSamples {
+		return memoryForecast{}, false
+	}
+	namespace, deployment, _ := strings.Cut(key, "/")
+	slope, current := linearTrend(samples)
+	f = memoryForecast{
+		Namespace:     namespace,
+		Deployment:    deployment,
+		LimitRatio:    current,
+		GrowthPerHour: slope * time.Hour.Seconds(),
+		Samples:       len(samples),
+	}
+	last := samples[len(samples)-1].at
+	switch {
+	case current >= 1:
+		f.LimitReached = &last
+	case slope > 0:
+		reached := last.Add(time.Duration((1 - current) / slope * float64(time.Second)))
+		f.LimitReached = &reached
+	}
+	return f, true
+}
+
+// collectForecastMetrics reports when the deployment's memory usage is
+// forecast to reach its limit.
+func (t *DeploymentTracker) collectForecastMetrics(ns, name string, now time.Time) {
+	if !t.forecast {
+		return
+	}
+	f, ok := t.memory.forecast(ns + "/" + name)
+	if !ok || f.LimitReached == nil {
+		deploymentMemoryLimitETA.DeleteLabelValues(ns, name)
+		return
+	}
+	eta := f.LimitReached.Sub(now)
+	if eta < 0 {
+		eta = 0
+	}
+	deploymentMemoryLimitETA.WithLabelValues(ns, name).Set(eta.Seconds())
+}
+
+// handleForecast serves GET /api/v1/forecast[?namespace=X]: the memory
+// forecasts of deployments with enough usage history, those reaching their
+// limit soonest first.
+func (t *DeploymentTracker) handleForecast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !t.forecast {
+		http.Error(w, "Memory forecasts are not enabled (--memory-forecast).", http.StatusNotFound)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+
+	result := []memoryForecast{}
+	for _, key := range t.memory.keys() {
+		if namespace != "" && !strings.HasPrefix(key, namespace+"/") {
+			continue
+		}
+		if f, ok := t.memory.forecast(key); ok {
+			result = append(result, f)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if (a.LimitReached == nil) != (b.LimitReached == nil) {
+			return a.LimitReached != nil
+		}
+		if a.LimitReached != nil && !a.LimitReached.Equal(*b.LimitReached) {
+			return a.LimitReached.Before(*b.LimitReached)
+		}
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Deployment < b.Deployment
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
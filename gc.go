@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+var (
+	// Size of the downtime tracking state, which must not grow with
+	// deployment churn
+	exporterDowntimeStartEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "exporter_downtime_start_entries",
+			Help: "Number of deployments the exporter currently tracks as down, i.e. entries of its downtime start map",
+		},
+	)
+)
+
+// forgetDeployment drops everything the exporter keeps about a deleted
+// deployment. An incident still open ends at the deletion with the given
+// resolution, as nothing will resolve it anymore. The deployment's series
+// stay until they are deleted like those of any other deployment.
+func (t *DeploymentTracker) forgetDeployment(ns, name string, now time.Time, resolution string) {
+	key := ns + "/" + name
+	defer t.locks.lock(key)()
+
+	// Left to the next deployment with the same identity
+	t.handOff(ns, name, now)
+
+	down := t.downtimeStart.remove(key)
+	t.correctedStart.remove(key)
+	t.lastRecovery.remove(key)
+	exporterDowntimeStartEntries.Set(float64(t.downtimeStart.len()))
+
+	if t.incidents != nil && t.incidents.close(ns, name, now, nil, resolution) {
+		log.Printf("Deployment %s is gone while down (%s), closed its incident", key, resolution)
+	} else if down {
+		log.Printf("Deployment %s is gone while down (%s)", key, resolution)
+	}
+
+	t.events.forget(ns, name)
+	t.restarts.forget(ns, name)
+	t.cadvisor.forget(ns, name)
+	t.refresh.forget(ns, name)
+	t.usage.forget(ns, name)
+	t.conditions.forget(ns, name)
+	t.generations.forget(ns, name)
+	t.controller.forget(ns, name)
+	t.rates.forget(ns, name)
+	t.memory.forget(ns, name)
+	t.predictor.forget(ns, name)
+	t.baselines.forget(ns, name)
+	t.rollback.forget(ns, name)
+	t.gauges.forget(ns, name)
+	t.preview.forgotten(ns, name, now)
+	debugf("Forgot deleted deployment %s", key)
+}
+
+// sweepDeletedDeployments forgets tracked deployments missing from the
+// periodic list, for deletions the watch missed (e.g. while it was
+// reconnecting). A deployment only counts as deleted when two consecutive
+// lists miss it, as one created after the list may already be tracked from
+// its watch event. Deployments of namespaces that failed to list are kept.
+func (t *DeploymentTracker) sweepDeletedDeployments(listed []appsv1.Deployment, failed map[string]error, now time.Time) {
+	owned := make(map[string]bool, len(listed))
+	for _, deployment := range listed {
+		if t.ownsDeployment(deployment.Namespace, deployment.Name) {
+			owned[deployment.Namespace+"/"+deployment.Name] = true
+		}
+	}
+
+	exporterDowntimeStartEntries.Set(float64(t.downtimeStart.len()))
+
+	missing := make(map[string]bool)
+	for key := range t.trackedKeys() {
+		ns, name, _ := strings.Cut(key, "/")
+		if _, ok := failed[ns]; ok || owned[key] {
+			continue
+		}
+		if t.missing[key] {
+			t.forgetDeployment(ns, name, now, resolutionDeploymentDeleted)
+			continue
+		}
+		missing[key] = true
+	}
+	t.missing = missing
+}
+
+// trackedKeys returns the namespace/name keys of the deployments the
+// exporter keeps state or series handles of.
+func (t *DeploymentTracker) trackedKeys() map[string]bool {
+	tracked := make(map[string]bool)
+	for _, times := range []*timeMap{t.downtimeStart, t.correctedStart, t.lastRecovery} {
+		for key := range times.all() {
+			tracked[key] = true
+		}
+	}
+	for _, key := range t.gauges.keys() {
+		tracked[key] = true
+	}
+	return tracked
+}
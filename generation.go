@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+var (
+	// Spec changes the deployment controller hasn't acted on yet; a lag
+	// that persists means the controller is stuck, not the application
+	deploymentGenerationLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_generation_lag",
+			Help: "Difference between the deployment's metadata.generation and status.observedGeneration",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentGenerationLagSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_generation_lag_seconds",
+			Help: "Seconds the deployment's generation lag has been nonzero, 0 while the controller is caught up",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// generationLag remembers since when each deployment's observed generation
+// has been behind its generation.
+type generationLag struct {
+	mu    sync.Mutex
+	since map[string]time.Time // namespace/deployment -> first cycle with a lag
+}
+
+func newGenerationLag() *generationLag {
+	return &generationLag{since: make(map[string]time.Time)}
+}
+
+// forget drops the lag start of a deleted deployment.
+func (g *generationLag) forget(ns, name string) {
+	if g == nil {
+		return
+	}
+	key := ns + "/" + name
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.since, key)
+}
+
+// update records the deployment's current lag and returns how long it has
+// been nonzero. A lag already present when the exporter started counts from
+// the first cycle that saw it.
+func (g *generationLag) update(key string, lag int64, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if lag <= 0 {
+		delete(g.since, key)
+		return 0
+	}
+	since, ok := g.since[key]
+	if !ok {
+		since = now
+		g.since[key] = now
+	}
+	return now.Sub(since)
+}
+
+// duration returns how long the deployment's lag has been nonzero as of its
+// last update.
+func (g *generationLag) duration(key string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	since, ok := g.since[key]
+	if !ok {
+		return 0
+	}
+	return now.Sub(since)
+}
+
+// collectGenerationLagMetrics reports how far and for how long the
+// deployment controller is behind the deployment's spec.
+func (t *DeploymentTracker) collectGenerationLagMetrics(deployment *appsv1.Deployment, gauges *deploymentGauges, now time.Time) {
+	lag := deployment.Generation - deployment.Status.ObservedGeneration
+	if lag < 0 {
+		lag = 0
+	}
+	duration := t.generations.update(deployment.Namespace+"/"+deployment.Name, lag, now)
+	gauges.gauge(deploymentGenerationLag).Set(float64(lag))
+	gauges.gauge(deploymentGenerationLagSeconds).Set(duration.Seconds())
+}
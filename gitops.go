@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+var (
+	// Live pod template differs from the manifest in the GitOps source
+	deploymentSpecDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_spec_drift",
+			Help: "Whether the live pod template differs from the manifest declared in the git source (1 = drifted)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// manifestSource holds the Deployments declared in a directory of rendered
+// manifests, e.g. a checkout kept up to date by a git-sync sidecar.
+type manifestSource struct {
+	dir              string
+	defaultNamespace string
+
+	mu       sync.RWMutex
+	declared map[string]map[string]interface{} // namespace/name -> pod template
+}
+
+func newManifestSource(dir, defaultNamespace string) *manifestSource {
+	if defaultNamespace == "" {
+		defaultNamespace = "default"
+	}
+	return &manifestSource{dir: dir, defaultNamespace: defaultNamespace}
+}
+
+// reload re-reads every YAML/JSON file below the directory. On error the
+// previously loaded manifests are kept.
+func (m *manifestSource) reload() error {
+	declared := make(map[string]map[string]interface{})
+	err := filepath.WalkDir(m.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != m.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+			return m.loadFile(path, declared)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.declared = declared
+	m.mu.Unlock()
+	debugf("Loaded %d deployment manifests from %s", len(declared), m.dir)
+	return nil
+}
+
+// loadFile adds the Deployments of a (possibly multi-document) manifest file.
+func (m *manifestSource) loadFile(path string, declared map[string]map[string]interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if object["apiVersion"] != "apps/v1" || object["kind"] != "Deployment" {
+			continue
+		}
+
+		var deployment appsv1.Deployment
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, &deployment); err != nil {
+			return fmt.Errorf("parsing deployment in %s: %w", path, err)
+		}
+		template, err := normalizedTemplate(&deployment)
+		if err != nil {
+			return fmt.Errorf("parsing deployment in %s: %w", path, err)
+		}
+		ns := deployment.Namespace
+		if ns == "" {
+			ns = m.defaultNamespace
+		}
+		declared[ns+"/"+deployment.Name] = template
+	}
+}
+
+// template returns the declared pod template of a deployment, if any.
+func (m *manifestSource) template(namespace, name string) (map[string]interface{}, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	template, ok := m.declared[namespace+"/"+name]
+	return template, ok
+}
+
+// normalizedTemplate renders a pod template the way the API server
+// serializes it, so e.g. quantities compare equal regardless of notation.
+func normalizedTemplate(deployment *appsv1.Deployment) (map[string]interface{}, error) {
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(&deployment.Spec.Template)
+}
+
+// driftPath returns the path of the first field set in the declared value
+// that differs in the live value, or "" if there is none. Fields only present
+// in the live object (API server defaults, restartedAt annotations, injected
+// labels) are not drift.
+func driftPath(path string, declared, live interface{}) string {
+	switch d := declared.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return path
+		}
+		for key, value := range d {
+			if p := driftPath(path+"."+key, value, l[key]); p != "" {
+				return p
+			}
+		}
+		return ""
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return path
+		}
+		for i := range d {
+			if p := driftPath(fmt.Sprintf("%s[%d]", path, i), d[i], l[i]); p != "" {
+				return p
+			}
+		}
+		return ""
+	default:
+		if !reflect.DeepEqual(declared, live) {
+			return path
+		}
+		return ""
+	}
+}
+
+// collectDriftMetrics compares the live pod template with the declared one.
+// Deployments not found in the source get no series.
+func (t *DeploymentTracker) collectDriftMetrics(deployment *appsv1.Deployment) {
+	if t.manifests == nil {
+		return
+	}
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	declared, ok := t.manifests.template(ns, name)
+	if !ok {
+		deploymentSpecDrift.DeleteLabelValues(ns, name)
+		return
+	}
+	live, err := normalizedTemplate(deployment)
+	if err != nil {
+		log.Printf("Error converting pod template of deployment %s/%s: %v", ns, name, err)
+		return
+	}
+
+	drift := float64(0)
+	if path := driftPath("spec.template", declared, live); path != "" {
+		debugf("Deployment %s/%s drifted from git source at %s", ns, name, path)
+		drift = 1
+	}
+	deploymentSpecDrift.WithLabelValues(ns, name).Set(drift)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// gaugeKey identifies a child gauge of a deployment: its vector and, for
+// vectors labelled beyond namespace and deployment, the extra label value.
+type gaugeKey struct {
+	vec   *prometheus.GaugeVec
+	label string
+}
+
+// deploymentGauges caches the child gauges processDeployment sets for one
+// deployment. WithLabelValues hashes the label values and allocates the
+// label slice on every call, which adds up over every metric of every
+// deployment in each cycle. Children are created on first use so series
+// still only appear once they are set.
+type deploymentGauges struct {
+	namespace string
+	name      string
+
+	mu     sync.Mutex
+	gauges map[gaugeKey]prometheus.Gauge
+}
+
+// gauge returns the deployment's child of a namespace/deployment vector.
+func (g *deploymentGauges) gauge(vec *prometheus.GaugeVec) prometheus.Gauge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := gaugeKey{vec: vec}
+	gauge, ok := g.gauges[key]
+	if !ok {
+		gauge = vec.WithLabelValues(g.namespace, g.name)
+		g.gauges[key] = gauge
+	}
+	return gauge
+}
+
+// labelled returns the deployment's child of a namespace/deployment/label
+// vector.
+func (g *deploymentGauges) labelled(vec *prometheus.GaugeVec, label string) prometheus.Gauge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := gaugeKey{vec: vec, label: label}
+	gauge, ok := g.gauges[key]
+	if !ok {
+		gauge = vec.WithLabelValues(g.namespace, g.name, label)
+		g.gauges[key] = gauge
+	}
+	return gauge
+}
+
+// gaugeCache holds the cached child gauges of all deployments.
+type gaugeCache struct {
+	mu          sync.Mutex
+	deployments map[types.NamespacedName]*deploymentGauges
+}
+
+func newGaugeCache() *gaugeCache {
+	return &gaugeCache{deployments: make(map[types.NamespacedName]*deploymentGauges)}
+}
+
+func (c *gaugeCache) get(namespace, name string) *deploymentGauges {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	g, ok := c.deployments[key]
+	if !ok {
+		g = &deploymentGauges{namespace: namespace, name: name, gauges: make(map[gaugeKey]prometheus.Gauge)}
+		c.deployments[key] = g
+	}
+	return g
+}
+
+// forget drops a deployment's cached children. It must be called whenever
+// the deployment's series are deleted from the vectors, as cached children
+// would no longer be exposed.
+func (c *gaugeCache) forget(namespace, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.deployments, types.NamespacedName{Namespace: namespace, Name: name})
+}
+
+// keys returns the namespace/name keys of the deployments with cached
+// children.
+func (c *gaugeCache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.deployments))
+	for deployment := range c.deployments {
+		keys = append(keys, deployment.String())
+	}
+	return keys
+}
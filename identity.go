@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Annotation naming the logical service a deployment implements, stable
+// across deployments recreated under new names
+const identityAnnotation = "deployment-exporter/id"
+
+// How long the state of a deleted deployment waits for its successor
+const identityHandoffTTL = time.Hour
+
+var (
+	// Logical services whose deployments are recreated under new names (e.g.
+	// checkout-v12 replacing checkout-v11), so their history doesn't restart
+	// at zero with every deployment
+	deploymentIdentity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_identity",
+			Help: "Always 1; the identity (deployment-exporter/id annotation) of the deployment",
+		},
+		[]string{"namespace", "deployment", "identity"},
+	)
+
+	identityRestartCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_identity_restart_total",
+			Help: "Recoveries of the deployments with the identity, across deployments recreated under new names",
+		},
+		[]string{"namespace", "identity"},
+	)
+
+	identityDowntimeSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_identity_downtime_seconds_total",
+			Help: "Seconds the deployments with the identity were down until their recovery, across deployments recreated under new names",
+		},
+		[]string{"namespace", "identity"},
+	)
+)
+
+// handoff is the downtime state a deleted deployment leaves to the next
+// deployment with its identity.
+type handoff struct {
+	downtimeStart  time.Time // zero unless it was down
+	correctedStart time.Time
+	lastRecovery   time.Time
+	downtimes      []downInterval
+	from           string
+	expires        time.Time
+}
+
+// identities maps deployments to their identity and holds the state of
+// deleted ones until a deployment with the same identity takes it over.
+type identities struct {
+	mu           sync.Mutex
+	byDeployment map[string]string   // namespace/deployment -> identity
+	handoffs     map[string]*handoff // namespace/identity -> state of the deleted deployment
+}
+
+func newIdentities() *identities {
+	return &identities{
+		byDeployment: make(map[string]string),
+		handoffs:     make(map[string]*handoff),
+	}
+}
+
+func validateIdentity(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+// identityOf returns the deployment's identity, "" if it has none.
+func identityOf(d *appsv1.Deployment) string {
+	return strings.TrimSpace(d.Annotations[identityAnnotation])
+}
+
+// collectIdentityMetrics records the deployment's identity and takes over
+// the state a deleted deployment with the same identity left behind. The
+// caller holds the deployment's lock.
+func (t *DeploymentTracker) collectIdentityMetrics(deployment *appsv1.Deployment, now time.Time) {
+	if t.identities == nil {
+		return
+	}
+	ns, name := deployment.Namespace, deployment.Name
+	key := ns + "/" + name
+	id := identityOf(deployment)
+
+	t.identities.mu.Lock()
+	previous, known := t.identities.byDeployment[key]
+	if known && previous != id {
+		deploymentIdentity.DeleteLabelValues(ns, name, previous)
+	}
+	var state *handoff
+	if id == "" {
+		delete(t.identities.byDeployment, key)
+	} else {
+		t.identities.byDeployment[key] = id
+		state = t.identities.handoffs[ns+"/"+id]
+		delete(t.identities.handoffs, ns+"/"+id)
+	}
+	t.identities.mu.Unlock()
+
+	if id == "" {
+		return
+	}
+	deploymentIdentity.WithLabelValues(ns, name, id).Set(1)
+	if state == nil {
+		return
+	}
+
+	// Successors created before their predecessor was deleted keep their
+	// own downtime, but inherit the history
+	if _, down := t.downtimeStart.get(key); !down && !state.downtimeStart.IsZero() {
+		t.downtimeStart.set(key, state.downtimeStart)
+		t.correctedStart.set(key, state.correctedStart)
+		gauges := t.gauges.get(ns, name)
+		gauges.gauge(deploymentDowntimeStart).Set(float64(state.downtimeStart.Unix()))
+		gauges.gauge(deploymentCorrectedDowntimeStart).Set(float64(state.correctedStart.Unix()))
+		if !t.silenced(ns, name, now) {
+			t.incidents.start(ns, name, state.correctedStart, t.incidentCauses(deployment), "", deploymentConditions(deployment))
+		}
+	}
+	if last, ok := t.lastRecovery.get(key); !ok || last.Before(state.lastRecovery) {
+		t.lastRecovery.set(key, state.lastRecovery)
+	}
+	t.rates.inherit(key, state.downtimes)
+	log.Printf("Deployment %s took over the downtime history of %s (identity %s)", key, state.from, id)
+}
+
+// handOff keeps the state of a deleted deployment for the next deployment
+// with its identity. The caller holds the deployment's lock and forgets the
+// state afterwards.
+func (t *DeploymentTracker) handOff(ns, name string, now time.Time) {
+	if t.identities == nil {
+		return
+	}
+	key := ns + "/" + name
+	t.identities.mu.Lock()
+	defer t.identities.mu.Unlock()
+	id, ok := t.identities.byDeployment[key]
+	if !ok {
+		return
+	}
+	delete(t.identities.byDeployment, key)
+
+	state := &handoff{from: key, expires: now.Add(identityHandoffTTL)}
+	state.downtimeStart, _ = t.downtimeStart.get(key)
+	state.correctedStart, _ = t.correctedStart.get(key)
+	state.lastRecovery, _ = t.lastRecovery.get(key)
+	state.downtimes = t.rates.history(key)
+	t.identities.handoffs[ns+"/"+id] = state
+}
+
+// identityRecovered counts a recovery of the deployment towards its
+// identity.
+func (t *DeploymentTracker) identityRecovered(deployment *appsv1.Deployment, downtime time.Duration) {
+	if t.identities == nil {
+		return
+	}
+	if id := identityOf(deployment); id != "" {
+		identityRestartCount.WithLabelValues(deployment.Namespace, id).Inc()
+		identityDowntimeSeconds.WithLabelValues(deployment.Namespace, id).Add(downtime.Seconds())
+	}
+}
+
+// expireHandoffs drops the state of deleted deployments no successor took
+// over in time.
+func (t *DeploymentTracker) expireHandoffs(now time.Time) {
+	if t.identities == nil {
+		return
+	}
+	t.identities.mu.Lock()
+	defer t.identities.mu.Unlock()
+	for id, state := range t.identities.handoffs {
+		if now.After(state.expires) {
+			delete(t.identities.handoffs, id)
+			debugf("Downtime history of deleted deployment %s (identity %s) expired", state.from, id)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// Size of open incident groups
+	incidentGroupSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_incident_group_size",
+			Help: "Number of incidents in an open incident group, by probable cause (e.g. node:worker-3)",
+		},
+		[]string{"cause"},
+	)
+)
+
+// incidentGroup is a parent incident for incidents that started within the
+// grouping window and share a probable cause.
+type incidentGroup struct {
+	ID        uint64    `json:"id"`
+	Cause     string    `json:"cause"`
+	Start     time.Time `json:"start"`
+	Incidents []uint64  `json:"incidents"`
+	Open      int       `json:"open"`
+}
+
+// incidentCauses returns the probable causes of a deployment going down,
+// most specific first: the node all its unready pods run on, the ConfigMaps
+// and Secrets its pods use, and its namespace.
+func (t *DeploymentTracker) incidentCauses(deployment *appsv1.Deployment) []string {
+	var causes []string
+
+	pods, err := t.listDeploymentPods(deployment, podSelector(deployment))
+	if err != nil {
+		log.Printf("Error listing pods for deployment %s/%s: %v", deployment.Namespace, deployment.Name, err)
+	}
+	node := ""
+	for _, pod := range pods {
+		if ready, _ := podReady(pod); ready || pod.Spec.NodeName == "" {
+			continue
+		}
+		if node != "" && node != pod.Spec.NodeName {
+			node = ""
+			break
+		}
+		node = pod.Spec.NodeName
+	}
+	if node != "" {
+		causes = append(causes, "node:"+node)
+	}
+
+	if t.capacity.waitingPods(deployment.Namespace+"/"+deployment.Name) > 0 {
+		causes = append(causes, nodeCapacityCause)
+	}
+
+	causes = append(causes, configCauses(deployment.Namespace, &deployment.Spec.Template.Spec)...)
+	return append(causes, "namespace:"+deployment.Namespace)
+}
+
+// configCauses lists the ConfigMaps and Secrets referenced by a pod spec.
+func configCauses(namespace string, spec *corev1.PodSpec) []string {
+	seen := make(map[string]bool)
+	var causes []string
+	add := func(kind, name string) {
+		cause := kind + ":" + namespace + "/" + name
+		if name != "" && !seen[cause] {
+			seen[cause] = true
+			causes = append(causes, cause)
+		}
+	}
+
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil {
+			add("configmap", volume.ConfigMap.Name)
+		}
+		if volume.Secret != nil {
+			add("secret", volume.Secret.SecretName)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					add("configmap", source.ConfigMap.Name)
+				}
+				if source.Secret != nil {
+					add("secret", source.Secret.Name)
+				}
+			}
+		}
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, container := range containers {
+			for _, from := range container.EnvFrom {
+				if from.ConfigMapRef != nil {
+					add("configmap", from.ConfigMapRef.Name)
+				}
+				if from.SecretRef != nil {
+					add("secret", from.SecretRef.Name)
+				}
+			}
+			for _, env := range container.Env {
+				if env.ValueFrom == nil {
+					continue
+				}
+				if env.ValueFrom.ConfigMapKeyRef != nil {
+					add("configmap", env.ValueFrom.ConfigMapKeyRef.Name)
+				}
+				if env.ValueFrom.SecretKeyRef != nil {
+					add("secret", env.ValueFrom.SecretKeyRef.Name)
+				}
+			}
+		}
+	}
+	return causes
+}
+
+// groupIncident adds a new incident to the group of a recent incident sharing
+// its most specific cause, creating the group if needed. Must be called with
+// s.mu held.
+func (s *incidentStore) groupIncident(inc *incident) {
+	if s.groupWindow <= 0 {
+		return
+	}
+
+	for _, cause := range inc.Causes {
+		for i := len(s.incidents) - 1; i >= 0; i-- {
+			other := s.incidents[i]
+			if inc.Start.Sub(other.Start) > s.groupWindow {
+				break
+			}
+			if other == inc || !hasCause(other, cause) {
+				continue
+			}
+
+			group := s.group(other.GroupID)
+			if group == nil || group.Cause != cause {
+				if other.GroupID != 0 {
+					// Already grouped by a more specific cause
+					continue
+				}
+				s.nextGroupID++
+				group = &incidentGroup{ID: s.nextGroupID, Cause: cause, Start: other.Start}
+				s.groups = append(s.groups, group)
+				s.latestGroup[cause] = group.ID
+				s.addToGroup(group, other)
+				s.pruneGroups()
+			}
+			s.addToGroup(group, inc)
+			return
+		}
+	}
+}
+
+// group returns the group with the given ID, nil if it does not exist (any
+// more). Must be called with s.mu held.
+func (s *incidentStore) group(id uint64) *incidentGroup {
+	if id == 0 {
+		return nil
+	}
+	for i := len(s.groups) - 1; i >= 0; i-- {
+		if s.groups[i].ID == id {
+			return s.groups[i]
+		}
+	}
+	return nil
+}
+
+// pruneGroups drops the oldest closed groups beyond maxIncidents. Must be
+// called with s.mu held.
+func (s *incidentStore) pruneGroups() {
+	for i := 0; len(s.groups) > maxIncidents && i < len(s.groups); {
+		if s.groups[i].Open > 0 {
+			i++
+			continue
+		}
+		s.groups = append(s.groups[:i], s.groups[i+1:]...)
+	}
+}
+
+func (s *incidentStore) addToGroup(group *incidentGroup, inc *incident) {
+	inc.GroupID = group.ID
+	group.Incidents = append(group.Incidents, inc.ID)
+	if inc.End == nil {
+		group.Open++
+	}
+	if s.latestGroup[group.Cause] != group.ID {
+		return
+	}
+	incidentGroupSize.WithLabelValues(group.Cause).Set(float64(len(group.Incidents)))
+}
+
+// ungroupResolved updates the group of a resolved incident and closes it
+// once all its incidents are resolved. Must be called with s.mu held.
+func (s *incidentStore) ungroupResolved(inc *incident) {
+	group := s.group(inc.GroupID)
+	if group == nil {
+		return
+	}
+	group.Open--
+	if group.Open <= 0 && s.latestGroup[group.Cause] == group.ID {
+		delete(s.latestGroup, group.Cause)
+		incidentGroupSize.DeleteLabelValues(group.Cause)
+	}
+}
+
+func hasCause(inc *incident, cause string) bool {
+	for _, c := range inc.Causes {
+		if c == cause {
+			return true
+		}
+	}
+	return false
+}
+
+// handleIncidentGroups serves GET /api/v1/incident-groups[?state=open], newest
+// first.
+func (s *incidentStore) handleIncidentGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	onlyOpen := r.URL.Query().Get("state") == "open"
+
+	s.mu.Lock()
+	groups := make([]incidentGroup, 0)
+	for i := len(s.groups) - 1; i >= 0; i-- {
+		group := s.groups[i]
+		if onlyOpen && group.Open == 0 {
+			continue
+		}
+		copied := *group
+		copied.Incidents = append([]uint64(nil), group.Incidents...)
+		groups = append(groups, copied)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
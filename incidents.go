@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Number of incidents kept in memory for the incidents API
+const maxIncidents = 1000
+
+// incident is one downtime of a deployment.
+type incident struct {
+	ID              uint64     `json:"id"`
+	Namespace       string     `json:"namespace"`
+	Deployment      string     `json:"deployment"`
+	Start           time.Time  `json:"start"`
+	End             *time.Time `json:"end,omitempty"`
+	DurationSeconds float64    `json:"durationSeconds,omitempty"`
+	Causes          []string   `json:"causes,omitempty"`
+	GroupID         uint64     `json:"groupId,omitempty"`
+	TraceID         string     `json:"traceId,omitempty"` // trace of the cycle that detected the downtime
+
+	// Part of the duration the exporter was blind for, which is neither
+	// known downtime nor uptime
+	UnmonitoredSeconds float64 `json:"unmonitoredSeconds,omitempty"`
+
+	// The deployment's conditions when the downtime was detected and when
+	// it recovered, keeping the controller's explanation of what happened
+	StartConditions []incidentCondition `json:"startConditions,omitempty"`
+	EndConditions   []incidentCondition `json:"endConditions,omitempty"`
+
+	// Why an incident ended other than by recovery, e.g. "namespace deleted"
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// incidentCondition is a deployment condition captured for an incident.
+type incidentCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// deploymentConditions captures the deployment's current conditions.
+func deploymentConditions(d *appsv1.Deployment) []incidentCondition {
+	var conditions []incidentCondition
+	for _, condition := range d.Status.Conditions {
+		conditions = append(conditions, incidentCondition{
+			Type:    string(condition.Type),
+			Status:  string(condition.Status),
+			Reason:  condition.Reason,
+			Message: condition.Message,
+		})
+	}
+	return conditions
+}
+
+// conditionSummary describes the conditions that aren't True, e.g.
+// "Progressing=False (ProgressDeadlineExceeded: ReplicaSet "api-5d9f" has
+// timed out progressing.)".
+func conditionSummary(conditions []incidentCondition) string {
+	var parts []string
+	for _, c := range conditions {
+		if c.Status == "True" {
+			continue
+		}
+		part := c.Type + "=" + c.Status
+		switch {
+		case c.Reason != "" && c.Message != "":
+			part += " (" + c.Reason + ": " + c.Message + ")"
+		case c.Reason != "" || c.Message != "":
+			part += " (" + c.Reason + c.Message + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// incidentStore keeps the most recent incidents and indexes the open ones by
+// deployment.
+type incidentStore struct {
+	groupWindow time.Duration
+	blindSpots  *blindSpots
+
+	mu          sync.Mutex
+	nextID      uint64
+	incidents   []*incident          // oldest first
+	open        map[string]*incident // namespace/deployment -> open incident
+	nextGroupID uint64
+	groups      []*incidentGroup  // oldest first
+	latestGroup map[string]uint64 // cause -> newest group
+}
+
+func newIncidentStore(groupWindow time.Duration) *incidentStore {
+	return &incidentStore{
+		groupWindow: groupWindow,
+		open:        make(map[string]*incident),
+		latestGroup: make(map[string]uint64),
+	}
+}
+
+func (s *incidentStore) start(namespace, deployment string, start time.Time, causes []string, traceID string, conditions []incidentCondition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := namespace + "/" + deployment
+	if _, ok := s.open[key]; ok {
+		return
+	}
+	s.nextID++
+	inc := &incident{ID: s.nextID, Namespace: namespace, Deployment: deployment, Start: start, Causes: causes,
+		TraceID: traceID, StartConditions: conditions}
+	s.open[key] = inc
+	s.incidents = append(s.incidents, inc)
+	s.groupIncident(inc)
+
+	// Drop the oldest resolved incidents beyond the limit
+	for i := 0; len(s.incidents) > maxIncidents && i < len(s.incidents); {
+		if s.incidents[i].End == nil {
+			i++
+			continue
+		}
+		s.incidents = append(s.incidents[:i], s.incidents[i+1:]...)
+	}
+}
+
+// resolve closes the deployment's open incident and reports whether there
+// was one.
+func (s *incidentStore) resolve(namespace, deployment string, end time.Time, conditions []incidentCondition) bool {
+	return s.close(namespace, deployment, end, conditions, "")
+}
+
+// close ends the deployment's open incident with a resolution other than
+// recovery ("" for recovery) and reports whether there was one.
+func (s *incidentStore) close(namespace, deployment string, end time.Time, conditions []incidentCondition, resolution string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := namespace + "/" + deployment
+	inc, ok := s.open[key]
+	if !ok {
+		return false
+	}
+	delete(s.open, key)
+	inc.End = &end
+	inc.DurationSeconds = end.Sub(inc.Start).Seconds()
+	inc.UnmonitoredSeconds = s.blindSpots.overlap(inc.Start, end).Seconds()
+	inc.EndConditions = conditions
+	inc.Resolution = resolution
+	s.ungroupResolved(inc)
+	return true
+}
+
+// all returns copies of all incidents, oldest first, and the next incident
+// ID, for state snapshots.
+func (s *incidentStore) all() ([]incident, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]incident, 0, len(s.incidents))
+	for _, inc := range s.incidents {
+		copied := *inc
+		copied.Causes = append([]string(nil), inc.Causes...)
+		copied.GroupID = 0
+		result = append(result, copied)
+	}
+	return result, s.nextID
+}
+
+// restore replaces the incidents with ones from a state snapshot. Incident
+// groups aren't carried over.
+func (s *incidentStore) restore(incidents []incident, nextID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.incidents = nil
+	s.open = make(map[string]*incident)
+	for i := range incidents {
+		inc := incidents[i]
+		s.incidents = append(s.incidents, &inc)
+		if inc.End == nil {
+			s.open[inc.Namespace+"/"+inc.Deployment] = &inc
+		}
+		if inc.ID > nextID {
+			nextID = inc.ID
+		}
+	}
+	s.nextID = nextID
+}
+
+// list returns copies of the incidents matching the filters, newest first.
+func (s *incidentStore) list(namespace, state string) []incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]incident, 0)
+	for i := len(s.incidents) - 1; i >= 0; i-- {
+		inc := s.incidents[i]
+		if namespace != "" && inc.Namespace != namespace {
+			continue
+		}
+		if (state == "open" && inc.End != nil) || (state == "resolved" && inc.End == nil) {
+			continue
+		}
+		copied := *inc
+		copied.Causes = append([]string(nil), inc.Causes...)
+		result = append(result, copied)
+	}
+	return result
+}
+
+// handleIncidents serves GET /api/v1/incidents[?namespace=X][&state=open|resolved].
+func (s *incidentStore) handleIncidents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", "open", "resolved":
+	default:
+		http.Error(w, fmt.Sprintf("invalid state %q, must be open or resolved", state), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.list(r.URL.Query().Get("namespace"), state))
+}
+
+// recordDown opens an incident for the deployment and reports it.
+func (t *DeploymentTracker) recordDown(ctx context.Context, d *appsv1.Deployment, now time.Time) {
+	namespace, deployment := d.Namespace, d.Name
+	if t.silenced(namespace, deployment, time.Now()) {
+		log.Printf("Deployment %s/%s went down (silenced, no incident or notification)", namespace, deployment)
+		return
+	}
+	id := traceID(ctx)
+	conditions := deploymentConditions(d)
+	t.incidents.start(namespace, deployment, now, t.incidentCauses(d), id, conditions)
+
+	message := fmt.Sprintf("Deployment %s/%s went down", namespace, deployment)
+	if summary := conditionSummary(conditions); summary != "" {
+		message += ": " + summary
+	}
+	t.events.add(event{
+		Type:       eventDown,
+		Namespace:  namespace,
+		Deployment: deployment,
+		Time:       now,
+		Message:    message,
+		TraceID:    id,
+		Conditions: conditions,
+	})
+}
+
+// recordRecovery resolves the deployment's incident and reports it. Downtimes
+// that started while silenced have no incident and aren't reported.
+func (t *DeploymentTracker) recordRecovery(ctx context.Context, d *appsv1.Deployment, now time.Time, downtime time.Duration) {
+	namespace, deployment := d.Namespace, d.Name
+	conditions := deploymentConditions(d)
+	if !t.incidents.resolve(namespace, deployment, now, conditions) {
+		return
+	}
+	t.events.add(event{
+		Type:            eventRecovered,
+		Namespace:       namespace,
+		Deployment:      deployment,
+		Time:            now,
+		DowntimeSeconds: downtime.Seconds(),
+		Message: fmt.Sprintf("Deployment %s/%s recovered after %.2fs (%.0fms)",
+			namespace, deployment, downtime.Seconds(), float64(downtime.Milliseconds())),
+		TraceID:    traceID(ctx),
+		Conditions: conditions,
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Default time after which an injected state expires, so a forgotten test
+// doesn't silence a real outage
+const defaultInjectDuration = 10 * time.Minute
+
+// injectedState is a forced ready state of a deployment.
+type injectedState struct {
+	ready   bool
+	expires time.Time
+}
+
+// injector forces the tracker's view of deployments for end-to-end tests of
+// alert pipelines: an injected down state opens an incident and sends
+// notifications like a real outage, without breaking the workload.
+type injector struct {
+	tracker *DeploymentTracker
+	enabled bool
+	token   string
+
+	mu     sync.Mutex
+	states map[string]injectedState
+}
+
+func newInjector(tracker *DeploymentTracker, opts *options) *injector {
+	return &injector{
+		tracker: tracker,
+		enabled: opts.enableDebugInject,
+		token:   opts.lifecycleToken,
+		states:  make(map[string]injectedState),
+	}
+}
+
+// injected returns the forced ready state of a deployment, if any.
+func (i *injector) injected(key string, now time.Time) (bool, bool) {
+	if i == nil {
+		return false, false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	state, ok := i.states[key]
+	if !ok {
+		return false, false
+	}
+	if !now.Before(state.expires) {
+		delete(i.states, key)
+		return false, false
+	}
+	return state.ready, true
+}
+
+// handleInject serves POST /api/v1/debug/inject?namespace=X&deployment=Y&state=down|up|clear[&duration=10m].
+func (i *injector) handleInject(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAction(w, r, i.enabled, i.token, "Debug inject API is not enabled.") {
+		return
+	}
+	query := r.URL.Query()
+	namespace, name := query.Get("namespace"), query.Get("deployment")
+	if namespace == "" || name == "" {
+		http.Error(w, "namespace and deployment are required", http.StatusBadRequest)
+		return
+	}
+	// Deployments of other namespaces or shards have no caches or state here
+	if !i.tracker.tracksNamespace(namespace) || !i.tracker.ownsDeployment(namespace, name) {
+		http.Error(w, fmt.Sprintf("deployment %s/%s is not tracked by this exporter", namespace, name), http.StatusNotFound)
+		return
+	}
+	duration := defaultInjectDuration
+	if value := query.Get("duration"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid duration %q", value), http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+
+	key := namespace + "/" + name
+	i.mu.Lock()
+	switch state := query.Get("state"); state {
+	case "down", "up":
+		i.states[key] = injectedState{ready: state == "up", expires: time.Now().Add(duration)}
+		log.Printf("Injected state %s for deployment %s for %s", state, key, duration)
+	case "clear":
+		delete(i.states, key)
+		log.Printf("Cleared injected state of deployment %s", key)
+	default:
+		i.mu.Unlock()
+		http.Error(w, fmt.Sprintf("invalid state %q, must be down, up or clear", state), http.StatusBadRequest)
+		return
+	}
+	i.mu.Unlock()
+
+	// Apply it right away instead of waiting for the next scrape
+	deployment, err := i.tracker.clientsetFor(namespace).AppsV1().Deployments(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("state recorded, but deployment could not be processed: %v", err), http.StatusAccepted)
+		return
+	}
+	i.tracker.processDeployment(r.Context(), deployment)
+	fmt.Fprintln(w, "OK")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Value recorded in the coordination ConfigMap for an instance tracking all
+// namespaces
+const allNamespaces = "*"
+
+const (
+	// How often an instance renews its entry in the coordination ConfigMap
+	instanceHeartbeatInterval = time.Minute
+
+	// Entries not renewed for this long belong to instances that were
+	// killed without removing them, and are removed by the other instances
+	instanceStaleAfter = 5 * instanceHeartbeatInterval
+)
+
+// Instance IDs are ConfigMap keys
+var instanceIDRegexp = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+var (
+	// Other exporter instances tracking overlapping namespaces
+	exporterInstanceOverlaps = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "exporter_instance_overlaps",
+			Help: "Number of other live exporter instances found in the coordination ConfigMap tracking namespaces that overlap with this instance",
+		},
+	)
+)
+
+// instanceEntry is the value of an instance's key in the coordination
+// ConfigMap. Entries of older versions hold just the namespaces and have no
+// heartbeat.
+type instanceEntry struct {
+	Namespaces string    `json:"namespaces"`
+	Heartbeat  time.Time `json:"heartbeat"`
+}
+
+func parseInstanceEntry(value string) instanceEntry {
+	var entry instanceEntry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		return instanceEntry{Namespaces: value}
+	}
+	return entry
+}
+
+// validateInstanceID checks that the ID can be used as a ConfigMap key.
+func validateInstanceID(id string) error {
+	if len(id) > 253 {
+		return fmt.Errorf("instance-id must be at most 253 characters, got %d", len(id))
+	}
+	if !instanceIDRegexp.MatchString(id) {
+		return fmt.Errorf("instance-id %q must consist of alphanumeric characters, '-', '_' or '.'", id)
+	}
+	return nil
+}
+
+// instanceRegistration keeps this instance's entry in the coordination
+// ConfigMap, recording which namespaces it tracks, so that instances whose
+// namespaces overlap (and would count the same restarts) are warned about.
+type instanceRegistration struct {
+	clientset   kubernetes.Interface
+	cmNamespace string
+	cmName      string
+	id          string
+	tracked     string
+
+	// Other instances already warned about
+	warned map[string]bool
+}
+
+func newInstanceRegistration(clientset kubernetes.Interface, cmNamespace, cmName, instanceID, namespace string) *instanceRegistration {
+	tracked := strings.Join(splitList(namespace), ",")
+	if tracked == "" {
+		tracked = allNamespaces
+	}
+	return &instanceRegistration{
+		clientset:   clientset,
+		cmNamespace: cmNamespace,
+		cmName:      cmName,
+		id:          instanceID,
+		tracked:     tracked,
+		warned:      make(map[string]bool),
+	}
+}
+
+// register writes this instance's entry with a fresh heartbeat, removes the
+// stale entries of other instances and warns about the live ones whose
+// namespaces overlap.
+func (r *instanceRegistration) register(now time.Time) error {
+	value, err := json.Marshal(instanceEntry{Namespaces: r.tracked, Heartbeat: now.UTC()})
+	if err != nil {
+		return err
+	}
+
+	var others map[string]instanceEntry
+	err = r.update(func(data map[string]string) {
+		others = make(map[string]instanceEntry, len(data))
+		for id, v := range data {
+			if id == r.id {
+				continue
+			}
+			entry := parseInstanceEntry(v)
+			if !entry.Heartbeat.IsZero() && now.Sub(entry.Heartbeat) > instanceStaleAfter {
+				log.Printf("Removing exporter instance %q from %s/%s, its entry was last renewed %s", id, r.cmNamespace, r.cmName, entry.Heartbeat.Format(time.RFC3339))
+				delete(data, id)
+				continue
+			}
+			others[id] = entry
+		}
+		data[r.id] = string(value)
+	})
+	if err != nil {
+		return err
+	}
+
+	overlaps := 0
+	for id, entry := range others {
+		ns := entry.Namespaces
+		if ns != allNamespaces && r.tracked != allNamespaces && !sharesNamespace(ns, r.tracked) {
+			continue
+		}
+		overlaps++
+		if !r.warned[id] {
+			r.warned[id] = true
+			log.Printf("Warning: exporter instance %q also tracks namespace %q which overlaps with this instance (%q tracking %q); restart counters will be double-counted",
+				id, ns, r.id, r.tracked)
+		}
+	}
+	exporterInstanceOverlaps.Set(float64(overlaps))
+	return nil
+}
+
+// run renews the entry until stop is closed.
+func (r *instanceRegistration) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(instanceHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := r.register(now); err != nil {
+				log.Printf("Warning: Could not renew exporter instance %q in %s/%s: %v", r.id, r.cmNamespace, r.cmName, err)
+			}
+		}
+	}
+}
+
+// unregister removes this instance's entry on shutdown.
+func (r *instanceRegistration) unregister() error {
+	return r.update(func(data map[string]string) {
+		delete(data, r.id)
+	})
+}
+
+// update applies change to the ConfigMap's data, creating the ConfigMap if
+// it doesn't exist yet, and retries on conflicts with other instances.
+func (r *instanceRegistration) update(change func(data map[string]string)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMaps := r.clientset.CoreV1().ConfigMaps(r.cmNamespace)
+		cm, err := configMaps.Get(context.Background(), r.cmName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			data := make(map[string]string)
+			change(data)
+			if len(data) == 0 {
+				return nil
+			}
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: r.cmName, Namespace: r.cmNamespace},
+				Data:       data,
+			}
+			_, err = configMaps.Create(context.Background(), cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), r.cmName, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		change(cm.Data)
+		_, err = configMaps.Update(context.Background(), cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// sharesNamespace reports whether two comma-separated namespace lists have a
+// namespace in common.
+func sharesNamespace(a, b string) bool {
+	for _, x := range splitList(a) {
+		for _, y := range splitList(b) {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
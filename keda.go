@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// KEDA defaults for unset ScaledObject replica bounds
+const (
+	kedaDefaultMinReplicas = 0
+	kedaDefaultMaxReplicas = 100
+)
+
+var (
+	// Scaler types of the ScaledObjects targeting the deployment
+	deploymentKEDAScaler = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_keda_scaler_info",
+			Help: "Trigger types of the KEDA ScaledObject scaling the deployment (always 1)",
+		},
+		[]string{"namespace", "deployment", "scaledobject", "scaler"},
+	)
+
+	deploymentKEDAMinReplicas = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_keda_min_replicas",
+			Help: "Minimum replica count of the KEDA ScaledObject scaling the deployment",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentKEDAMaxReplicas = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_keda_max_replicas",
+			Help: "Maximum replica count of the KEDA ScaledObject scaling the deployment",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentKEDAPaused = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_keda_paused",
+			Help: "Whether autoscaling of the deployment is paused in its KEDA ScaledObject (1 = paused)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentKEDAActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_keda_active",
+			Help: "Whether the KEDA ScaledObject scaling the deployment is active, i.e. its triggers report load (1 = active)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Failing triggers stop scaling without taking the deployment down
+	deploymentKEDATriggerError = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_keda_trigger_error",
+			Help: "Whether a trigger of the KEDA ScaledObject scaling the deployment is failing (1 = failing)",
+		},
+		[]string{"namespace", "deployment", "trigger"},
+	)
+)
+
+// scaledObjectCondition returns the status of a ScaledObject condition
+// ("True", "False", "Unknown") and its message, or "" if it isn't set.
+func scaledObjectCondition(so *unstructured.Unstructured, conditionType string) (string, string) {
+	conditions, _, _ := unstructured.NestedSlice(so.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		message, _ := condition["message"].(string)
+		return status, message
+	}
+	return "", ""
+}
+
+// scaledObjectPaused reports whether KEDA's pause annotations or Paused
+// condition are set.
+func scaledObjectPaused(so *unstructured.Unstructured) bool {
+	annotations := so.GetAnnotations()
+	if _, ok := annotations["autoscaling.keda.sh/paused-replicas"]; ok {
+		return true
+	}
+	if annotations["autoscaling.keda.sh/paused"] == "true" {
+		return true
+	}
+	status, _ := scaledObjectCondition(so, "Paused")
+	return status == "True"
+}
+
+// triggerErrors returns, per trigger, whether it is failing. KEDA reports
+// per-trigger health in status.health keyed by metric names prefixed with
+// "s<index>-"; triggers without health entries are failing when the
+// ScaledObject's Ready condition is false, since KEDA doesn't say which one
+// broke.
+func triggerErrors(so *unstructured.Unstructured, triggers []interface{}) map[string]bool {
+	health, _, _ := unstructured.NestedMap(so.Object, "status", "health")
+	ready, message := scaledObjectCondition(so, "Ready")
+
+	failingTriggers := make(map[string]bool, len(triggers))
+	for i, tr := range triggers {
+		trigger, ok := tr.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		scaler, _ := trigger["type"].(string)
+		label, _ := trigger["name"].(string)
+		if label == "" {
+			label = fmt.Sprintf("s%d-%s", i, scaler)
+		}
+
+		prefix := fmt.Sprintf("s%d-", i)
+		reported := false
+		failing := false
+		for metricName, h := range health {
+			if !strings.HasPrefix(metricName, prefix) {
+				continue
+			}
+			reported = true
+			if entry, ok := h.(map[string]interface{}); ok && entry["status"] == "Failing" {
+				failing = true
+			}
+		}
+		if !reported {
+			failing = ready == "False"
+		}
+		if failing {
+			debugf("KEDA trigger %s of ScaledObject %s/%s is failing: %s", label, so.GetNamespace(), so.GetName(), message)
+		}
+		failingTriggers[label] = failing
+	}
+	return failingTriggers
+}
+
+// kedaScaledToZero reports whether a deployment at zero replicas was scaled
+// there by KEDA on purpose rather than being down.
+func (t *DeploymentTracker) kedaScaledToZero(deployment *appsv1.Deployment) bool {
+	return t.keda && len(t.scaledObjects(deployment)) > 0
+}
+
+// collectKEDAMetrics exports the configuration and state of the KEDA
+// ScaledObject scaling the deployment.
+func (t *DeploymentTracker) collectKEDAMetrics(deployment *appsv1.Deployment) {
+	if !t.keda {
+		return
+	}
+	ns := deployment.Namespace
+	name := deployment.Name
+	labels := prometheus.Labels{"namespace": ns, "deployment": name}
+	deploymentKEDAScaler.DeletePartialMatch(labels)
+	deploymentKEDATriggerError.DeletePartialMatch(labels)
+
+	scaledObjects := t.scaledObjects(deployment)
+	if len(scaledObjects) == 0 {
+		deploymentKEDAMinReplicas.DeleteLabelValues(ns, name)
+		deploymentKEDAMaxReplicas.DeleteLabelValues(ns, name)
+		deploymentKEDAPaused.DeleteLabelValues(ns, name)
+		deploymentKEDAActive.DeleteLabelValues(ns, name)
+		return
+	}
+	// KEDA rejects a second ScaledObject for the same target
+	so := scaledObjects[0]
+
+	triggers, _, _ := unstructured.NestedSlice(so.Object, "spec", "triggers")
+	for _, tr := range triggers {
+		trigger, ok := tr.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if scaler, _ := trigger["type"].(string); scaler != "" {
+			deploymentKEDAScaler.WithLabelValues(ns, name, so.GetName(), scaler).Set(1)
+		}
+	}
+
+	for trigger, failing := range triggerErrors(so, triggers) {
+		value := float64(0)
+		if failing {
+			value = 1
+		}
+		deploymentKEDATriggerError.WithLabelValues(ns, name, trigger).Set(value)
+	}
+
+	minReplicas, found, _ := unstructured.NestedInt64(so.Object, "spec", "minReplicaCount")
+	if !found {
+		minReplicas = kedaDefaultMinReplicas
+	}
+	maxReplicas, found, _ := unstructured.NestedInt64(so.Object, "spec", "maxReplicaCount")
+	if !found {
+		maxReplicas = kedaDefaultMaxReplicas
+	}
+	deploymentKEDAMinReplicas.WithLabelValues(ns, name).Set(float64(minReplicas))
+	deploymentKEDAMaxReplicas.WithLabelValues(ns, name).Set(float64(maxReplicas))
+
+	paused := float64(0)
+	if scaledObjectPaused(so) {
+		paused = 1
+	}
+	deploymentKEDAPaused.WithLabelValues(ns, name).Set(paused)
+
+	active := float64(0)
+	if status, _ := scaledObjectCondition(so, "Active"); status == "True" {
+		active = 1
+	}
+	deploymentKEDAActive.WithLabelValues(ns, name).Set(active)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// Requests rejected by API server priority and fairness or max-inflight
+	// limits
+	exporterKubeAPIThrottled = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "exporter_kube_api_throttled_total",
+			Help: "Number of Kubernetes API requests answered with 429 Too Many Requests",
+		},
+	)
+
+	// Same count, readable without going through the registry
+	kubeAPIThrottledCount atomic.Uint64
+
+	// Latency of the API server itself, to tell a slow control plane apart
+	// from slow workloads when heartbeats go stale
+	exporterKubeAPIRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "exporter_kube_api_request_duration_seconds",
+			Help:    "Duration in seconds of Kubernetes API requests until the response headers arrived (watches excluded)",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"verb", "resource"},
+	)
+)
+
+// throttleDetectingTransport times requests and counts 429 responses.
+// client-go itself retries them after the server's Retry-After delay.
+type throttleDetectingTransport struct {
+	next http.RoundTripper
+}
+
+func (rt *throttleDetectingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	if verb, resource := requestVerbResource(req); verb != "watch" {
+		exporterKubeAPIRequestDuration.WithLabelValues(verb, resource).Observe(time.Since(start).Seconds())
+	}
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		exporterKubeAPIThrottled.Inc()
+		kubeAPIThrottledCount.Add(1)
+		debugf("Kubernetes API throttled %s %s (flow schema %s, retry after %ss)", req.Method, req.URL.Path,
+			resp.Header.Get("X-Kubernetes-PF-FlowSchema-UID"), resp.Header.Get("Retry-After"))
+	}
+	return resp, err
+}
+
+// requestVerbResource derives the Kubernetes API verb and resource of a
+// request from its method and path, e.g. list pods for GET
+// /api/v1/namespaces/x/pods. Resources outside the core group are qualified
+// with their group (pods.metrics.k8s.io) and subresources are appended
+// (nodes/proxy).
+func requestVerbResource(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	group := ""
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		group = parts[1]
+		parts = parts[3:]
+	default:
+		return strings.ToLower(req.Method), "other"
+	}
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	if len(parts) == 0 {
+		return strings.ToLower(req.Method), "other"
+	}
+
+	resource := parts[0]
+	if group != "" {
+		resource += "." + group
+	}
+	if len(parts) > 2 {
+		resource += "/" + parts[2]
+	}
+
+	named := len(parts) > 1
+	verb := strings.ToLower(req.Method)
+	switch req.Method {
+	case http.MethodGet:
+		switch {
+		case req.URL.Query().Get("watch") == "true" || req.URL.Query().Get("watch") == "1":
+			verb = "watch"
+		case named:
+			verb = "get"
+		default:
+			verb = "list"
+		}
+	case http.MethodPost:
+		verb = "create"
+	case http.MethodPut:
+		verb = "update"
+	}
+	return verb, resource
+}
+
+// instrumentTransport wraps the client transport of every client created
+// from config.
+func instrumentTransport(config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &throttleDetectingTransport{next: rt}
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Settings /-/reload applies without a restart
+var reloadableSettings = map[string]bool{
+	"log-level": true,
+}
+
+// lifecycle serves the Prometheus-style /-/healthy, /-/ready, /-/reload and
+// /-/quit endpoints. Reload and quit change the exporter's state, so they are
+// only enabled with --enable-lifecycle and, with --lifecycle-token, require
+// that bearer token.
+type lifecycle struct {
+	tracker *DeploymentTracker
+	flags   *flag.FlagSet
+	args    []string
+	enabled bool
+	token   string
+	quit    chan struct{}
+
+	mu       sync.Mutex
+	quitOnce sync.Once
+}
+
+func newLifecycle(tracker *DeploymentTracker, flags *flag.FlagSet, args []string, opts *options) *lifecycle {
+	return &lifecycle{
+		tracker: tracker,
+		flags:   flags,
+		args:    args,
+		enabled: opts.enableLifecycle,
+		token:   opts.lifecycleToken,
+		quit:    make(chan struct{}),
+	}
+}
+
+func (l *lifecycle) handleHealthy(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Exporter is Healthy.")
+}
+
+// handleReady reports ready once the first periodic scrape has populated
+// the metrics.
+func (l *lifecycle) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !l.tracker.ready.Load() {
+		http.Error(w, "Exporter is not ready.", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "Exporter is Ready.")
+}
+
+func (l *lifecycle) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAction(w, r, l.enabled, l.token, "Lifecycle API is not enabled.") {
+		return
+	}
+	if err := l.reload(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "Config reloaded.")
+}
+
+func (l *lifecycle) handleQuit(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAction(w, r, l.enabled, l.token, "Lifecycle API is not enabled.") {
+		return
+	}
+	fmt.Fprintln(w, "Requesting termination... Goodbye!")
+	log.Printf("Termination requested via /-/quit")
+	l.quitOnce.Do(func() { close(l.quit) })
+}
+
+// authorizeAction checks that state-changing actions are enabled, the method
+// is POST or PUT and the token (if set) matches, and writes the error
+// response otherwise. A write-scoped API token stands in for the token.
+func authorizeAction(w http.ResponseWriter, r *http.Request, enabled bool, token, disabled string) bool {
+	if !enabled {
+		http.Error(w, disabled, http.StatusForbidden)
+		return false
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return authorizeToken(w, r, token)
+}
+
+// guardWrites serves GET and HEAD requests of an endpoint that both reads
+// and changes state, and requires other methods to be enabled and to carry
+// the token (if set) like authorizeAction.
+func guardWrites(handler http.HandlerFunc, enabled bool, token, disabled string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if !enabled {
+				http.Error(w, disabled, http.StatusForbidden)
+				return
+			}
+			if !authorizeToken(w, r, token) {
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// authorizeToken checks the token of a state-changing action, if set.
+func authorizeToken(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token != "" && !writeAuthorized(r) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
+		}
+	}
+	return true
+}
+
+// reload re-reads the command line and --config file, applies the settings
+// that can change at runtime and re-reads the git source. Other changed
+// settings are reported as needing a restart.
+func (l *lifecycle) reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	opts := &options{}
+	opts.bindFlags(fs)
+	if err := fs.Parse(l.args); err != nil {
+		return err
+	}
+	if opts.configFile != "" {
+		if err := loadConfigFile(fs, opts.configFile); err != nil {
+			return err
+		}
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	var restart []string
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		current := l.flags.Lookup(f.Name)
+		if current == nil || current.Value.String() == f.Value.String() {
+			return
+		}
+		if !reloadableSettings[f.Name] {
+			restart = append(restart, f.Name)
+			return
+		}
+		if err := current.Value.Set(f.Value.String()); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		switch f.Name {
+		case "log-level":
+			errs = append(errs, setLogLevel(f.Value.String()))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if err := l.tracker.schedules.reload(); err != nil {
+		return err
+	}
+	if err := l.tracker.overrides.reload(); err != nil {
+		return err
+	}
+	if l.tracker.manifests != nil {
+		if err := l.tracker.manifests.reload(); err != nil {
+			return err
+		}
+	}
+
+	if len(restart) > 0 {
+		log.Printf("Config reloaded; changed settings that need a restart: %s", strings.Join(restart, ", "))
+	} else {
+		log.Printf("Config reloaded")
+	}
+	return nil
+}
+
+// quitOnSignal shuts the exporter down gracefully on SIGTERM or SIGINT, as
+// the /-/quit endpoint does.
+func (l *lifecycle) quitOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	log.Printf("Termination requested by %s", sig)
+	l.quitOnce.Do(func() { close(l.quit) })
+}
+
+// reloadOnSignal reloads the config on every SIGHUP.
+func (l *lifecycle) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := l.reload(); err != nil {
+			log.Printf("Error reloading config (SIGHUP): %v", err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// First file descriptor passed by systemd socket activation (SD_LISTEN_FDS_START)
+const systemdListenFDsStart = 3
+
+// listenAll opens a listener for every address in the comma-separated
+// --metrics-addr list, closing the ones already opened if one fails.
+func listenAll(addrs string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range splitList(addrs) {
+		l, err := listen(addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("listening on %s: %w", addr, err)
+		}
+		listeners = append(listeners, l...)
+	}
+	return listeners, nil
+}
+
+// listen opens the listeners for one --metrics-addr item: "unix:///path" (or
+// "unix:/path") for a Unix domain socket, "systemd" for the sockets passed by
+// systemd socket activation, anything else is a TCP host:port.
+func listen(addr string) ([]net.Listener, error) {
+	switch {
+	case addr == "systemd":
+		return systemdListeners()
+	case strings.HasPrefix(addr, "unix:"):
+		l, err := unixListener(unixSocketPath(addr))
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	default:
+		l, err := net.Listen(tcpNetwork(addr), addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+}
+
+// tcpNetwork picks the address family for a host:port. A literal IPv4 or
+// IPv6 host binds only that family, so "0.0.0.0:9101,[::]:9101" gives two
+// separate binds on the same port; an empty host or a hostname binds dual
+// stack where the system supports it.
+func tcpNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// validateListenAddr checks one --metrics-addr item without binding it.
+func validateListenAddr(addr string) error {
+	switch {
+	case addr == "systemd":
+		return nil
+	case strings.HasPrefix(addr, "unix:"):
+		if unixSocketPath(addr) == "" {
+			return fmt.Errorf("metrics-addr %q has no socket path", addr)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if strings.Count(addr, ":") > 1 && !strings.HasPrefix(addr, "[") {
+			return fmt.Errorf("metrics-addr %q: IPv6 addresses must be written in brackets, e.g. [::]:9101", addr)
+		}
+		return fmt.Errorf("metrics-addr %q: %w", addr, err)
+	}
+	return nil
+}
+
+// unixSocketPath strips the unix: or unix:// prefix from addr.
+func unixSocketPath(addr string) string {
+	path := strings.TrimPrefix(addr, "unix:")
+	return strings.TrimPrefix(path, "//")
+}
+
+func unixListener(path string) (net.Listener, error) {
+	// A socket left behind by a previous run that didn't shut down cleanly
+	// would make the bind fail
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket %s: %w", path, err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The socket file is removed again when the listener is closed
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+	return listener, nil
+}
+
+// systemdListeners returns the sockets passed by systemd, see
+// sd_listen_fds(3). The environment is cleared so child processes don't
+// inherit it.
+func systemdListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_PID not set to this process)")
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_FDS not set)")
+	}
+
+	listeners := make([]net.Listener, 0, fds)
+	for fd := systemdListenFDsStart; fd < systemdListenFDsStart+fds; fd++ {
+		file := os.NewFile(uintptr(fd), "systemd-socket")
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("using systemd socket %d: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
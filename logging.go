@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Supported log levels
+const (
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+)
+
+// debugLogging enables verbose watch and event logging. It can be changed at
+// runtime so diagnosing an issue doesn't require a restart, which would lose
+// the in-memory downtime state.
+var debugLogging atomic.Bool
+
+// debugf logs only when the debug level is enabled.
+func debugf(format string, args ...interface{}) {
+	if debugLogging.Load() {
+		log.Printf("[debug] "+format, args...)
+	}
+}
+
+func currentLogLevel() string {
+	if debugLogging.Load() {
+		return logLevelDebug
+	}
+	return logLevelInfo
+}
+
+// parseLogLevel reports whether level enables debug logging.
+func parseLogLevel(level string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case logLevelInfo:
+		return false, nil
+	case logLevelDebug:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown log level %q (want %q or %q)", level, logLevelInfo, logLevelDebug)
+	}
+}
+
+func setLogLevel(level string) error {
+	debug, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	debugLogging.Store(debug)
+	return nil
+}
+
+// handleLogLevel serves GET (current level) and PUT (change level, body or
+// ?level= parameter) on /-/loglevel.
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		level := r.URL.Query().Get("level")
+		if level == "" {
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			level = string(body)
+		}
+		if err := setLogLevel(level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Log level changed to %s", currentLogLevel())
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, currentLogLevel())
+}
+
+// toggleDebugOnSignal switches between info and debug on every SIGUSR1.
+func toggleDebugOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	for range signals {
+		debugLogging.Store(!debugLogging.Load())
+		log.Printf("Log level changed to %s (SIGUSR1)", currentLogLevel())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Annotation naming the logical service a deployment is a member of, e.g.
+// checkout for checkout-v1 and checkout-v2
+const logicalServiceAnnotation = "deployment-exporter/service"
+
+var (
+	// Availability of logical services implemented by several equivalent
+	// deployments (versions, canaries, blue/green pairs), which is up while
+	// any of them is
+	logicalServiceUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_service_up",
+			Help: "Whether any member deployment of the logical service is up (1) or all of them are down (0)",
+		},
+		[]string{"namespace", "service"},
+	)
+
+	logicalServiceMembers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_service_deployments",
+			Help: "Number of member deployments of the logical service",
+		},
+		[]string{"namespace", "service"},
+	)
+
+	logicalServiceMembersUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_service_deployments_up",
+			Help: "Number of member deployments of the logical service that are up",
+		},
+		[]string{"namespace", "service"},
+	)
+
+	deploymentLogicalService = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_service",
+			Help: "Always 1; the logical service the deployment is a member of, to join member detail to the service series",
+		},
+		[]string{"namespace", "deployment", "service"},
+	)
+)
+
+// logicalServices aggregates deployments into the logical services named by
+// their deployment-exporter/service annotation or --service-label label.
+type logicalServices struct {
+	label string
+
+	mu       sync.Mutex
+	services map[string]bool   // namespace/service of the last cycle
+	members  map[string]string // namespace/deployment -> service of the last cycle
+}
+
+func newLogicalServices(opts *options) *logicalServices {
+	return &logicalServices{
+		label:    opts.serviceLabel,
+		services: make(map[string]bool),
+		members:  make(map[string]string),
+	}
+}
+
+func validateLogicalService(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+// serviceOf returns the deployment's logical service, "" if it isn't a
+// member of one. The annotation wins over the label.
+func (s *logicalServices) serviceOf(d *appsv1.Deployment) string {
+	if service := strings.TrimSpace(d.Annotations[logicalServiceAnnotation]); service != "" {
+		return service
+	}
+	if s.label != "" {
+		return d.Labels[s.label]
+	}
+	return ""
+}
+
+// collectLogicalServiceMetrics updates the logical service series from the
+// periodic list. Services of namespaces that failed to list keep their last
+// values; those without members anymore are deleted.
+func (t *DeploymentTracker) collectLogicalServiceMetrics(deployments []appsv1.Deployment, failed map[string]error) {
+	s := t.logicalServices
+	if s == nil {
+		return
+	}
+
+	members := make(map[string]string)
+	total := make(map[string]int)
+	up := make(map[string]int)
+	for _, deployment := range deployments {
+		service := s.serviceOf(&deployment)
+		if service == "" || !t.ownsDeployment(deployment.Namespace, deployment.Name) {
+			continue
+		}
+		key := deployment.Namespace + "/" + deployment.Name
+		members[key] = service
+		total[deployment.Namespace+"/"+service]++
+		if _, isDown := t.downtimeStart.get(key); !isDown {
+			up[deployment.Namespace+"/"+service]++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, service := range s.members {
+		ns, name, _ := strings.Cut(key, "/")
+		if _, ok := failed[ns]; ok {
+			members[key] = service
+			continue
+		}
+		if members[key] != service {
+			deploymentLogicalService.DeleteLabelValues(ns, name, service)
+		}
+	}
+	for key, service := range members {
+		ns, name, _ := strings.Cut(key, "/")
+		deploymentLogicalService.WithLabelValues(ns, name, service).Set(1)
+	}
+	s.members = members
+
+	services := make(map[string]bool)
+	for key := range s.services {
+		ns, service, _ := strings.Cut(key, "/")
+		if _, ok := failed[ns]; ok {
+			services[key] = true
+			continue
+		}
+		if total[key] == 0 {
+			logicalServiceUp.DeleteLabelValues(ns, service)
+			logicalServiceMembers.DeleteLabelValues(ns, service)
+			logicalServiceMembersUp.DeleteLabelValues(ns, service)
+		}
+	}
+	for key, count := range total {
+		ns, service, _ := strings.Cut(key, "/")
+		services[key] = true
+		value := float64(0)
+		if up[key] > 0 {
+			value = 1
+		}
+		logicalServiceUp.WithLabelValues(ns, service).Set(value)
+		logicalServiceMembers.WithLabelValues(ns, service).Set(float64(count))
+		logicalServiceMembersUp.WithLabelValues(ns, service).Set(float64(up[key]))
+	}
+	s.services = services
+}
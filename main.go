@@ -3,22 +3,29 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth" // cloud and OIDC auth providers
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
@@ -86,6 +93,15 @@ var (
 		[]string{"namespace", "deployment", "condition", "status"},
 	)
 
+	// When each condition last changed status, as recorded by the controller
+	deploymentConditionLastTransition = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_condition_last_transition_timestamp_seconds",
+			Help: "Unix timestamp when the deployment condition last transitioned from one status to another",
+		},
+		[]string{"namespace", "deployment", "condition"},
+	)
+
 	// Deployment replicas info
 	deploymentReplicasDesired = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -229,64 +245,317 @@ var (
 )
 
 type DeploymentTracker struct {
-	clientset      *kubernetes.Clientset
-	metricsClient  *metricsv.Clientset
-	downtimeStart  map[string]time.Time
-	namespace      string
+	clientset         *kubernetes.Clientset
+	dynamicClient     dynamic.Interface
+	metricsClient     *metricsv.Clientset
+	tenants           tenantClients
+	metricsCircuit    *circuitBreaker
+	caches            map[string]*namespaceCaches // namespace ("" for all) -> informers
+	nodeInformer      cache.SharedIndexInformer
+	nodeOS            bool
+	pvcMetrics        bool
+	watchServices     bool
+	autoscaleReplicas int
+	keda              bool
+	volumeStats       *volumeStatsCache
+	cadvisor          *cadvisorCache
+	shard             int
+	shardByNamespace  bool
+	totalShards       int
+	dryRun            *dryRunReporter
+	downtimeStart     *timeMap
+	correctedStart    *timeMap
+	lastRecovery      *timeMap
+	locks             *deploymentLocks
+	missing           map[string]bool // tracked deployments the last periodic list missed
+	terminating       *terminatingNamespaces
+	preview           *previewMode
+	identities        *identities
+	logicalServices   *logicalServices
+	capacity          *capacityWaits
+	flagger           *flaggerCanaries
+	overrides         *metricOverrides
+	namespaces        []string // "" for all namespaces
+	matchByOwner      bool
+	sidecarContainers map[string]bool
+	staleRolloutAge   time.Duration
+	manifests         *manifestSource
+	schedules         *replicaSchedules
+	meshHealth        bool
+	revisionMetrics   bool
+	incidents         *incidentStore
+	silences          *silenceStore
+	alertmanager      *alertmanagerSilences
+	injector          *injector
+	events            *eventBatcher
+	rollback          *rollbackHook
+	restarts          *restartTracker
+	generations       *generationLag
+	controller        *controllerHealth
+	rates             *rollingRates
+	memory            *memoryHistory
+	predictor         *failurePredictor
+	baselines         *usageBaselines
+	forecast          bool
+	gauges            *gaugeCache
+	conditions        *conditionSeries
+	peers             *peerClusters
+	warmUntil         time.Time
+	blindSpots        *blindSpots
+	refresh           *resourceRefresh
+	usage             *usageTTL
+	ready             atomic.Bool
 }
 
-func init() {
+func registerMetrics(reg prometheus.Registerer) {
 	// Register metrics with Prometheus
-	prometheus.MustRegister(deploymentDowntimeDuration)
-	prometheus.MustRegister(deploymentRestartCount)
-	prometheus.MustRegister(deploymentStatus)
-	prometheus.MustRegister(deploymentHeartbeat)
-	prometheus.MustRegister(deploymentRecoveryTimeMs)
-	prometheus.MustRegister(deploymentDowntimeStart)
-	prometheus.MustRegister(deploymentConditionStatus)
-	prometheus.MustRegister(deploymentReplicasDesired)
-	prometheus.MustRegister(deploymentReplicasReady)
-	prometheus.MustRegister(deploymentReplicasAvailable)
-	prometheus.MustRegister(deploymentReplicasUnavailable)
-	prometheus.MustRegister(deploymentReplicasUpdated)
-	prometheus.MustRegister(deploymentCreationTime)
-	prometheus.MustRegister(deploymentGeneration)
-	prometheus.MustRegister(deploymentObservedGeneration)
-	prometheus.MustRegister(deploymentAvailabilityRatio)
-	prometheus.MustRegister(deploymentCPUUsage)
-	prometheus.MustRegister(deploymentMemoryUsage)
-	prometheus.MustRegister(deploymentCPURequest)
-	prometheus.MustRegister(deploymentMemoryRequest)
-	prometheus.MustRegister(deploymentCPULimit)
-	prometheus.MustRegister(deploymentMemoryLimit)
-	prometheus.MustRegister(deploymentCPUUsagePercent)
-	prometheus.MustRegister(deploymentMemoryUsagePercent)
+	reg.MustRegister(deploymentDowntimeDuration)
+	reg.MustRegister(deploymentRestartCount)
+	reg.MustRegister(deploymentStatus)
+	reg.MustRegister(deploymentHeartbeat)
+	reg.MustRegister(deploymentRecoveryTimeMs)
+	reg.MustRegister(deploymentDowntimeStart)
+	reg.MustRegister(deploymentConditionLastTransition)
+	reg.MustRegister(deploymentReplicasDesired)
+	reg.MustRegister(deploymentReplicasReady)
+	reg.MustRegister(deploymentReplicasAvailable)
+	reg.MustRegister(deploymentReplicasUnavailable)
+	reg.MustRegister(deploymentReplicasUpdated)
+	reg.MustRegister(deploymentCreationTime)
+	reg.MustRegister(deploymentGeneration)
+	reg.MustRegister(deploymentObservedGeneration)
+	reg.MustRegister(deploymentGenerationLag)
+	reg.MustRegister(deploymentGenerationLagSeconds)
+	reg.MustRegister(controllerHealthSuspect)
+	reg.MustRegister(controllerHealthSignal)
+	reg.MustRegister(exporterWatchEventDelay)
+	reg.MustRegister(deploymentAvailabilityRatio)
+	reg.MustRegister(deploymentCPUUsage)
+	reg.MustRegister(deploymentMemoryUsage)
+	reg.MustRegister(deploymentCPURequest)
+	reg.MustRegister(deploymentMemoryRequest)
+	reg.MustRegister(deploymentCPULimit)
+	reg.MustRegister(deploymentMemoryLimit)
+	reg.MustRegister(deploymentCPUUsagePercent)
+	reg.MustRegister(deploymentMemoryUsagePercent)
+	reg.MustRegister(deploymentMemoryOverThreshold)
+	reg.MustRegister(deploymentCPUOverThreshold)
+	reg.MustRegister(metricsAPICircuitOpen)
+	reg.MustRegister(deploymentSelectorUnownedPods)
+	reg.MustRegister(deploymentClassCPUUsage)
+	reg.MustRegister(deploymentClassMemoryUsage)
+	reg.MustRegister(deploymentClassCPURequest)
+	reg.MustRegister(deploymentClassMemoryRequest)
+	reg.MustRegister(deploymentPodStartupSeconds)
+	reg.MustRegister(deploymentPodSchedulingSeconds)
+	reg.MustRegister(deploymentWaitingForNodeCapacity)
+	reg.MustRegister(deploymentNodeScaleUps)
+	reg.MustRegister(deploymentNodeCapacitySeconds)
+	reg.MustRegister(flaggerCanaryReplicas)
+	reg.MustRegister(flaggerCanaryReplicasReady)
+	reg.MustRegister(flaggerCanaryActive)
+	reg.MustRegister(flaggerPrimary)
+	reg.MustRegister(deploymentPodReadinessFlaps)
+	reg.MustRegister(deploymentPodsTerminating)
+	reg.MustRegister(deploymentPodsStuckTerminating)
+	reg.MustRegister(deploymentOldestTerminatingPodAge)
+	reg.MustRegister(deploymentPVCBound)
+	reg.MustRegister(deploymentPVCCapacity)
+	reg.MustRegister(deploymentPVCUsagePercent)
+	reg.MustRegister(exporterShardDeployments)
+	reg.MustRegister(exporterInstanceOverlaps)
+	reg.MustRegister(deploymentLastRolloutTimestamp)
+	reg.MustRegister(deploymentRolloutAge)
+	reg.MustRegister(deploymentRolloutStale)
+	reg.MustRegister(deploymentReplicaSetCount)
+	reg.MustRegister(deploymentRevisionHistoryLimit)
+	reg.MustRegister(deploymentReplicaSetsOverLimit)
+	reg.MustRegister(deploymentSpecDrift)
+	reg.MustRegister(deploymentReceivingTraffic)
+	reg.MustRegister(deploymentMeshSidecarReadyRatio)
+	reg.MustRegister(deploymentMeshSidecarMissing)
+	reg.MustRegister(exporterKubeAPIThrottled)
+	reg.MustRegister(exporterKubeAPIRequestDuration)
+	reg.MustRegister(exporterAPIRequestsRejected)
+	reg.MustRegister(incidentGroupSize)
+	reg.MustRegister(deploymentAutoRollbacks)
+	reg.MustRegister(deploymentAutoRollbackFailures)
+	reg.MustRegister(deploymentRestartStorm)
+	reg.MustRegister(deploymentRecentRestarts)
+	reg.MustRegister(deploymentProcessingDuration)
+	reg.MustRegister(exporterScrapeCycleDuration)
+	reg.MustRegister(exporterScrapeInterval)
+	reg.MustRegister(exporterResourceCollectionsSkipped)
+	reg.MustRegister(deploymentCorrectedDowntimeStart)
+	reg.MustRegister(deploymentCorrectedDowntimeDuration)
+	reg.MustRegister(deploymentPodsByNodeOS)
+	reg.MustRegister(deploymentExtendedResourceRequest)
+	reg.MustRegister(deploymentExtendedResourceLimit)
+	reg.MustRegister(deploymentCPUThrottledRatio)
+	reg.MustRegister(deploymentCPULimitToRequestRatio)
+	reg.MustRegister(deploymentMemoryLimitToRequestRatio)
+	reg.MustRegister(deploymentContainersWithoutRequests)
+	reg.MustRegister(deploymentQoSGuaranteed)
+	reg.MustRegister(deploymentAutoscalerMissing)
+	reg.MustRegister(deploymentKEDAScaler)
+	reg.MustRegister(deploymentKEDAMinReplicas)
+	reg.MustRegister(deploymentKEDAMaxReplicas)
+	reg.MustRegister(deploymentKEDAPaused)
+	reg.MustRegister(deploymentKEDAActive)
+	reg.MustRegister(deploymentKEDATriggerError)
+	reg.MustRegister(deploymentBelowMinAvailable)
+	reg.MustRegister(deploymentScheduledReplicas)
+	reg.MustRegister(deploymentOffSchedule)
+	reg.MustRegister(deploymentSilenced)
+	reg.MustRegister(deploymentSilencedByAlertmanager)
+	reg.MustRegister(deploymentCollectionError)
+	reg.MustRegister(deploymentUsageSampleTimestamp)
+	reg.MustRegister(deploymentUsageStalePods)
+	reg.MustRegister(deploymentWeeklyAvailability)
+	reg.MustRegister(exporterArchiveFailures)
+	reg.MustRegister(exporterArchiveLastSuccess)
+	reg.MustRegister(deploymentVersionSkew)
+	reg.MustRegister(exporterPeerClusterUp)
+	reg.MustRegister(globalServiceDown)
+	reg.MustRegister(globalServiceClustersDown)
+	reg.MustRegister(globalServiceClusters)
+	reg.MustRegister(deploymentUnmonitoredSeconds)
+	reg.MustRegister(exporterLabelValueCollisions)
+	reg.MustRegister(deploymentDowntimeRate)
+	reg.MustRegister(deploymentRestartsRate)
+	reg.MustRegister(deploymentFailureRiskScore)
+	reg.MustRegister(deploymentFailureRiskFactor)
+	reg.MustRegister(deploymentCPUUsageAnomaly)
+	reg.MustRegister(deploymentMemoryUsageAnomaly)
+	reg.MustRegister(deploymentMemoryLimitETA)
+	reg.MustRegister(exporterDowntimeStartEntries)
+	reg.MustRegister(exporterNamespaceWatchUp)
+	reg.MustRegister(exporterNamespaceWatchErrors)
+	reg.MustRegister(exporterNamespaceListErrors)
+	reg.MustRegister(exporterNamespaceCacheSynced)
+	reg.MustRegister(exporterMissingPermission)
+	reg.MustRegister(namespaceTerminating)
+	reg.MustRegister(previewDeployments)
+	reg.MustRegister(previewDeploymentsDown)
+	reg.MustRegister(previewDowntimes)
+	reg.MustRegister(deploymentIdentity)
+	reg.MustRegister(identityRestartCount)
+	reg.MustRegister(identityDowntimeSeconds)
+	reg.MustRegister(logicalServiceUp)
+	reg.MustRegister(logicalServiceMembers)
+	reg.MustRegister(logicalServiceMembersUp)
+	reg.MustRegister(deploymentLogicalService)
+	reg.MustRegister(deploymentRevisionReplicas)
+	reg.MustRegister(deploymentRevisionReplicasReady)
+	reg.MustRegister(deploymentRevisionCPUUsage)
+	reg.MustRegister(deploymentRevisionMemoryUsage)
+	reg.MustRegister(deploymentMinReadySeconds)
+	reg.MustRegister(deploymentRecoveryPodReadySeconds)
+	reg.MustRegister(deploymentRecoveryMinReadyWaitSeconds)
 }
 
 func main() {
-	var (
-		kubeconfig     string
-		namespace      string
-		metricsAddr    string
-		scrapeInterval int
-	)
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-config":
+			os.Exit(runCheckConfig(os.Args[2:]))
+		case "generate":
+			os.Exit(runGenerate(os.Args[2:]))
+		case "estimate":
+			os.Exit(runEstimate(os.Args[2:]))
+		case "demo":
+			os.Exit(runDemo(os.Args[2:]))
+		}
+	}
 
-	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (optional, uses in-cluster config if not set)")
-	flag.StringVar(&namespace, "namespace", "", "Namespace to monitor (empty = all namespaces)")
-	flag.StringVar(&metricsAddr, "metrics-addr", ":9101", "Address to expose metrics on")
-	flag.IntVar(&scrapeInterval, "scrape-interval", 15, "Scrape interval in seconds")
+	opts := &options{}
+	opts.bindFlags(flag.CommandLine)
 	flag.Parse()
 
+	if opts.configFile != "" {
+		if err := loadConfigFile(flag.CommandLine, opts.configFile); err != nil {
+			log.Fatalf("Error loading config file: %v", err)
+		}
+	}
+	if err := opts.validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	setLogLevel(opts.logLevel)
+	go toggleDebugOnSignal()
+
+	shutdownTracing, err := setupTracing(opts)
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+
+	// Register metrics, labelled with the shard when the cluster is split
+	// across several exporter replicas and with the instance id when one
+	// instance runs per namespace
+	constLabels := prometheus.Labels{}
+	if opts.totalShards > 1 {
+		constLabels["shard"] = strconv.Itoa(opts.shard)
+	}
+	if opts.instanceID != "" {
+		constLabels["instance_id"] = opts.instanceID
+	}
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	var dryRunRegistry *prometheus.Registry
+	if opts.dryRun {
+		// Keep metrics in a private registry that is never served
+		dryRunRegistry = prometheus.NewRegistry()
+		registerer = dryRunRegistry
+	}
+	wrapped := vecRecorder{prometheus.WrapRegistererWith(constLabels, registerer)}
+	registerMetrics(wrapped)
+	registerConditionMetric(wrapped, opts.normalizeConditions)
+	sanitizer := newLabelSanitizer(opts)
+	overrides, err := newMetricOverrides(opts.configFile)
+	if err != nil {
+		log.Fatalf("Error loading metric overrides: %v", err)
+	}
+
 	// Create Kubernetes client
-	config, err := getKubeConfig(kubeconfig)
+	config, err := getKubeConfig(opts.kubeconfig, opts.kubeContext)
 	if err != nil {
 		log.Fatalf("Error creating kubernetes config: %v", err)
 	}
+	config, err = applyCredentials(config, opts)
+	if err != nil {
+		log.Fatalf("Error applying credentials: %v", err)
+	}
+	instrumentTransport(config)
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Error creating kubernetes client: %v", err)
 	}
+	tenants, err := newTenantClients(config, opts)
+	if err != nil {
+		log.Fatalf("Error creating namespace clients: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Error creating dynamic kubernetes client: %v", err)
+	}
+
+	// Detect other instances tracking the same namespaces
+	var registration *instanceRegistration
+	if opts.instanceID != "" {
+		registration = newInstanceRegistration(clientset, opts.coordinationNamespace, opts.coordinationConfigMap, opts.instanceID, opts.namespace)
+		if err := registration.register(time.Now()); err != nil {
+			log.Printf("Warning: Could not check for overlapping exporter instances: %v", err)
+		}
+	}
+
+	// Report RBAC gaps instead of letting the affected list calls fail
+	permissions := newPermissionChecker(clientset, tenants, opts)
+	permissions.check(context.Background())
+	if opts.permissionCheckInterval > 0 {
+		go permissions.run(time.Duration(opts.permissionCheckInterval) * time.Second)
+	}
 
 	// Create metrics client
 	metricsClient, err := metricsv.NewForConfig(config)
@@ -295,33 +564,276 @@ func main() {
 	}
 
 	tracker := &DeploymentTracker{
-		clientset:     clientset,
-		metricsClient: metricsClient,
-		downtimeStart: make(map[string]time.Time),
-		namespace:     namespace,
+		clientset:         clientset,
+		dynamicClient:     dynamicClient,
+		metricsClient:     metricsClient,
+		tenants:           tenants,
+		metricsCircuit:    newCircuitBreaker(opts.metricsFailureThreshold, time.Duration(opts.metricsCooldown)*time.Second, metricsAPICircuitOpen),
+		downtimeStart:     newTimeMap(),
+		correctedStart:    newTimeMap(),
+		lastRecovery:      newTimeMap(),
+		locks:             newDeploymentLocks(),
+		namespaces:        opts.trackedNamespaces(),
+		matchByOwner:      opts.matchByOwner,
+		sidecarContainers: parseSidecarContainers(opts.sidecarContainers),
+		shard:             opts.shard,
+		totalShards:       opts.totalShards,
+		shardByNamespace:  opts.shardBy == shardByNamespace,
+		staleRolloutAge:   time.Duration(opts.staleRolloutDays) * 24 * time.Hour,
+		meshHealth:        opts.meshHealth,
+		revisionMetrics:   opts.revisionMetrics,
+		nodeOS:            opts.nodeOS,
+		pvcMetrics:        opts.pvcMetrics,
+		watchServices:     opts.watchServices,
+		autoscaleReplicas: opts.autoscalerMinReplicas,
+		keda:              opts.keda,
+		silences:          newSilenceStore(),
+		incidents:         newIncidentStore(time.Duration(opts.incidentGroupWindow) * time.Second),
+		restarts:          newRestartTracker(time.Duration(opts.restartStormWindow)*time.Second, opts.restartStormThreshold),
+		generations:       newGenerationLag(),
+		controller:        newControllerHealth(time.Duration(opts.controllerLagThreshold)*time.Second, time.Duration(opts.watchDelayThreshold)*time.Second),
+		rates:             newRollingRates(),
+		identities:        newIdentities(),
+		logicalServices:   newLogicalServices(opts),
+		gauges:            newGaugeCache(),
+		overrides:         overrides,
+	}
+	if opts.normalizeConditions {
+		tracker.conditions = newConditionSeries()
+	}
+	if opts.namespaceLifecycle {
+		tracker.terminating = newTerminatingNamespaces()
+	}
+	if opts.clusterAutoscaler {
+		tracker.capacity = newCapacityWaits()
+	}
+	if opts.flagger {
+		tracker.flagger = newFlaggerCanaries()
+	}
+	if opts.previewNamespaceRegex != "" {
+		tracker.preview = newPreviewMode(opts)
+	}
+	if opts.failurePrediction || opts.memoryForecast {
+		tracker.memory = newMemoryHistory(opts.memoryHistoryWindow())
+		tracker.forecast = opts.memoryForecast
+	}
+	if opts.failurePrediction {
+		tracker.predictor = newFailurePredictor(tracker.memory)
+	}
+	if opts.anomalySigmas > 0 {
+		tracker.baselines = newUsageBaselines(opts.anomalySigmas)
+	}
+	if opts.usageTTL > 0 {
+		tracker.usage = newUsageTTL(time.Duration(opts.usageTTL) * time.Second)
+	}
+	if opts.resourceRefresh > 0 {
+		tracker.refresh = newResourceRefresh(time.Duration(opts.resourceRefresh) * time.Second)
+	}
+	if opts.dryRun {
+		tracker.dryRun = newDryRunReporter(sanitizer.wrap(overrides.wrap(consistentGatherer{dryRunRegistry})))
+	}
+
+	// Notifications for downtime and recovery, collapsed during mass outages
+	notifiers := newNotifiers(opts)
+	if opts.dryRun && len(notifiers) > 0 {
+		log.Printf("Dry-run mode: notifications are logged, not sent")
+		notifiers = nil
+	}
+	tracker.events = newEventBatcher(time.Duration(opts.notifyBatchWindow)*time.Second, opts.notifyBatchThreshold, newDispatcher(notifiers))
+
+	if opts.rollbackAfter > 0 {
+		tracker.rollback = newRollbackHook(tracker.clientsetFor, opts)
 	}
 
+	if opts.gitSource != "" {
+		// Manifests without a namespace belong to the only watched one
+		defaultNamespace := ""
+		if len(tracker.namespaces) == 1 {
+			defaultNamespace = tracker.namespaces[0]
+		}
+		tracker.manifests = newManifestSource(opts.gitSource, defaultNamespace)
+		if err := tracker.manifests.reload(); err != nil {
+			log.Printf("Warning: Could not load manifests from git source %s: %v", opts.gitSource, err)
+		}
+	}
+
+	if opts.alertmanagerURL != "" {
+		alertLabels, _ := parseAlertLabels(opts.alertmanagerAlertLabels)
+		tracker.alertmanager = newAlertmanagerSilences(opts.alertmanagerURL, alertLabels)
+		go tracker.alertmanager.run(time.Duration(opts.alertmanagerInterval) * time.Second)
+	}
+
+	location, _ := time.LoadLocation(opts.scheduleTimezone)
+	tracker.schedules = newReplicaSchedules(opts.replicaScheduleFile, location)
+	if err := tracker.schedules.reload(); err != nil {
+		log.Printf("Warning: Could not load replica schedules from %s: %v", opts.replicaScheduleFile, err)
+	}
+
+	if opts.pvcUsage {
+		tracker.volumeStats = newVolumeStatsCache(time.Duration(opts.scrapeInterval) * time.Second)
+	}
+	if opts.cpuThrottling {
+		tracker.cadvisor = newCadvisorCache(time.Duration(opts.scrapeInterval) * time.Second)
+	}
+
+	tracker.injector = newInjector(tracker, opts)
+
+	// Gaps in the exporter's own heartbeat are unmonitored time
+	tracker.blindSpots = newBlindSpots(opts.blindSpotThreshold())
+	tracker.incidents.blindSpots = tracker.blindSpots
+
+	// Continue where a previous instance stopped
+	if opts.importState != "" {
+		if err := tracker.importState(opts.importState); err != nil {
+			log.Fatalf("Error importing state: %v", err)
+		}
+	}
+
+	// Start informers for pods, replicasets and the enabled collectors
+	stopCh := make(chan struct{})
+	tracker.startInformers(stopCh)
+	if registration != nil {
+		go registration.run(stopCh)
+	}
+
+	// Seed the state from a full list before watch events come in
+	if opts.startupGracePeriod > 0 {
+		tracker.warmUntil = time.Now().Add(time.Duration(opts.startupGracePeriod) * time.Second)
+		log.Printf("Startup warm-up: downtimes are recorded from %s on", tracker.warmUntil.Format(time.RFC3339))
+	}
+	tracker.scrapeDeployments()
+
 	// Start watching deployments
-	go tracker.watchDeployments()
+	tracker.watchDeployments()
+
+	// Push to Graphite/StatsD for stacks that don't scrape
+	if (opts.graphiteAddr != "" || opts.statsdAddr != "") && !opts.dryRun {
+		go newEmitter(opts, sanitizer.wrap(overrides.wrap(consistentGatherer{prometheus.DefaultGatherer}))).run(time.Duration(opts.emitInterval) * time.Second)
+	}
+	if opts.zabbixAddr != "" && !opts.dryRun {
+		go newZabbixSender(opts, overrides.wrap(consistentGatherer{prometheus.DefaultGatherer})).run(time.Duration(opts.emitInterval) * time.Second)
+	}
+
+	// Compare deployments with the exporters of other clusters
+	if opts.peerClusters != "" {
+		tracker.peers = newPeerClusters(tracker, opts)
+		go tracker.peers.run(time.Duration(opts.peerSyncInterval) * time.Second)
+	}
+
+	// Keep resolved incidents beyond the in-memory store
+	if opts.archiveBucket != "" && !opts.dryRun {
+		archiver, err := newIncidentArchiver(tracker.incidents, opts)
+		if err != nil {
+			log.Fatalf("Error setting up incident archive: %v", err)
+		}
+		go archiver.run(time.Duration(opts.archiveInterval) * time.Second)
+	}
+
+	// Publish weekly availability for SLA reporting
+	if opts.weeklyAvailabilityWeeks > 0 {
+		go newWeeklyAvailability(tracker, opts.weeklyAvailabilityWeeks).run()
+	}
 
 	// Start periodic scraper for heartbeat
-	go tracker.periodicScrape(time.Duration(scrapeInterval) * time.Second)
+	go tracker.periodicScrape(newScrapeSchedule(
+		time.Duration(opts.scrapeInterval)*time.Second,
+		time.Duration(opts.minScrapeInterval())*time.Second,
+		time.Duration(opts.maxScrapeInterval())*time.Second,
+	))
 
 	// Expose metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
+	if opts.dryRun {
+		log.Printf("Dry-run mode: metrics are logged, not exposed")
+	} else {
+		gatherer := overrides.wrap(consistentGatherer{prometheus.DefaultGatherer})
+		http.Handle("/metrics", metricsHandler(opts, sanitizer.wrap(gatherer), sanitizer.wrapUTF8(gatherer)))
+	}
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
+	// JSON and control API, with optional token auth and rate limits
+	var apiTokens []apiToken
+	if opts.apiTokensFile != "" {
+		if apiTokens, err = loadAPITokens(opts.apiTokensFile); err != nil {
+			log.Fatalf("Error loading API tokens: %v", err)
+		}
+	}
+	api := newAPIGuard(apiTokens, opts.apiRateLimit, opts.apiRateBurst, newCORSPolicy(opts.apiCORSOrigins))
+	// Changing the log level and silences is opt-in like reload and quit,
+	// reading them is not
+	http.HandleFunc("/-/loglevel", api.wrap(guardWrites(handleLogLevel, opts.enableLifecycle, opts.lifecycleToken, "Lifecycle API is not enabled.")))
+	lc := newLifecycle(tracker, flag.CommandLine, os.Args[1:], opts)
+	http.HandleFunc("/-/healthy", lc.handleHealthy)
+	http.HandleFunc("/-/ready", lc.handleReady)
+	http.HandleFunc("/-/reload", api.wrap(lc.handleReload))
+	http.HandleFunc("/-/quit", api.wrap(lc.handleQuit))
+	go lc.reloadOnSignal()
+	go lc.quitOnSignal()
+	http.HandleFunc("/api/openapi.json", api.wrap(handleOpenAPI))
+	http.HandleFunc("/api/v1/incidents", api.wrap(tracker.incidents.handleIncidents))
+	http.HandleFunc("/api/v1/incident-groups", api.wrap(tracker.incidents.handleIncidentGroups))
+	http.HandleFunc("/api/v1/state", api.wrap(tracker.handleState))
+	http.HandleFunc("/api/v1/state/export", api.wrap(tracker.handleStateExport))
+	http.HandleFunc("/api/v1/silences", api.wrap(guardWrites(tracker.silences.handleSilences, opts.enableSilences, opts.lifecycleToken, "Silences API is not enabled.")))
+	http.HandleFunc("/api/v1/silences/", api.wrap(guardWrites(tracker.silences.handleSilence, opts.enableSilences, opts.lifecycleToken, "Silences API is not enabled.")))
+	http.HandleFunc("/api/v1/topology", api.wrap(tracker.handleTopology))
+	http.HandleFunc("/api/v1/backstage", api.wrap(tracker.handleBackstage))
+	http.HandleFunc("/api/v1/debug/inject", api.wrap(tracker.injector.handleInject))
+	http.HandleFunc("/api/v1/deployments", api.wrap(tracker.handleDeployments))
+	http.HandleFunc("/api/v1/version-skew", api.wrap(tracker.peers.handleVersionSkew))
+	http.HandleFunc("/api/v1/forecast", api.wrap(tracker.handleForecast))
+
+	log.Printf("Starting K8s Deployment Exporter on %s", opts.metricsAddr)
+	log.Printf("Monitoring namespaces: %s (empty = all)", opts.namespace)
+	if opts.totalShards > 1 {
+		log.Printf("Tracking shard %d of %d", opts.shard, opts.totalShards)
+		if tracker.shardByNamespace {
+			log.Printf("Namespaces of this shard: %v", tracker.namespaces)
+		}
+	}
 
-	log.Printf("Starting K8s Deployment Exporter on %s", metricsAddr)
-	log.Printf("Monitoring namespace: %s (empty = all)", namespace)
-	log.Fatal(http.ListenAndServe(metricsAddr, nil))
+	listeners, err := listenAll(opts.metricsAddr)
+	if err != nil {
+		log.Fatalf("Error starting metrics server: %v", err)
+	}
+	server := &http.Server{}
+	go func() {
+		<-lc.quit
+		log.Printf("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+	serveErrs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(l net.Listener) {
+			serveErrs <- server.Serve(l)
+		}(listener)
+	}
+	for range listeners {
+		if err := <-serveErrs; err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}
+	close(stopCh)
+
+	if registration != nil {
+		if err := registration.unregister(); err != nil {
+			log.Printf("Warning: Could not remove exporter instance %q from the coordination ConfigMap: %v", opts.instanceID, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
 }
 
-func getKubeConfig(kubeconfig string) (*rest.Config, error) {
+func getKubeConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
 	// Try in-cluster config first
-	if kubeconfig == "" {
+	if kubeconfig == "" && kubeContext == "" {
 		config, err := rest.InClusterConfig()
 		if err == nil {
 			return config, nil
@@ -329,93 +841,190 @@ func getKubeConfig(kubeconfig string) (*rest.Config, error) {
 		log.Printf("In-cluster config failed, trying kubeconfig file")
 	}
 
-	// Fall back to kubeconfig file
-	if kubeconfig == "" {
-		kubeconfig = os.Getenv("KUBECONFIG")
-	}
-	if kubeconfig == "" {
-		homeDir, _ := os.UserHomeDir()
-		kubeconfig = homeDir + "/.kube/config"
+	// Fall back to kubeconfig file: --kubeconfig, then every file in
+	// KUBECONFIG, then ~/.kube/config
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	// Exec plugins (aws-iam-authenticator, gke-gcloud-auth-plugin, kubelogin)
+	// are re-run by client-go whenever their credential expires
+	if config.ExecProvider != nil {
+		log.Printf("Using exec credential plugin %s", config.ExecProvider.Command)
+	} else if config.AuthProvider != nil {
+		log.Printf("Using auth provider %s", config.AuthProvider.Name)
+	}
+	return config, nil
 }
 
-func (t *DeploymentTracker) watchDeployments() {
-	for {
-		watcher, err := t.clientset.AppsV1().Deployments(t.namespace).Watch(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			log.Printf("Error creating watcher: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
+func (t *DeploymentTracker) handleWatchEvent(event watch.Event) {
+	deployment, ok := event.Object.(*appsv1.Deployment)
+	if !ok || !t.ownsDeployment(deployment.Namespace, deployment.Name) {
+		return
+	}
+	debugf("Watch event %s for deployment %s/%s (resourceVersion %s)", event.Type, deployment.Namespace, deployment.Name, deployment.ResourceVersion)
+	if event.Type == watch.Deleted {
+		t.forgetDeployment(deployment.Namespace, deployment.Name, time.Now(), resolutionDeploymentDeleted)
+		return
+	}
+	t.controller.observeEvent(deployment, time.Now())
 
-		log.Println("Started watching deployments...")
+	ctx, span := tracer.Start(context.Background(), "watch event",
+		deploymentAttributes(deployment.Namespace, deployment.Name),
+		trace.WithAttributes(attribute.String("k8s.watch.event_type", string(event.Type))))
+	t.processDeployment(ctx, deployment)
+	span.End()
+}
 
-		for event := range watcher.ResultChan() {
-			if event.Type == watch.Error {
-				log.Printf("Watch error: %v", event.Object)
-				break
-			}
+func (t *DeploymentTracker) periodicScrape(schedule *scrapeSchedule) {
+	// The next cycle is scheduled once the previous one finished, so slow
+	// cycles stretch the interval instead of piling up
+	timer := time.NewTimer(schedule.current)
+	defer timer.Stop()
+
+	for range timer.C {
+		throttled := kubeAPIThrottledCount.Load()
+		start := time.Now()
+		t.scrapeDeployments()
+		timer.Reset(schedule.next(time.Since(start), kubeAPIThrottledCount.Load() != throttled))
+	}
+}
 
-			deployment, ok := event.Object.(*appsv1.Deployment)
-			if !ok {
-				continue
-			}
+// scrapeDeployments runs one periodic scrape over all tracked deployments.
+func (t *DeploymentTracker) scrapeDeployments() {
+	start := time.Now()
+	ctx, span := tracer.Start(context.Background(), "scrape cycle")
+	defer span.End()
 
-			t.processDeployment(deployment)
+	// A namespace that fails to list doesn't hold up the others
+	deployments, failed := listDeployments(ctx, t.clientsetFor, t.namespaces)
+	for namespace := range failed {
+		exporterNamespaceListErrors.WithLabelValues(namespace).Inc()
+	}
+	if err := listError(failed); err != nil {
+		log.Printf("Error listing deployments: %v", err)
+		span.RecordError(err)
+		if len(failed) == len(t.namespaces) {
+			span.SetStatus(codes.Error, "listing deployments failed")
+			return
 		}
+	}
+	t.heartbeat(start, deployments)
 
-		watcher.Stop()
-		log.Println("Watcher stopped, restarting...")
-		time.Sleep(5 * time.Second)
+	// Pick up manifest changes synced into the git source
+	if t.manifests != nil {
+		if err := t.manifests.reload(); err != nil {
+			log.Printf("Error reloading git source %s: %v", t.manifests.dir, err)
+		}
 	}
-}
 
-func (t *DeploymentTracker) periodicScrape(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	t.updateFlaggerCanaries(deployments, failed, start)
 
-	for range ticker.C {
-		deployments, err := t.clientset.AppsV1().Deployments(t.namespace).List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			log.Printf("Error listing deployments: %v", err)
+	owned := 0
+	for _, deployment := range deployments {
+		if !t.ownsDeployment(deployment.Namespace, deployment.Name) {
 			continue
 		}
-
-		for _, deployment := range deployments.Items {
-			t.processDeployment(&deployment)
-		}
+		owned++
+		t.processDeployment(ctx, &deployment)
 	}
+	t.updateControllerHealth(start, deployments)
+	t.sweepDeletedDeployments(deployments, failed, start)
+	t.collectPreviewMetrics(deployments, failed, start)
+	t.expireHandoffs(start)
+	t.collectLogicalServiceMetrics(deployments, failed)
+	span.SetAttributes(attribute.Int("deployments.tracked", owned))
+	exporterShardDeployments.Set(float64(owned))
+	duration := time.Since(start)
+	exporterScrapeCycleDuration.Observe(duration.Seconds())
+	debugf("Periodic scrape processed %d of %d deployments in %s", owned, len(deployments), duration)
+
+	if t.dryRun != nil {
+		t.dryRun.report()
+	}
+	t.ready.Store(true)
 }
 
-func (t *DeploymentTracker) processDeployment(deployment *appsv1.Deployment) {
+func (t *DeploymentTracker) processDeployment(ctx context.Context, deployment *appsv1.Deployment) {
 	ns := deployment.Namespace
 	name := deployment.Name
 	key := ns + "/" + name
 
-	// Update heartbeat
+	// Started before taking the lock, so waiting for a state read shows
+	ctx, span := tracer.Start(ctx, "process deployment", deploymentAttributes(ns, name))
+	defer span.End()
+	defer t.locks.lock(key)()
+
+	// Pods etc. of a namespace whose caches aren't synced yet would look
+	// missing
+	if !t.cacheSynced(ns) {
+		debugf("Caches of namespace %s not synced, skipping deployment %s", ns, name)
+		return
+	}
+	// The series of deployments of a terminating namespace are removed
+	if t.terminating.has(ns) {
+		debugf("Namespace %s is terminating, skipping deployment %s", ns, name)
+		return
+	}
+
+	// The metrics API and kubelet calls of the resource collection are made
+	// before taking scrapeLock, so a slow one holds up neither scrapes nor
+	// the updates of other deployments queued behind a scrape
 	now := time.Now()
-	deploymentHeartbeat.WithLabelValues(ns, name).Set(float64(now.Unix()))
+	var resources *resourceFetch
+	if !t.flagger.isCanary(ns, name) && t.refresh.due(key, now) {
+		_, fetchSpan := tracer.Start(ctx, "fetch resource metrics")
+		resources = t.fetchResources(deployment)
+		fetchSpan.End()
+	}
+
+	// Apply all of the deployment's updates before the next scrape sees them
+	scrapeLock.RLock()
+	defer scrapeLock.RUnlock()
+
+	// Flagger canaries are scaled to zero between analyses, which isn't
+	// downtime; the primary serves the traffic
+	t.flagger.observe(deployment)
+	if t.flagger.isCanary(ns, name) {
+		collectCanaryMetrics(deployment)
+		return
+	}
+
+	// Update heartbeat
+	gauges := t.gauges.get(ns, name)
+	defer func() {
+		gauges.gauge(deploymentProcessingDuration).Set(time.Since(now).Seconds())
+	}()
+	gauges.gauge(deploymentHeartbeat).Set(float64(now.Unix()))
+
+	// Carry the history of a recreated deployment over
+	t.collectIdentityMetrics(deployment, now)
 
 	// Set metadata metrics
-	deploymentCreationTime.WithLabelValues(ns, name).Set(float64(deployment.CreationTimestamp.Unix()))
-	deploymentGeneration.WithLabelValues(ns, name).Set(float64(deployment.Generation))
-	deploymentObservedGeneration.WithLabelValues(ns, name).Set(float64(deployment.Status.ObservedGeneration))
+	gauges.gauge(deploymentCreationTime).Set(float64(deployment.CreationTimestamp.Unix()))
+	gauges.gauge(deploymentGeneration).Set(float64(deployment.Generation))
+	gauges.gauge(deploymentObservedGeneration).Set(float64(deployment.Status.ObservedGeneration))
+	t.collectGenerationLagMetrics(deployment, gauges, now)
 
 	// Set replica metrics
 	if deployment.Spec.Replicas != nil {
-		deploymentReplicasDesired.WithLabelValues(ns, name).Set(float64(*deployment.Spec.Replicas))
+		gauges.gauge(deploymentReplicasDesired).Set(float64(*deployment.Spec.Replicas))
 	}
-	deploymentReplicasReady.WithLabelValues(ns, name).Set(float64(deployment.Status.ReadyReplicas))
-	deploymentReplicasAvailable.WithLabelValues(ns, name).Set(float64(deployment.Status.AvailableReplicas))
-	deploymentReplicasUnavailable.WithLabelValues(ns, name).Set(float64(deployment.Status.UnavailableReplicas))
-	deploymentReplicasUpdated.WithLabelValues(ns, name).Set(float64(deployment.Status.UpdatedReplicas))
+	gauges.gauge(deploymentReplicasReady).Set(float64(deployment.Status.ReadyReplicas))
+	gauges.gauge(deploymentReplicasAvailable).Set(float64(deployment.Status.AvailableReplicas))
+	gauges.gauge(deploymentReplicasUnavailable).Set(float64(deployment.Status.UnavailableReplicas))
+	gauges.gauge(deploymentReplicasUpdated).Set(float64(deployment.Status.UpdatedReplicas))
+	gauges.gauge(deploymentMinReadySeconds).Set(float64(deployment.Spec.MinReadySeconds))
 
 	// Set availability ratio with labels showing "X/Y" format
 	if deployment.Spec.Replicas != nil {
-		available := fmt.Sprintf("%d", deployment.Status.ReadyReplicas)
-		desired := fmt.Sprintf("%d", *deployment.Spec.Replicas)
+		available := strconv.FormatInt(int64(deployment.Status.ReadyReplicas), 10)
+		desired := strconv.FormatInt(int64(*deployment.Spec.Replicas), 10)
 		ratio := float64(0)
 		if *deployment.Spec.Replicas > 0 {
 			ratio = float64(deployment.Status.ReadyReplicas) / float64(*deployment.Spec.Replicas)
@@ -423,26 +1032,58 @@ func (t *DeploymentTracker) processDeployment(deployment *appsv1.Deployment) {
 		deploymentAvailabilityRatio.WithLabelValues(ns, name, available, desired).Set(ratio)
 	}
 
-	// Collect resource usage metrics
-	t.collectResourceMetrics(ns, name, deployment)
+	// Report silences suspending incidents and notifications
+	t.collectSilenceMetrics(ns, name, now)
+	t.collectAlertmanagerSilenceMetrics(ns, name)
 
-	// Process deployment conditions (Available, Progressing, ReplicaFailure)
-	for _, condition := range deployment.Status.Conditions {
-		conditionType := string(condition.Type)
-		conditionStatus := string(condition.Status)
-		
-		var statusValue float64
-		switch conditionStatus {
-		case "True":
-			statusValue = 1
-		case "False":
-			statusValue = 0
-		default: // "Unknown"
-			statusValue = -1
-		}
-		
-		deploymentConditionStatus.WithLabelValues(ns, name, conditionType, conditionStatus).Set(statusValue)
+	// Check the hot-spare requirement and the replica schedule
+	collectMinAvailableMetrics(deployment)
+	scheduledReplicas, scheduled := t.collectScheduleMetrics(deployment, now)
+
+	// Collect rollout and revision history metrics from the deployment's
+	// ReplicaSets
+	_, rolloutSpan := tracer.Start(ctx, "collect rollout metrics")
+	replicaSets, err := t.deploymentReplicaSets(deployment)
+	setCollectionError(ns, name, collectionErrorReplicaSets, err != nil)
+	if err != nil {
+		log.Printf("Error listing replicasets for deployment %s/%s: %v", ns, name, err)
+	} else {
+		t.collectRolloutMetrics(deployment, replicaSets, now)
+		collectReplicaSetMetrics(deployment, replicaSets)
+		if t.revisionMetrics {
+			collectRevisionMetrics(deployment, replicaSets)
+		}
 	}
+	rolloutSpan.End()
+
+	// Detect crash loops hidden by enough ready replicas
+	t.collectRestartStormMetrics(ns, name, now)
+	t.collectRiskMetrics(ns, name, gauges, now)
+	t.collectForecastMetrics(ns, name, now)
+
+	// Compare the pod template with the GitOps source
+	t.collectDriftMetrics(deployment)
+
+	// Check whether the blue/green Service routes to this deployment
+	t.collectTrafficMetrics(deployment)
+
+	// Collect resource usage metrics once the pods changed or the refresh
+	// interval passed
+	if resources != nil {
+		_, resourceSpan := tracer.Start(ctx, "collect resource metrics")
+		t.collectResourceMetrics(ns, name, deployment, resources)
+		resourceSpan.End()
+	}
+	t.expireUsageMetrics(ns, name, now)
+	collectRequestPolicyMetrics(deployment)
+
+	// Flag large deployments without an HPA or ScaledObject
+	t.collectAutoscalerMetrics(deployment)
+	t.collectCapacityMetrics(ns, name, gauges)
+	t.collectKEDAMetrics(deployment)
+
+	// Process deployment conditions (Available, Progressing, ReplicaFailure)
+	t.collectConditionMetrics(deployment, gauges)
 
 	// Check if deployment is ready
 	desiredReplicas := int32(0)
@@ -453,110 +1094,275 @@ func (t *DeploymentTracker) processDeployment(deployment *appsv1.Deployment) {
 		desiredReplicas > 0 &&
 		deployment.Status.UnavailableReplicas == 0
 
+	// KEDA scales idle event-driven deployments to zero on purpose
+	if desiredReplicas == 0 && t.kedaScaledToZero(deployment) {
+		isReady = true
+	}
+	// Scaling to zero as the replica schedule expects isn't downtime either
+	if desiredReplicas == 0 && scheduled && scheduledReplicas == 0 {
+		isReady = true
+	}
+	// State forced through the debug inject API
+	if injected, ok := t.injector.injected(key, now); ok {
+		isReady = injected
+	}
+
+	// Report the rates once the status below is applied
+	defer t.collectRateMetrics(key, gauges, now)
+
 	// Track status
 	if isReady {
-		deploymentStatus.WithLabelValues(ns, name).Set(1)
+		gauges.gauge(deploymentStatus).Set(1)
 
 		// If we have a downtime start time, calculate recovery
-		if startTime, exists := t.downtimeStart[key]; exists {
-			downtime := now.Sub(startTime)
+		if startTime, exists := t.downtimeStart.get(key); exists {
+			// The last pod's Ready transition rather than the scrape tick
+			// that noticed the recovery
+			recoveredAt := t.recoveryTime(deployment, startTime, now)
+			downtime := recoveredAt.Sub(startTime)
 			downtimeSeconds := downtime.Seconds()
 			downtimeMs := float64(downtime.Milliseconds())
+			correctedStart, _ := t.correctedStart.get(key)
+			correctedDowntime := recoveredAt.Sub(correctedStart)
 
-			// Display time in WIB (UTC+7)
-			wibTime := now.UTC().Add(7 * time.Hour).Format("2006/01/02 15:04:05")
-			log.Printf("[%s WIB] Deployment %s/%s recovered after %.2fs (%.0fms)", wibTime, ns, name, downtimeSeconds, downtimeMs)
+			t.recordRecovery(ctx, deployment, recoveredAt, correctedDowntime)
+			t.rates.recovered(key, startTime, recoveredAt)
 
-			deploymentDowntimeDuration.WithLabelValues(ns, name).Set(downtimeSeconds)
-			deploymentRecoveryTimeMs.WithLabelValues(ns, name).Set(downtimeMs)
+			gauges.gauge(deploymentDowntimeDuration).Set(downtimeSeconds)
+			gauges.gauge(deploymentCorrectedDowntimeDuration).Set(correctedDowntime.Seconds())
+			gauges.gauge(deploymentRecoveryTimeMs).Set(downtimeMs)
+			collectRecoveryBreakdown(deployment, gauges, startTime, recoveredAt, now)
 			deploymentRestartCount.WithLabelValues(ns, name).Inc()
+			t.identityRecovered(deployment, downtime)
 
-			delete(t.downtimeStart, key)
+			t.downtimeStart.remove(key)
+			t.correctedStart.remove(key)
+			t.lastRecovery.set(key, recoveredAt)
 		}
 	} else {
-		deploymentStatus.WithLabelValues(ns, name).Set(0)
+		gauges.gauge(deploymentStatus).Set(0)
+
+		// Deployments that aren't ready when the exporter starts are
+		// usually mid-rollout; they only count as down once the warm-up
+		// is over, back-dated by the corrected start if still not ready
+		startTime, exists := t.downtimeStart.get(key)
+		if !exists && now.Before(t.warmUntil) {
+			debugf("Deployment %s/%s not ready during startup warm-up, not recording a downtime yet", ns, name)
+			return
+		}
 
 		// If this is a new downtime, record start time
-		if _, exists := t.downtimeStart[key]; !exists {
-			t.downtimeStart[key] = now
-			deploymentDowntimeStart.WithLabelValues(ns, name).Set(float64(now.Unix()))
-			// Display time in WIB (UTC+7)
-			wibTime := now.UTC().Add(7 * time.Hour).Format("2006/01/02 15:04:05")
-			log.Printf("[%s WIB] Deployment %s/%s went down", wibTime, ns, name)
+		if !exists {
+			startTime = now
+			lastRecovery, _ := t.lastRecovery.get(key)
+			correctedStart := correctedDowntimeStart(deployment, now, lastRecovery)
+			t.downtimeStart.set(key, startTime)
+			t.correctedStart.set(key, correctedStart)
+			gauges.gauge(deploymentDowntimeStart).Set(float64(now.Unix()))
+			gauges.gauge(deploymentCorrectedDowntimeStart).Set(float64(correctedStart.Unix()))
+			t.recordDown(ctx, deployment, correctedStart)
+			if branch, ok := t.preview.branch(ns); ok {
+				previewDowntimes.WithLabelValues(branch).Inc()
+			}
+		}
+
+		// Roll back failed rollouts of opted-in deployments
+		if t.rollback != nil {
+			t.rollback.check(deployment, replicaSets, startTime, now)
 		}
 	}
 }
 
-func (t *DeploymentTracker) collectResourceMetrics(namespace, deploymentName string, deployment *appsv1.Deployment) {
-	// Get pods for this deployment
-	labelSelector := metav1.FormatLabelSelector(deployment.Spec.Selector)
-	pods, err := t.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
-	if err != nil {
-		log.Printf("Error listing pods for deployment %s/%s: %v", namespace, deploymentName, err)
+// resourceFetch is what the resource collection of a deployment reads from
+// the metrics API and the kubelets.
+type resourceFetch struct {
+	pods    []*corev1.Pod
+	podsErr error
+
+	volumeUsage map[string]volumeUsage // claim name -> usage
+	cfs         cfsCounters            // summed over the pods
+
+	// Whether the pod metrics were listed; not without a metrics client or
+	// while the circuit is open
+	podMetricsListed bool
+	podMetrics       *metricsv1beta1.PodMetricsList
+	podMetricsErr    error
+}
+
+// fetchResources makes the API and kubelet calls of the deployment's
+// resource collection. It runs without scrapeLock held.
+func (t *DeploymentTracker) fetchResources(deployment *appsv1.Deployment) *resourceFetch {
+	namespace := deployment.Namespace
+	labelSelector := podSelector(deployment)
+
+	fetched := &resourceFetch{}
+	fetched.pods, fetched.podsErr = t.listDeploymentPods(deployment, labelSelector)
+	if fetched.podsErr != nil {
+		return fetched
+	}
+	fetched.volumeUsage = t.deploymentVolumeUsage(deployment, fetched.pods)
+	if t.cadvisor != nil {
+		fetched.cfs = t.deploymentCFSCounters(fetched.pods)
+	}
+
+	if t.metricsClientFor(namespace) != nil && t.metricsCircuit.Allow() {
+		fetched.podMetricsListed = true
+		fetched.podMetrics, fetched.podMetricsErr = t.listPodMetrics(namespace, labelSelector)
+		if fetched.podMetricsErr != nil {
+			// Metrics server might not be available
+			t.metricsCircuit.Failure(fetched.podMetricsErr)
+		} else {
+			t.metricsCircuit.Success()
+		}
+	}
+	return fetched
+}
+
+func (t *DeploymentTracker) collectResourceMetrics(namespace, deploymentName string, deployment *appsv1.Deployment, fetched *resourceFetch) {
+	pods := fetched.pods
+	setCollectionError(namespace, deploymentName, collectionErrorPods, fetched.podsErr != nil)
+	if fetched.podsErr != nil {
+		log.Printf("Error listing pods for deployment %s/%s: %v", namespace, deploymentName, fetched.podsErr)
 		return
 	}
 
+	collectTerminatingMetrics(namespace, deploymentName, pods)
+	t.collectVolumeMetrics(deployment, fetched.volumeUsage)
+	if t.meshHealth {
+		collectMeshMetrics(deployment, pods)
+	}
+
+	// Memory usage of Windows pods isn't cgroup-accounted like on Linux
+	// and isn't comparable to their requests, so they are left out of the
+	// memory usage percentage
+	windowsPods := t.collectNodeOSMetrics(namespace, deploymentName, pods)
+	collectExtendedResourceMetrics(namespace, deploymentName, pods)
+	if t.cadvisor != nil {
+		t.collectThrottlingMetrics(namespace, deploymentName, fetched.cfs)
+	}
+
 	// Calculate resource requests and limits
+	gauges := t.gauges.get(namespace, deploymentName)
 	var totalCPURequest, totalMemoryRequest resource.Quantity
 	var totalCPULimit, totalMemoryLimit resource.Quantity
+	var percentMemoryRequest int64
+	memoryLimits := make(map[string]int64) // pod/container -> memory limit
+	classCPURequest := make(map[string]int64)
+	classMemoryRequest := make(map[string]int64)
 
-	for _, pod := range pods.Items {
+	for _, pod := range pods {
 		for _, container := range pod.Spec.Containers {
+			class := t.containerClass(container.Name)
 			if cpuReq := container.Resources.Requests[corev1.ResourceCPU]; !cpuReq.IsZero() {
 				totalCPURequest.Add(cpuReq)
+				classCPURequest[class] += cpuReq.MilliValue()
 			}
 			if memReq := container.Resources.Requests[corev1.ResourceMemory]; !memReq.IsZero() {
 				totalMemoryRequest.Add(memReq)
+				classMemoryRequest[class] += memReq.Value()
+				if !windowsPods[pod.Name] {
+					percentMemoryRequest += memReq.Value()
+				}
 			}
 			if cpuLim := container.Resources.Limits[corev1.ResourceCPU]; !cpuLim.IsZero() {
 				totalCPULimit.Add(cpuLim)
 			}
 			if memLim := container.Resources.Limits[corev1.ResourceMemory]; !memLim.IsZero() {
 				totalMemoryLimit.Add(memLim)
+				if !windowsPods[pod.Name] {
+					memoryLimits[pod.Name+"/"+container.Name] = memLim.Value()
+				}
 			}
 		}
 	}
 
 	// Set request and limit metrics (in millicores and MiB)
-	deploymentCPURequest.WithLabelValues(namespace, deploymentName).Set(float64(totalCPURequest.MilliValue()))
-	deploymentMemoryRequest.WithLabelValues(namespace, deploymentName).Set(float64(totalMemoryRequest.Value()) / 1024 / 1024)
-	deploymentCPULimit.WithLabelValues(namespace, deploymentName).Set(float64(totalCPULimit.MilliValue()))
-	deploymentMemoryLimit.WithLabelValues(namespace, deploymentName).Set(float64(totalMemoryLimit.Value()) / 1024 / 1024)
-
-	// Try to get actual usage from metrics server
-	if t.metricsClient != nil {
-		podMetrics, err := t.metricsClient.MetricsV1beta1().PodMetricses(namespace).List(context.Background(), metav1.ListOptions{
-			LabelSelector: labelSelector,
-		})
-		if err != nil {
-			// Metrics server might not be available
+	gauges.gauge(deploymentCPURequest).Set(float64(totalCPURequest.MilliValue()))
+	gauges.gauge(deploymentMemoryRequest).Set(float64(totalMemoryRequest.Value()) / 1024 / 1024)
+	gauges.gauge(deploymentCPULimit).Set(float64(totalCPULimit.MilliValue()))
+	gauges.gauge(deploymentMemoryLimit).Set(float64(totalMemoryLimit.Value()) / 1024 / 1024)
+	for _, class := range containerClasses {
+		gauges.labelled(deploymentClassCPURequest, class).Set(float64(classCPURequest[class]))
+		gauges.labelled(deploymentClassMemoryRequest, class).Set(float64(classMemoryRequest[class]) / 1024 / 1024)
+	}
+
+	// Use the actual usage from metrics server, unless it has been failing
+	// and the circuit is open
+	if fetched.podMetricsListed {
+		podMetrics := fetched.podMetrics
+		setCollectionError(namespace, deploymentName, collectionErrorMetricsAPI, fetched.podMetricsErr != nil)
+		if fetched.podMetricsErr != nil {
+			return
+		}
+
+		// Leave the usage series alone rather than replacing them with
+		// sums over outdated samples
+		fresh, stalePods, newest := freshPodMetrics(podMetrics.Items, pods, time.Now())
+		gauges.gauge(deploymentUsageStalePods).Set(float64(stalePods))
+		if len(fresh) == 0 && stalePods > 0 {
+			debugf("Only stale pod metrics for deployment %s/%s, keeping previous usage", namespace, deploymentName)
 			return
 		}
+		if !newest.IsZero() {
+			gauges.gauge(deploymentUsageSampleTimestamp).Set(float64(newest.Unix()))
+			t.usage.record(namespace+"/"+deploymentName, newest)
+		}
 
-		var totalCPUUsage, totalMemoryUsage int64
-		for _, pm := range podMetrics.Items {
+		var totalCPUUsage, totalMemoryUsage, percentMemoryUsage int64
+		var limitedMemoryUsage, limitedMemoryLimit int64
+		classCPUUsage := make(map[string]int64)
+		classMemoryUsage := make(map[string]int64)
+		for _, pm := range fresh {
 			for _, container := range pm.Containers {
+				class := t.containerClass(container.Name)
 				cpuUsage := container.Usage[corev1.ResourceCPU]
 				memUsage := container.Usage[corev1.ResourceMemory]
 				totalCPUUsage += cpuUsage.MilliValue()
 				totalMemoryUsage += memUsage.Value()
+				classCPUUsage[class] += cpuUsage.MilliValue()
+				classMemoryUsage[class] += memUsage.Value()
+				if !windowsPods[pm.Name] {
+					percentMemoryUsage += memUsage.Value()
+				}
+				if limit, ok := memoryLimits[pm.Name+"/"+container.Name]; ok {
+					limitedMemoryUsage += memUsage.Value()
+					limitedMemoryLimit += limit
+				}
 			}
 		}
+		t.collectAnomalyMetrics(namespace+"/"+deploymentName, gauges, float64(totalCPUUsage), float64(totalMemoryUsage))
+		if t.revisionMetrics {
+			t.collectRevisionUsageMetrics(deployment, pods, fresh)
+		}
+		if limitedMemoryLimit > 0 {
+			t.memory.record(namespace+"/"+deploymentName, time.Now(), float64(limitedMemoryUsage)/float64(limitedMemoryLimit))
+		}
 
 		// Set usage metrics (millicores and MiB)
-		deploymentCPUUsage.WithLabelValues(namespace, deploymentName).Set(float64(totalCPUUsage))
-		deploymentMemoryUsage.WithLabelValues(namespace, deploymentName).Set(float64(totalMemoryUsage) / 1024 / 1024)
+		gauges.gauge(deploymentCPUUsage).Set(float64(totalCPUUsage))
+		gauges.gauge(deploymentMemoryUsage).Set(float64(totalMemoryUsage) / 1024 / 1024)
+		for _, class := range containerClasses {
+			gauges.labelled(deploymentClassCPUUsage, class).Set(float64(classCPUUsage[class]))
+			gauges.labelled(deploymentClassMemoryUsage, class).Set(float64(classMemoryUsage[class]) / 1024 / 1024)
+		}
 
 		// Calculate usage percentages
+		var cpuPercent, memPercent float64
 		if totalCPURequest.MilliValue() > 0 {
-			cpuPercent := (float64(totalCPUUsage) / float64(totalCPURequest.MilliValue())) * 100
-			deploymentCPUUsagePercent.WithLabelValues(namespace, deploymentName).Set(cpuPercent)
+			cpuPercent = (float64(totalCPUUsage) / float64(totalCPURequest.MilliValue())) * 100
+			gauges.gauge(deploymentCPUUsagePercent).Set(cpuPercent)
 		}
-		if totalMemoryRequest.Value() > 0 {
-			memPercent := (float64(totalMemoryUsage) / float64(totalMemoryRequest.Value())) * 100
-			deploymentMemoryUsagePercent.WithLabelValues(namespace, deploymentName).Set(memPercent)
+		if percentMemoryRequest > 0 {
+			memPercent = (float64(percentMemoryUsage) / float64(percentMemoryRequest)) * 100
+			gauges.gauge(deploymentMemoryUsagePercent).Set(memPercent)
 		}
+
+		// Compare them against the deployment's own alert thresholds
+		collectThresholdMetric(deployment, deploymentCPUOverThreshold, cpuAlertThresholdAnnotation, cpuPercent, totalCPURequest.MilliValue() > 0)
+		collectThresholdMetric(deployment, deploymentMemoryOverThreshold, memoryAlertThresholdAnnotation, memPercent, percentMemoryRequest > 0)
+	} else {
+		// Usage can't be measured without a metrics client or while the
+		// circuit is open
+		setCollectionError(namespace, deploymentName, collectionErrorMetricsAPI, true)
 	}
 }
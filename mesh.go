@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Service mesh proxy containers, injected as regular or native (init) sidecars
+var meshProxyContainers = map[string]bool{
+	"istio-proxy":   true,
+	"linkerd-proxy": true,
+}
+
+var (
+	// Mesh proxy readiness: a deployment can be ready while its proxies
+	// still reject traffic
+	deploymentMeshSidecarReadyRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_mesh_sidecar_ready_ratio",
+			Help: "Ratio of the deployment's running pods whose service mesh proxy is ready",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentMeshSidecarMissing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_mesh_sidecar_injection_missing",
+			Help: "Number of running pods of a meshed deployment without a service mesh proxy",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// meshInjectionRequested reports whether the pod template opts into sidecar
+// injection explicitly.
+func meshInjectionRequested(template *corev1.PodTemplateSpec) bool {
+	return template.Labels["sidecar.istio.io/inject"] == "true" ||
+		template.Annotations["sidecar.istio.io/inject"] == "true" ||
+		template.Annotations["linkerd.io/inject"] == "enabled"
+}
+
+// meshProxyStatus returns whether the pod runs a mesh proxy and whether it is
+// ready.
+func meshProxyStatus(pod *corev1.Pod) (present, ready bool) {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses} {
+		for _, status := range statuses {
+			if meshProxyContainers[status.Name] {
+				return true, status.Ready
+			}
+		}
+	}
+	return false, false
+}
+
+// collectMeshMetrics reports mesh proxy readiness and missing injection for
+// deployments that are part of the mesh: the template requests injection or
+// at least one of its pods runs a proxy.
+func collectMeshMetrics(deployment *appsv1.Deployment, pods []*corev1.Pod) {
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	meshed := meshInjectionRequested(&deployment.Spec.Template)
+	running, withProxy, ready := 0, 0, 0
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		running++
+		present, proxyReady := meshProxyStatus(pod)
+		if present {
+			withProxy++
+			meshed = true
+		}
+		if proxyReady {
+			ready++
+		}
+	}
+
+	if !meshed {
+		deploymentMeshSidecarReadyRatio.DeleteLabelValues(ns, name)
+		deploymentMeshSidecarMissing.DeleteLabelValues(ns, name)
+		return
+	}
+
+	ratio := float64(0)
+	if running > 0 {
+		ratio = float64(ready) / float64(running)
+	}
+	deploymentMeshSidecarReadyRatio.WithLabelValues(ns, name).Set(ratio)
+	deploymentMeshSidecarMissing.WithLabelValues(ns, name).Set(float64(running - withProxy))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/yaml"
+)
+
+// Config file section overriding the help text and adding static labels per
+// metric family, e.g. for metrics governance tooling:
+//
+//	metrics:
+//	  k8s_deployment_status:
+//	    help: Whether the deployment is ready (1) or down (0); owned by team-platform
+//	    labels:
+//	      owner: team-platform
+const metricsConfigKey = "metrics"
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// metricOverride is the configuration of one metric family.
+type metricOverride struct {
+	Help   string            `json:"help"`
+	Labels map[string]string `json:"labels"`
+}
+
+// loadMetricOverrides reads the metrics section of a config file.
+func loadMetricOverrides(path string) (map[string]metricOverride, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Metrics map[string]metricOverride `json:"metrics"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var errs []error
+	for name, override := range config.Metrics {
+		if !metricNameRegexp.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s: %s: invalid metric name %q", path, metricsConfigKey, name))
+		}
+		for label := range override.Labels {
+			if !labelNameRegexp.MatchString(label) || strings.HasPrefix(label, "__") {
+				errs = append(errs, fmt.Errorf("%s: %s: %s: invalid label name %q", path, metricsConfigKey, name, label))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return config.Metrics, nil
+}
+
+// metricOverrides applies the metrics section of the config file on the way
+// out of the registry, like the label sanitizer, so every exposition uses
+// the same help texts and labels. It is re-read on /-/reload.
+type metricOverrides struct {
+	path string
+
+	mu        sync.RWMutex
+	overrides map[string]metricOverride
+}
+
+// newMetricOverrides returns nil without a config file.
+func newMetricOverrides(path string) (*metricOverrides, error) {
+	if path == "" {
+		return nil, nil
+	}
+	o := &metricOverrides{path: path}
+	if err := o.reload(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// reload re-reads the config file.
+func (o *metricOverrides) reload() error {
+	if o == nil {
+		return nil
+	}
+	overrides, err := loadMetricOverrides(o.path)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.overrides = overrides
+	return nil
+}
+
+// wrap returns a gatherer exposing g's metrics with the overrides applied,
+// or g itself without a config file.
+func (o *metricOverrides) wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if o == nil {
+		return g
+	}
+	return overridingGatherer{Gatherer: g, overrides: o}
+}
+
+type overridingGatherer struct {
+	prometheus.Gatherer
+	overrides *metricOverrides
+}
+
+func (g overridingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	g.overrides.mu.RLock()
+	defer g.overrides.mu.RUnlock()
+	for _, family := range families {
+		override, ok := g.overrides.overrides[family.GetName()]
+		if !ok {
+			continue
+		}
+		if override.Help != "" {
+			help := override.Help
+			family.Help = &help
+		}
+		if len(override.Labels) == 0 {
+			continue
+		}
+		for _, metric := range family.Metric {
+			// Label pairs are shared with the registry's metrics, so they
+			// are replaced rather than modified. Labels the metric already
+			// has keep their value
+			pairs := append([]*dto.LabelPair(nil), metric.Label...)
+			has := make(map[string]bool, len(pairs))
+			for _, pair := range pairs {
+				has[pair.GetName()] = true
+			}
+			for name, value := range override.Labels {
+				if has[name] {
+					continue
+				}
+				name, value := name, value
+				pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+			}
+			sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+			metric.Label = pairs
+		}
+	}
+	return families, err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsHandler serves /metrics from gatherer, or from utf8Gatherer (if not
+// nil) for scrapers negotiating UTF-8, gzip-compressed for scrapers that
+// accept it, with an optional limit on concurrent scrapes and an optional
+// cache of the encoded payload.
+func metricsHandler(opts *options, gatherer, utf8Gatherer prometheus.Gatherer) http.Handler {
+	handlerOpts := promhttp.HandlerOpts{MaxRequestsInFlight: opts.metricsMaxRequests}
+	handler := promhttp.HandlerFor(gatherer, handlerOpts)
+	if utf8Gatherer != nil {
+		handler = escapingHandler{legacy: handler, utf8: promhttp.HandlerFor(utf8Gatherer, handlerOpts)}
+	}
+	handler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+	if opts.metricsCacheTTL > 0 {
+		handler = newCachingHandler(handler, opts.metricsCacheTTL)
+	}
+	return handler
+}
+
+// escapingHandler picks the response by the escaping scheme of the Accept
+// header: Prometheus 3 asks for escaping=allow-utf-8 when it accepts UTF-8
+// names and values as they are, older scrapers don't.
+type escapingHandler struct {
+	legacy http.Handler
+	utf8   http.Handler
+}
+
+func (h escapingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !acceptsUTF8(r.Header.Get("Accept")) {
+		h.legacy.ServeHTTP(w, r)
+		return
+	}
+	h.utf8.ServeHTTP(&escapingResponseWriter{ResponseWriter: w}, r)
+}
+
+// acceptsUTF8 reports whether any media type of an Accept header has the
+// escaping=allow-utf-8 parameter.
+func acceptsUTF8(accept string) bool {
+	for _, mediaType := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(mediaType)
+		if err == nil && params["escaping"] == "allow-utf-8" {
+			return true
+		}
+	}
+	return false
+}
+
+// escapingResponseWriter confirms the negotiated escaping scheme in the
+// Content-Type of the response.
+type escapingResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *escapingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if contentType := w.Header().Get("Content-Type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType+"; escaping=allow-utf-8")
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *escapingResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// cachedResponse is an encoded /metrics response.
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// cachingHandler replays the last successful response for the same content
+// negotiation for ttl, so the scrapes of an HA Prometheus pair arriving within
+// a second of each other gather and encode the metrics only once.
+type cachingHandler struct {
+	next http.Handler
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry is the cached response of one content negotiation. Its mutex
+// is only held while the response is looked up or refreshed, not while it
+// is written to (possibly slow) clients.
+type cacheEntry struct {
+	mu       sync.Mutex
+	response *cachedResponse
+}
+
+func newCachingHandler(next http.Handler, ttl time.Duration) *cachingHandler {
+	return &cachingHandler{
+		next:    next,
+		ttl:     ttl,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+func (h *cachingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := fmt.Sprintf("%s|%t", r.Header.Get("Accept"), strings.Contains(r.Header.Get("Accept-Encoding"), "gzip"))
+
+	h.mu.Lock()
+	entry, ok := h.entries[key]
+	if !ok {
+		entry = &cacheEntry{}
+		h.entries[key] = entry
+	}
+	h.mu.Unlock()
+
+	// Concurrent scrapes of the same format wait for the one being encoded
+	// instead of each encoding their own copy
+	entry.mu.Lock()
+	cached := entry.response
+	if cached == nil || time.Now().After(cached.expires) {
+		rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		h.next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK {
+			entry.mu.Unlock()
+			rec.replay(w)
+			return
+		}
+		cached = &cachedResponse{header: rec.header, body: rec.body.Bytes(), expires: time.Now().Add(h.ttl)}
+		entry.response = cached
+	}
+	entry.mu.Unlock()
+
+	for name, values := range cached.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(cached.body)
+}
+
+// responseRecorder buffers a response.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header         { return r.header }
+func (r *responseRecorder) WriteHeader(status int)      { r.status = status }
+func (r *responseRecorder) Write(p []byte) (int, error) { return r.body.Write(p) }
+
+func (r *responseRecorder) replay(w http.ResponseWriter) {
+	for name, values := range r.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
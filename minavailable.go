@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Annotation declaring how many replicas must be ready at all times,
+// regardless of spec.replicas
+const minAvailableAnnotation = "deployment-exporter/min-available"
+
+var (
+	// Hot-spare policy violations
+	deploymentBelowMinAvailable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_below_min_available",
+			Help: "Whether the deployment has fewer ready replicas than its deployment-exporter/min-available annotation requires (1 = below)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+func validateMinAvailable(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("must be a non-negative integer, got %q", value)
+	}
+	return nil
+}
+
+// collectMinAvailableMetrics checks ready replicas against the
+// min-available annotation. Scaling spec.replicas below it doesn't lower the
+// requirement.
+func collectMinAvailableMetrics(deployment *appsv1.Deployment) {
+	ns := deployment.Namespace
+	name := deployment.Name
+	value, ok := deployment.Annotations[minAvailableAnnotation]
+	if !ok {
+		deploymentBelowMinAvailable.DeleteLabelValues(ns, name)
+		return
+	}
+	if err := validateMinAvailable(value); err != nil {
+		log.Printf("Invalid %s annotation on deployment %s/%s: %v", minAvailableAnnotation, ns, name, err)
+		deploymentBelowMinAvailable.DeleteLabelValues(ns, name)
+		return
+	}
+	minAvailable, _ := strconv.Atoi(value)
+
+	below := float64(0)
+	if int(deployment.Status.ReadyReplicas) < minAvailable {
+		below = 1
+	}
+	deploymentBelowMinAvailable.WithLabelValues(ns, name).Set(below)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+var (
+	// Pods only count as available minReadySeconds after they became Ready,
+	// which stretches every recovery by a configured delay that looks like
+	// slowness on dashboards
+	deploymentMinReadySeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_min_ready_seconds",
+			Help: "The deployment's spec.minReadySeconds",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentRecoveryPodReadySeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_recovery_pod_ready_seconds",
+			Help: "Seconds of the last recovery until the deployment's last pod became Ready",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentRecoveryMinReadyWaitSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_recovery_min_ready_wait_seconds",
+			Help: "Seconds of the last recovery spent waiting on minReadySeconds after the deployment's last pod became Ready",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// collectRecoveryBreakdown splits a recovery into the time until the last pod
+// became Ready (at podsReady) and the wait on minReadySeconds that followed
+// until the recovery was observed.
+func collectRecoveryBreakdown(deployment *appsv1.Deployment, gauges *deploymentGauges, downSince, podsReady, observed time.Time) {
+	minReady := time.Duration(deployment.Spec.MinReadySeconds) * time.Second
+	wait := min(observed.Sub(podsReady), minReady)
+	if wait < 0 {
+		wait = 0
+	}
+	gauges.gauge(deploymentRecoveryPodReadySeconds).Set(podsReady.Sub(downSince).Seconds())
+	gauges.gauge(deploymentRecoveryMinReadyWaitSeconds).Set(wait.Seconds())
+}
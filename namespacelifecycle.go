@@ -0,0 +1,180 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Resolution of incidents closed because their deployment is gone, or no
+// longer tracked as a deployment of its own
+const (
+	resolutionDeploymentDeleted = "deployment deleted"
+	resolutionNamespaceDeleted  = "namespace deleted"
+	resolutionFlaggerCanary     = "flagger canary"
+)
+
+var (
+	// Context for deployments disappearing with their namespace
+	namespaceTerminating = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_namespace_terminating",
+			Help: "Whether the tracked namespace is being deleted (1); the series of its deployments are removed meanwhile",
+		},
+		[]string{"namespace"},
+	)
+)
+
+// partialDeleter is a metric vector, whose series can be deleted by label.
+type partialDeleter interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// registeredVecs are the metric vectors registered through a vecRecorder.
+var registeredVecs []partialDeleter
+
+// vecRecorder remembers the metric vectors registered through it, so all
+// series of a namespace can be deleted at once.
+type vecRecorder struct {
+	prometheus.Registerer
+}
+
+func (r vecRecorder) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	if vec, ok := c.(partialDeleter); ok {
+		registeredVecs = append(registeredVecs, vec)
+	}
+	return nil
+}
+
+func (r vecRecorder) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// terminatingNamespaces are the tracked namespaces being deleted, whose
+// deployments are no longer processed.
+type terminatingNamespaces struct {
+	mu          sync.Mutex
+	terminating map[string]bool
+}
+
+func newTerminatingNamespaces() *terminatingNamespaces {
+	return &terminatingNamespaces{terminating: make(map[string]bool)}
+}
+
+// has reports whether the namespace is being deleted.
+func (l *terminatingNamespaces) has(namespace string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.terminating[namespace]
+}
+
+// update records whether the namespace is terminating and reports whether
+// it just started to.
+func (l *terminatingNamespaces) update(namespace string, terminating bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	started := terminating && !l.terminating[namespace]
+	if terminating {
+		l.terminating[namespace] = true
+	} else {
+		delete(l.terminating, namespace)
+	}
+	return started
+}
+
+// startNamespaceInformer watches namespaces, which are cluster-scoped, and
+// blocks until the cache is synced.
+func (t *DeploymentTracker) startNamespaceInformer(stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactory(t.clientset, 0)
+	informer := factory.Core().V1().Namespaces().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    t.onNamespace,
+		UpdateFunc: func(_, obj interface{}) { t.onNamespace(obj) },
+		DeleteFunc: t.onNamespaceDelete,
+	}); err != nil {
+		log.Fatalf("Error adding namespace event handler: %v", err)
+	}
+	factory.Start(stopCh)
+	log.Println("Waiting for namespace cache to sync...")
+	factory.WaitForCacheSync(stopCh)
+}
+
+// tracksNamespace reports whether the namespace is one of --namespace.
+func (t *DeploymentTracker) tracksNamespace(namespace string) bool {
+	for _, tracked := range t.namespaces {
+		if tracked == "" || tracked == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *DeploymentTracker) onNamespace(obj interface{}) {
+	namespace, ok := obj.(*corev1.Namespace)
+	if !ok || !t.tracksNamespace(namespace.Name) {
+		return
+	}
+	terminating := namespace.Status.Phase == corev1.NamespaceTerminating || namespace.DeletionTimestamp != nil
+	value := float64(0)
+	if terminating {
+		value = 1
+	}
+	namespaceTerminating.WithLabelValues(namespace.Name).Set(value)
+	if t.terminating.update(namespace.Name, terminating) {
+		log.Printf("Namespace %s is terminating, removing the series of its deployments", namespace.Name)
+		t.forgetNamespace(namespace.Name)
+	}
+}
+
+func (t *DeploymentTracker) onNamespaceDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	namespace, ok := obj.(*corev1.Namespace)
+	if !ok || !t.tracksNamespace(namespace.Name) {
+		return
+	}
+	t.terminating.update(namespace.Name, false)
+	log.Printf("Namespace %s was deleted, removing the series of its deployments", namespace.Name)
+	t.forgetNamespace(namespace.Name)
+	namespaceTerminating.DeleteLabelValues(namespace.Name)
+}
+
+// forgetNamespace forgets the namespace's deployments, closing their open
+// incidents, and deletes all of its series but k8s_namespace_terminating.
+func (t *DeploymentTracker) forgetNamespace(namespace string) {
+	now := time.Now()
+	for key := range t.trackedKeys() {
+		ns, name, _ := strings.Cut(key, "/")
+		if ns == namespace {
+			t.forgetDeployment(ns, name, now, resolutionNamespaceDeleted)
+		}
+	}
+
+	scrapeLock.Lock()
+	defer scrapeLock.Unlock()
+	deleted := 0
+	for _, vec := range registeredVecs {
+		if vec == partialDeleter(namespaceTerminating) {
+			continue
+		}
+		deleted += vec.DeletePartialMatch(prometheus.Labels{"namespace": namespace})
+	}
+	debugf("Deleted %d series of namespace %s", deleted, namespace)
+}
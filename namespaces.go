@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// Backoff between attempts to re-establish a namespace's watch, doubled
+	// on each consecutive failure
+	watchRetryMin = 5 * time.Second
+	watchRetryMax = 5 * time.Minute
+
+	// How long startup waits for the caches of all namespaces in
+	// multi-namespace mode; the rest are tracked once they synced
+	namespaceSyncTimeout = time.Minute
+)
+
+var (
+	// Health of each watched namespace, so one namespace the exporter can't
+	// read (e.g. its RBAC was revoked) is visible without hiding the rest.
+	// The namespace label is empty when watching all namespaces.
+	exporterNamespaceWatchUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_namespace_watch_up",
+			Help: "Whether the deployment watch of the namespace is established (1) or failing (0)",
+		},
+		[]string{"namespace"},
+	)
+
+	exporterNamespaceWatchErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_namespace_watch_errors_total",
+			Help: "Failures to establish or keep the deployment watch of the namespace",
+		},
+		[]string{"namespace"},
+	)
+
+	exporterNamespaceListErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "exporter_namespace_list_errors_total",
+			Help: "Failed periodic deployment lists of the namespace",
+		},
+		[]string{"namespace"},
+	)
+
+	exporterNamespaceCacheSynced = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_namespace_cache_synced",
+			Help: "Whether the pod and ReplicaSet caches of the namespace are synced (1); deployments of unsynced namespaces aren't processed",
+		},
+		[]string{"namespace"},
+	)
+)
+
+// watchedNamespaces parses --namespace: a comma-separated list of
+// namespaces, or "" for all namespaces.
+func watchedNamespaces(list string) []string {
+	namespaces := splitList(list)
+	if len(namespaces) == 0 {
+		return []string{""}
+	}
+	return namespaces
+}
+
+// listDeployments lists the deployments of the namespaces, each with the
+// clientset clientsetFor returns for it. Namespaces that fail to list are
+// left out, with their errors returned by namespace.
+func listDeployments(ctx context.Context, clientsetFor func(namespace string) kubernetes.Interface, namespaces []string) ([]appsv1.Deployment, map[string]error) {
+	var deployments []appsv1.Deployment
+	failed := make(map[string]error)
+	for _, namespace := range namespaces {
+		list, err := clientsetFor(namespace).AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			failed[namespace] = err
+			continue
+		}
+		deployments = append(deployments, list.Items...)
+	}
+	return deployments, failed
+}
+
+// listError combines the errors of listDeployments, nil if there are none.
+func listError(failed map[string]error) error {
+	namespaces := make([]string, 0, len(failed))
+	for namespace := range failed {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	var errs []error
+	for _, namespace := range namespaces {
+		if namespace == "" {
+			errs = append(errs, fmt.Errorf("listing deployments: %w", failed[namespace]))
+		} else {
+			errs = append(errs, fmt.Errorf("listing deployments of namespace %s: %w", namespace, failed[namespace]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// namespaceCaches are the informers of one watched namespace, or of all
+// namespaces. Each namespace syncs on its own, so one the exporter can't
+// read only leaves its own caches empty.
+type namespaceCaches struct {
+	pods          cache.SharedIndexInformer
+	replicaSets   cache.SharedIndexInformer
+	hpas          cache.SharedIndexInformer // nil unless --autoscaler-min-replicas is set
+	scaledObjects cache.SharedIndexInformer // nil unless KEDA ScaledObjects are considered and installed
+	synced        chan struct{}             // closed once all of the above are synced
+
+	// Informers of optional collectors, which don't hold back processing
+	// while they sync
+	pvcs     cache.SharedIndexInformer // nil unless --pvc-metrics is set
+	services cache.SharedIndexInformer // nil unless --watch-services is set
+}
+
+// cachesFor returns the informers holding the namespace's objects.
+func (t *DeploymentTracker) cachesFor(namespace string) *namespaceCaches {
+	if caches, ok := t.caches[namespace]; ok {
+		return caches
+	}
+	return t.caches[""]
+}
+
+// cacheSynced reports whether the namespace's caches are synced, false for
+// namespaces that aren't watched.
+func (t *DeploymentTracker) cacheSynced(namespace string) bool {
+	caches := t.cachesFor(namespace)
+	if caches == nil {
+		return false
+	}
+	select {
+	case <-caches.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+// watchDeployments watches the deployments of each namespace in its own
+// goroutine, so a namespace whose watch keeps failing only backs off itself
+// instead of restarting the watches of all others.
+func (t *DeploymentTracker) watchDeployments() {
+	for _, namespace := range t.namespaces {
+		go t.watchNamespace(namespace)
+	}
+}
+
+func (t *DeploymentTracker) watchNamespace(namespace string) {
+	up := exporterNamespaceWatchUp.WithLabelValues(namespace)
+	retry := watchRetryMin
+	for {
+		watcher, err := t.clientsetFor(namespace).AppsV1().Deployments(namespace).Watch(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			log.Printf("Error creating watcher for %s, retrying in %s: %v", namespaceName(namespace), retry, err)
+			up.Set(0)
+			exporterNamespaceWatchErrors.WithLabelValues(namespace).Inc()
+			time.Sleep(retry)
+			retry = min(2*retry, watchRetryMax)
+			continue
+		}
+		retry = watchRetryMin
+		up.Set(1)
+
+		log.Printf("Started watching deployments of %s...", namespaceName(namespace))
+
+		for event := range watcher.ResultChan() {
+			if event.Type == watch.Error {
+				log.Printf("Watch error for %s: %v", namespaceName(namespace), event.Object)
+				exporterNamespaceWatchErrors.WithLabelValues(namespace).Inc()
+				break
+			}
+			t.handleWatchEvent(event)
+		}
+
+		watcher.Stop()
+		up.Set(0)
+		log.Printf("Watcher of %s stopped, restarting...", namespaceName(namespace))
+		time.Sleep(watchRetryMin)
+	}
+}
+
+// namespaceName names the namespace in log messages.
+func namespaceName(namespace string) string {
+	if namespace == "" {
+		return "all namespaces"
+	}
+	return "namespace " + namespace
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Operating systems reported in the os label; pods without any OS hint are
+// "unknown"
+var nodeOSes = []string{"linux", "windows", "unknown"}
+
+var (
+	// Pods of the deployment by the OS of the node they run on
+	deploymentPodsByNodeOS = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_pods_by_node_os",
+			Help: "Number of pods of the deployment by operating system of the node they run on",
+		},
+		[]string{"namespace", "deployment", "os"},
+	)
+)
+
+// podOS returns the operating system a pod runs on: spec.os, then a
+// kubernetes.io/os node selector and, with --node-os, the label of the node
+// it is scheduled on.
+func (t *DeploymentTracker) podOS(pod *corev1.Pod) string {
+	if pod.Spec.OS != nil && pod.Spec.OS.Name != "" {
+		return string(pod.Spec.OS.Name)
+	}
+	if os := pod.Spec.NodeSelector[corev1.LabelOSStable]; os != "" {
+		return os
+	}
+	if t.nodeInformer != nil && pod.Spec.NodeName != "" {
+		obj, exists, err := t.nodeInformer.GetIndexer().GetByKey(pod.Spec.NodeName)
+		if err == nil && exists {
+			if os := obj.(*corev1.Node).Labels[corev1.LabelOSStable]; os != "" {
+				return os
+			}
+		}
+	}
+	return "unknown"
+}
+
+// collectNodeOSMetrics counts the deployment's pods per node OS and returns
+// the names of those running on Windows.
+func (t *DeploymentTracker) collectNodeOSMetrics(namespace, deploymentName string, pods []*corev1.Pod) map[string]bool {
+	counts := make(map[string]int)
+	windows := make(map[string]bool)
+	for _, pod := range pods {
+		os := t.podOS(pod)
+		counts[os]++
+		if os == string(corev1.Windows) {
+			windows[pod.Name] = true
+		}
+	}
+	for _, os := range nodeOSes {
+		deploymentPodsByNodeOS.WithLabelValues(namespace, deploymentName, os).Set(float64(counts[os]))
+	}
+	return windows
+}
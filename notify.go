@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// Deployment event types
+const (
+	eventDown              = "down"
+	eventRecovered         = "recovered"
+	eventRestartStorm      = "restart_storm"
+	eventRestartStormEnded = "restart_storm_ended"
+)
+
+// Event types ending the condition reported by another event type
+var resolvedEvents = map[string]string{
+	eventRecovered:         eventDown,
+	eventRestartStormEnded: eventRestartStorm,
+}
+
+// eventVerbs describe event types in summaries.
+var eventVerbs = map[string]string{
+	eventDown:              "went down",
+	eventRecovered:         "recovered",
+	eventRestartStorm:      "started a restart storm",
+	eventRestartStormEnded: "ended a restart storm",
+}
+
+// event is a deployment state change sent to notifiers. Summary events
+// stand in for a batch of suppressed events and carry their count instead of
+// a deployment.
+type event struct {
+	Type            string    `json:"type"`
+	Namespace       string    `json:"namespace"`
+	Deployment      string    `json:"deployment,omitempty"`
+	Time            time.Time `json:"time"`
+	DowntimeSeconds float64   `json:"downtimeSeconds,omitempty"`
+	Count           int       `json:"count,omitempty"`
+	Message         string    `json:"message"`
+	TraceID         string    `json:"traceId,omitempty"`
+
+	// Deployment conditions at downtime start or recovery
+	Conditions []incidentCondition `json:"conditions,omitempty"`
+}
+
+// Opsgenie API URL of accounts outside the EU
+const defaultOpsgenieAPIURL = "https://api.opsgenie.com"
+
+// validateNotifierURL checks that a notifier URL is an absolute http(s) URL.
+func validateNotifierURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q must be an http:// or https:// URL", value)
+	}
+	return nil
+}
+
+// validateNotifiers checks the notifier options.
+func validateNotifiers(o *options) []error {
+	var errs []error
+	if o.webhookURL != "" {
+		if err := validateNotifierURL(o.webhookURL); err != nil {
+			errs = append(errs, fmt.Errorf("webhook-url: %w", err))
+		}
+	}
+	if o.opsgenieAPIKey != "" && strings.TrimSpace(o.opsgenieAPIKey) == "" {
+		errs = append(errs, errors.New("opsgenie-api-key must not be blank"))
+	}
+	if o.opsgenieAPIURL != defaultOpsgenieAPIURL && o.opsgenieAPIKey == "" {
+		errs = append(errs, errors.New("opsgenie-api-url requires opsgenie-api-key, Opsgenie is otherwise disabled"))
+	}
+	if err := validateNotifierURL(o.opsgenieAPIURL); err != nil {
+		errs = append(errs, fmt.Errorf("opsgenie-api-url: %w", err))
+	}
+	if o.cloudEventsURL != "" {
+		if err := validateNotifierURL(o.cloudEventsURL); err != nil {
+			errs = append(errs, fmt.Errorf("cloudevents-url: %w", err))
+		}
+		if o.cloudEventsSource == "" {
+			errs = append(errs, errors.New("cloudevents-source must not be empty with cloudevents-url"))
+		}
+	}
+	return errs
+}
+
+// notifier delivers events to an external system.
+type notifier interface {
+	notify(ev event) error
+}
+
+// newNotifiers creates the notifiers configured in opts.
+func newNotifiers(opts *options) []notifier {
+	var notifiers []notifier
+	if opts.webhookURL != "" {
+		notifiers = append(notifiers, newWebhookNotifier(opts.webhookURL))
+	}
+	if opts.opsgenieAPIKey != "" {
+		notifiers = append(notifiers, newOpsgenieNotifier(opts.opsgenieAPIURL, opts.opsgenieAPIKey))
+	}
+	if opts.cloudEventsURL != "" {
+		notifiers = append(notifiers, newCloudEventsNotifier(opts.cloudEventsURL, opts.cloudEventsSource))
+	}
+	return notifiers
+}
+
+// webhookNotifier POSTs every event as JSON.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *webhookNotifier) notify(ev event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// opsgenieNotifier opens an Opsgenie alert when a deployment (or, for
+// summaries, a namespace) goes down and closes it on recovery.
+type opsgenieNotifier struct {
+	apiURL string
+	apiKey string
+	client *http.Client
+}
+
+func newOpsgenieNotifier(apiURL, apiKey string) *opsgenieNotifier {
+	return &opsgenieNotifier{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *opsgenieNotifier) notify(ev event) error {
+	// The alias deduplicates alerts and identifies the alert to close
+	alias := "k8s-deployment-exporter/" + ev.Namespace + "/" + ev.Deployment
+	if ev.Deployment == "" {
+		alias = "k8s-deployment-exporter/" + ev.Namespace + "/summary"
+	}
+	opened, resolves := resolvedEvents[ev.Type]
+	if !resolves {
+		opened = ev.Type
+	}
+	if opened != eventDown {
+		alias += "/" + opened
+	}
+
+	if resolves {
+		return n.post("/v2/alerts/"+url.PathEscape(alias)+"/close?identifierType=alias", map[string]interface{}{
+			"source": "k8s-deployment-exporter",
+			"note":   ev.Message,
+		})
+	}
+	details := map[string]string{
+		"namespace":  ev.Namespace,
+		"deployment": ev.Deployment,
+		"time":       ev.Time.Format(time.RFC3339),
+	}
+	if ev.TraceID != "" {
+		details["trace_id"] = ev.TraceID
+	}
+	if summary := conditionSummary(ev.Conditions); summary != "" {
+		details["conditions"] = summary
+	}
+	return n.post("/v2/alerts", map[string]interface{}{
+		"message": ev.Message,
+		"alias":   alias,
+		"source":  "k8s-deployment-exporter",
+		"tags":    []string{"kubernetes", "namespace:" + ev.Namespace},
+		"details": details,
+	})
+}
+
+func (n *opsgenieNotifier) post(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+n.apiKey)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("opsgenie returned %s", resp.Status)
+	}
+	return nil
+}
+
+// cloudEventsNotifier POSTs every event as a CloudEvents 1.0 structured-mode
+// JSON message, e.g. to Knative Eventing, Argo Events or an auto-rollback
+// trigger.
+type cloudEventsNotifier struct {
+	url    string
+	source string
+	client *http.Client
+}
+
+func newCloudEventsNotifier(url, source string) *cloudEventsNotifier {
+	return &cloudEventsNotifier{url: url, source: source, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *cloudEventsNotifier) notify(ev event) error {
+	subject := ev.Namespace
+	if ev.Deployment != "" {
+		subject += "/" + ev.Deployment
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              string(uuid.NewUUID()),
+		"source":          n.source,
+		"type":            "io.k8s.deployment-exporter.deployment." + ev.Type,
+		"subject":         subject,
+		"time":            ev.Time.Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+		"data":            ev,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/cloudevents+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("cloudevents sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+// dispatcher sends events to the notifiers from a single goroutine, so slow
+// endpoints never hold up deployment processing.
+type dispatcher struct {
+	notifiers []notifier
+	queue     chan event
+}
+
+func newDispatcher(notifiers []notifier) *dispatcher {
+	d := &dispatcher{notifiers: notifiers, queue: make(chan event, 1000)}
+	if len(notifiers) > 0 {
+		go d.run()
+	}
+	return d
+}
+
+func (d *dispatcher) send(ev event) {
+	if len(d.notifiers) == 0 {
+		return
+	}
+	select {
+	case d.queue <- ev:
+	default:
+		log.Printf("Notification queue full, dropping %s event for %s/%s", ev.Type, ev.Namespace, ev.Deployment)
+	}
+}
+
+func (d *dispatcher) run() {
+	for ev := range d.queue {
+		for _, n := range d.notifiers {
+			if err := n.notify(ev); err != nil {
+				log.Printf("Error sending %s notification for %s/%s: %v", ev.Type, ev.Namespace, ev.Deployment, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI description of the /api/v1 JSON API. Keep it in sync when adding
+// or changing API routes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves GET /api/openapi.json.
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
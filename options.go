@@ -0,0 +1,447 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// options holds the exporter settings. They are set from command-line flags
+// and, for flags not given on the command line, from the --config file.
+type options struct {
+	configFile              string
+	kubeconfig              string
+	kubeContext             string
+	namespace               string
+	metricsAddr             string
+	scrapeInterval          int
+	scrapeIntervalMin       int
+	scrapeIntervalMax       int
+	resourceRefresh         int
+	usageTTL                int
+	metricsFailureThreshold int
+	metricsCooldown         int
+	permissionCheckInterval int
+	matchByOwner            bool
+	sidecarContainers       string
+	watchServices           bool
+	pvcMetrics              bool
+	pvcUsage                bool
+	cpuThrottling           bool
+	staleRolloutDays        int
+	gitSource               string
+	replicaScheduleFile     string
+	scheduleTimezone        string
+	meshHealth              bool
+	revisionMetrics         bool
+	clusterAutoscaler       bool
+	flagger                 bool
+	nodeOS                  bool
+	namespaceLifecycle      bool
+	keda                    bool
+	autoscalerMinReplicas   int
+	metricsMaxRequests      int
+	metricsCacheTTL         time.Duration
+	graphiteAddr            string
+	statsdAddr              string
+	emitInterval            int
+	emitMetrics             string
+	emitPrefix              string
+	zabbixAddr              string
+	zabbixHost              string
+	zabbixDeployments       string
+	webhookURL              string
+	notifyBatchWindow       int
+	notifyBatchThreshold    int
+	incidentGroupWindow     int
+	opsgenieAPIKey          string
+	opsgenieAPIURL          string
+	cloudEventsURL          string
+	cloudEventsSource       string
+	alertmanagerURL         string
+	alertmanagerAlertLabels string
+	alertmanagerInterval    int
+	clusterName             string
+	peerClusters            string
+	peerToken               string
+	peerSyncInterval        int
+	startupGracePeriod      int
+	blindSpotSeconds        int
+	labelSanitizeRegex      string
+	previewNamespaceRegex   string
+	previewSeriesTTL        int
+	serviceLabel            string
+	labelValueMaxLength     int
+	utf8LabelValues         bool
+	controllerLagThreshold  int
+	failurePrediction       bool
+	anomalySigmas           float64
+	memoryForecast          bool
+	forecastWindow          int
+	watchDelayThreshold     int
+	rollbackAfter           int
+	weeklyAvailabilityWeeks int
+	archiveBucket           string
+	archiveEndpoint         string
+	archiveRegion           string
+	archiveInterval         int
+	rollbackWindow          int
+	rollbackWebhookURL      string
+	rollbackDryRun          bool
+	restartStormThreshold   int
+	restartStormWindow      int
+	shard                   int
+	totalShards             int
+	shardBy                 string
+	instanceID              string
+	coordinationNamespace   string
+	coordinationConfigMap   string
+	logLevel                string
+	otlpEndpoint            string
+	otlpInsecure            bool
+	traceSampleRatio        float64
+	dryRun                  bool
+	importState             string
+	enableLifecycle         bool
+	lifecycleToken          string
+	enableDebugInject       bool
+	enableSilences          bool
+	normalizeConditions     bool
+	apiTokensFile           string
+	apiRateLimit            float64
+	apiRateBurst            int
+	apiCORSOrigins          string
+	impersonateUser         string
+	impersonateGroups       string
+	namespaceTokenDir       string
+}
+
+func (o *options) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", "", "Path to a YAML config file; keys are flag names, command-line flags take precedence")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to kubeconfig file (optional, uses in-cluster config if not set)")
+	fs.StringVar(&o.kubeContext, "context", "", "Kubeconfig context to use (default current-context)")
+	fs.StringVar(&o.namespace, "namespace", "", "Comma-separated namespaces to monitor, each with its own watch and caches (empty = all namespaces)")
+	fs.StringVar(&o.metricsAddr, "metrics-addr", ":9101", "Comma-separated addresses to expose metrics on: host:port, [ipv6]:port, unix:///path/to/socket or systemd for socket activation")
+	fs.IntVar(&o.metricsMaxRequests, "metrics-max-requests", 0, "Maximum number of concurrent /metrics requests, further requests get 503 (0 = unlimited)")
+	fs.DurationVar(&o.metricsCacheTTL, "metrics-cache-ttl", 0, "Serve the encoded /metrics payload from cache for this long, e.g. 1s for HA Prometheus pairs (0 = disabled)")
+	fs.StringVar(&o.graphiteAddr, "graphite-addr", "", "Graphite host:port to push metrics to in the plaintext protocol")
+	fs.StringVar(&o.statsdAddr, "statsd-addr", "", "StatsD host:port to push metrics to as gauges (UDP)")
+	fs.IntVar(&o.emitInterval, "emit-interval", 60, "Seconds between pushes to Graphite/StatsD/Zabbix")
+	fs.StringVar(&o.emitMetrics, "emit-metrics", "k8s_deployment_*", "Comma-separated metric families pushed to Graphite/StatsD; a trailing * matches a prefix")
+	fs.StringVar(&o.emitPrefix, "emit-prefix", "k8s", "Prefix of the Graphite/StatsD series names")
+	fs.StringVar(&o.zabbixAddr, "zabbix-addr", "", "Zabbix server or proxy host:port to push the health values of --zabbix-deployments to with the sender protocol")
+	fs.StringVar(&o.zabbixHost, "zabbix-host", "k8s-deployment-exporter", "Zabbix host holding the trapper items")
+	fs.StringVar(&o.zabbixDeployments, "zabbix-deployments", "", "Comma-separated namespace/deployment glob patterns of the deployments pushed to Zabbix, e.g. prod/*,shop/checkout")
+	fs.IntVar(&o.scrapeInterval, "scrape-interval", 15, "Scrape interval in seconds")
+	fs.IntVar(&o.scrapeIntervalMin, "scrape-interval-min", 0, "Lower bound in seconds the scrape interval shrinks back to when load drops (0 = scrape-interval)")
+	fs.IntVar(&o.scrapeIntervalMax, "scrape-interval-max", 0, "Upper bound in seconds the scrape interval is stretched to under load or API throttling (0 = 8x scrape-interval)")
+	fs.IntVar(&o.resourceRefresh, "resource-refresh-interval", 60, "Seconds after which resource metrics of a deployment whose pods didn't change are re-collected (0 = every scrape)")
+	fs.IntVar(&o.usageTTL, "usage-ttl", 0, "Seconds after the newest metrics-server sample at which a deployment's usage series are dropped (0 = keep the last value)")
+	fs.BoolVar(&o.normalizeConditions, "normalize-condition-metric", false, "Export k8s_deployment_condition_status as one series per condition without the status label, deleting series of conditions that disappeared")
+	fs.IntVar(&o.startupGracePeriod, "startup-grace-period", 0, "Seconds after startup during which deployments that aren't ready are not yet recorded as down, e.g. 60 (0 = disabled)")
+	fs.IntVar(&o.blindSpotSeconds, "blind-spot-threshold", 0, "Seconds without a successful scrape cycle after which the gap counts as unmonitored (0 = 3x --scrape-interval-max)")
+	fs.IntVar(&o.controllerLagThreshold, "controller-lag-threshold", 300, "Seconds a deployment's generation lag must persist to count towards k8s_controller_health_suspect")
+	fs.IntVar(&o.watchDelayThreshold, "watch-delay-threshold", 30, "Median delay in seconds of deployment watch events above which they count towards k8s_controller_health_suspect")
+	fs.BoolVar(&o.failurePrediction, "failure-prediction", false, "Experimental: expose k8s_deployment_failure_risk_score from memory, restart and readiness flap trends")
+	fs.BoolVar(&o.memoryForecast, "memory-forecast", false, "Forecast when deployments' memory usage reaches their limits (k8s_deployment_memory_limit_eta_seconds, /api/v1/forecast)")
+	fs.IntVar(&o.forecastWindow, "forecast-window", 21600, "Seconds of memory usage history the forecasts are fitted to")
+	fs.Float64Var(&o.anomalySigmas, "anomaly-sigmas", 0, "Standard deviations from its rolling baseline at which a deployment's CPU or memory usage is flagged as an anomaly (0 = disabled)")
+	fs.StringVar(&o.previewNamespaceRegex, "preview-namespace-regex", "", "Regular expression of ephemeral preview namespaces, e.g. ^preview-(?P<branch>.+)$; their deployments are also aggregated by the branch group (or first group, or namespace) and their series expire once deleted (empty = disabled)")
+	fs.IntVar(&o.previewSeriesTTL, "preview-series-ttl", 300, "Seconds the series of deleted preview deployments, and of branches without deployments, are kept")
+	fs.StringVar(&o.serviceLabel, "service-label", "", "Deployment label naming the logical service the deployment is a member of, e.g. service; members are aggregated into k8s_service_up (the deployment-exporter/service annotation wins; empty = annotation only)")
+	fs.StringVar(&o.labelSanitizeRegex, "label-sanitize-regex", "", "Regular expression of label value runes replaced with _ in all exposed metrics, e.g. [^a-zA-Z0-9_-] (empty = none)")
+	fs.IntVar(&o.labelValueMaxLength, "label-value-max-length", 0, "Maximum length in runes of exposed label values; longer ones are truncated with a hash suffix (0 = unlimited)")
+	fs.BoolVar(&o.utf8LabelValues, "utf8-label-values", false, "Keep non-ASCII runes in label values matching --label-sanitize-regex for scrapers negotiating escaping=allow-utf-8 (Prometheus 3)")
+	fs.IntVar(&o.permissionCheckInterval, "permission-check-interval", 600, "Seconds between re-checks of the RBAC permissions the enabled collectors need, which are always checked at startup (0 = startup only)")
+	fs.IntVar(&o.metricsFailureThreshold, "metrics-api-failure-threshold", 3, "Consecutive metrics-server failures before usage collection is skipped")
+	fs.IntVar(&o.metricsCooldown, "metrics-api-cooldown", 60, "Seconds to skip usage collection after the metrics-server circuit opens")
+	fs.BoolVar(&o.matchByOwner, "match-pods-by-owner", true, "Only attribute pods owned by the deployment's ReplicaSets (avoids over-counting with shared selectors)")
+	fs.StringVar(&o.sidecarContainers, "sidecar-containers", defaultSidecarContainers, "Comma-separated container names counted as sidecars in container_class resource metrics")
+	fs.BoolVar(&o.watchServices, "watch-services", false, "Watch Services for the blue/green traffic of deployments annotated with deployment-exporter/blue-green-service and the Services and Ingresses in /api/v1/topology (requires list/watch on services)")
+	fs.BoolVar(&o.pvcMetrics, "pvc-metrics", false, "Export binding state and capacity of the PersistentVolumeClaims mounted by deployments (requires list/watch on persistentvolumeclaims)")
+	fs.BoolVar(&o.pvcUsage, "pvc-usage", false, "Collect PersistentVolumeClaim usage from kubelet volume stats (requires pvc-metrics and nodes/proxy access)")
+	fs.BoolVar(&o.cpuThrottling, "cpu-throttling", false, "Collect CPU throttling from kubelet cAdvisor metrics (requires nodes/proxy access)")
+	fs.IntVar(&o.staleRolloutDays, "stale-rollout-days", 180, "Days without a rollout after which k8s_deployment_rollout_stale is set (0 = disabled)")
+	fs.StringVar(&o.gitSource, "git-source", "", "Directory of rendered deployment manifests (e.g. a git-sync checkout) to detect spec drift against")
+	fs.StringVar(&o.replicaScheduleFile, "replica-schedule-file", "", "YAML file mapping namespace/deployment to replica schedules like \"Mon-Fri 08:00-20:00=6; *=1\"")
+	fs.StringVar(&o.scheduleTimezone, "schedule-timezone", "UTC", "Time zone replica schedules are evaluated in, e.g. Asia/Jakarta")
+	fs.BoolVar(&o.meshHealth, "mesh-health", false, "Export Istio/Linkerd sidecar proxy readiness and missing injection for meshed deployments")
+	fs.IntVar(&o.autoscalerMinReplicas, "autoscaler-missing-replicas", 0, "Flag deployments with at least this many replicas and no HPA or KEDA ScaledObject in k8s_deployment_autoscaler_missing (0 = disabled)")
+	fs.BoolVar(&o.keda, "keda", false, "Watch KEDA ScaledObjects, export their scaler configuration and state and treat KEDA scale-to-zero as intentional")
+	fs.BoolVar(&o.namespaceLifecycle, "namespace-lifecycle", false, "Watch the tracked namespaces: remove the series and close the incidents of deployments of terminating or deleted namespaces, and export k8s_namespace_terminating (requires list/watch on namespaces)")
+	fs.BoolVar(&o.revisionMetrics, "revision-metrics", false, "Export replicas and usage of each deployment split into its new and old pod templates (revision=\"new\"|\"old\"), to follow rollouts crossing over")
+	fs.BoolVar(&o.clusterAutoscaler, "cluster-autoscaler", false, "Track pending pods waiting for cluster-autoscaler scale-ups, from its TriggeredScaleUp events (requires list/watch on events)")
+	fs.BoolVar(&o.flagger, "flagger", false, "Treat the target deployments of Flagger canaries (those with a Flagger-owned <name>-primary) as canaries with their own k8s_flagger_canary_* metrics instead of tracking their availability")
+	fs.BoolVar(&o.nodeOS, "node-os", false, "Look up the OS of each pod's node when the pod spec doesn't tell (requires list/watch on nodes)")
+	fs.StringVar(&o.webhookURL, "webhook-url", "", "URL to POST deployment down/recovered events to as JSON")
+	fs.StringVar(&o.opsgenieAPIKey, "opsgenie-api-key", "", "Opsgenie API integration key; opens an alert per down deployment and closes it on recovery")
+	fs.StringVar(&o.opsgenieAPIURL, "opsgenie-api-url", defaultOpsgenieAPIURL, "Opsgenie API URL (https://api.eu.opsgenie.com for EU accounts)")
+	fs.StringVar(&o.cloudEventsURL, "cloudevents-url", "", "URL to POST deployment events to as CloudEvents (structured JSON)")
+	fs.StringVar(&o.cloudEventsSource, "cloudevents-source", "k8s-deployment-exporter", "CloudEvents source attribute of the events")
+	fs.StringVar(&o.alertmanagerURL, "alertmanager-url", "", "Alertmanager URL whose active silences also suspend incidents and notifications")
+	fs.StringVar(&o.alertmanagerAlertLabels, "alertmanager-alert-labels", "", "Comma-separated name=value labels, besides namespace and deployment, that Alertmanager silences are matched against, e.g. alertname=DeploymentDown")
+	fs.IntVar(&o.alertmanagerInterval, "alertmanager-sync-interval", 60, "Seconds between syncs of Alertmanager silences")
+	fs.StringVar(&o.clusterName, "cluster-name", "", "Name of this cluster in multi-cluster mode")
+	fs.StringVar(&o.peerClusters, "peer-clusters", "", "Comma-separated name=URL pairs of the exporters of other clusters to compare deployments with (empty = single-cluster)")
+	fs.StringVar(&o.peerToken, "peer-token", "", "Bearer token sent to the --peer-clusters exporters' API")
+	fs.IntVar(&o.peerSyncInterval, "peer-sync-interval", 60, "Seconds between syncs with the --peer-clusters exporters")
+	fs.IntVar(&o.notifyBatchWindow, "notify-batch-window", 30, "Window in seconds in which mass down/recovery events of a namespace are collapsed")
+	fs.IntVar(&o.notifyBatchThreshold, "notify-batch-threshold", 10, "Events per namespace and window logged/notified individually before the rest is summarized (0 = never summarize)")
+	fs.IntVar(&o.weeklyAvailabilityWeeks, "weekly-availability-weeks", 0, "Number of closed ISO weeks to expose k8s_deployment_weekly_availability for (0 = disabled)")
+	fs.StringVar(&o.archiveBucket, "archive-bucket", "", "s3://bucket/prefix or gs://bucket/prefix to upload resolved incidents to as JSON Lines (empty = disabled)")
+	fs.StringVar(&o.archiveEndpoint, "archive-endpoint", "", "S3-compatible endpoint URL for --archive-bucket, e.g. for MinIO (default: AWS S3 or Cloud Storage)")
+	fs.StringVar(&o.archiveRegion, "archive-region", "us-east-1", "Region of the --archive-bucket S3 bucket")
+	fs.IntVar(&o.archiveInterval, "archive-interval", 3600, "Seconds between uploads of newly resolved incidents to --archive-bucket")
+	fs.IntVar(&o.incidentGroupWindow, "incident-group-window", 60, "Seconds within which incidents sharing a probable cause (node, ConfigMap/Secret, namespace) are grouped (0 = disabled)")
+	fs.IntVar(&o.restartStormThreshold, "restart-storm-threshold", 5, "Container restarts of a deployment within --restart-storm-window above which it is in a restart storm")
+	fs.IntVar(&o.restartStormWindow, "restart-storm-window", 600, "Window in seconds for counting container restarts towards a restart storm")
+	fs.IntVar(&o.rollbackAfter, "rollback-after", 0, "Roll back deployments annotated deployment-exporter/auto-rollback=true that are down for this many seconds after a rollout (0 = disabled)")
+	fs.IntVar(&o.rollbackWindow, "rollback-window", 600, "Only roll back if the downtime started within this many seconds of the rollout")
+	fs.StringVar(&o.rollbackWebhookURL, "rollback-webhook-url", "", "Call this URL to roll back instead of patching the deployment")
+	fs.BoolVar(&o.rollbackDryRun, "rollback-dry-run", false, "Only log the rollbacks that would be triggered")
+	fs.IntVar(&o.shard, "shard", 0, "Shard index of this exporter replica (0-based)")
+	fs.IntVar(&o.totalShards, "total-shards", 1, "Total number of exporter replicas sharing the cluster's deployments")
+	fs.StringVar(&o.shardBy, "shard-by", shardByDeployment, "How deployments are spread over the shards: deployment (by namespace/name hash; every replica still lists and watches all watched namespaces) or namespace (whole namespaces of --namespace; each replica only lists and watches its own)")
+	fs.StringVar(&o.instanceID, "instance-id", "", "Identifier of this exporter instance, added as instance_id label (for one instance per namespace); requires get, create and update on the coordination ConfigMap")
+	fs.StringVar(&o.coordinationNamespace, "coordination-namespace", "monitoring", "Namespace of the ConfigMap used to detect overlapping instances")
+	fs.StringVar(&o.coordinationConfigMap, "coordination-configmap", "k8s-deployment-exporter-instances", "ConfigMap used to detect instances tracking overlapping namespaces")
+	fs.StringVar(&o.otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector host:port to export traces of collection cycles to (empty = tracing disabled)")
+	fs.BoolVar(&o.otlpInsecure, "otlp-insecure", false, "Export traces over plain HTTP instead of HTTPS")
+	fs.Float64Var(&o.traceSampleRatio, "trace-sample-ratio", 1, "Fraction of scrape cycles and watch events traced")
+	fs.StringVar(&o.logLevel, "log-level", logLevelInfo, "Log level (info or debug); can be changed at runtime via PUT /-/loglevel or SIGUSR1")
+	fs.StringVar(&o.importState, "import-state", "", "Restore open downtimes, incidents, silences and counters from a /api/v1/state/export snapshot on startup")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Watch and process deployments but only log the metrics that would be set instead of exposing them")
+	fs.BoolVar(&o.enableLifecycle, "enable-lifecycle", false, "Enable the /-/reload and /-/quit endpoints and log level changes through PUT /-/loglevel")
+	fs.StringVar(&o.lifecycleToken, "lifecycle-token", "", "Bearer token required by /-/reload, /-/quit, PUT /-/loglevel, creating and deleting silences and /api/v1/debug/inject")
+	fs.StringVar(&o.apiTokensFile, "api-tokens-file", "", "YAML file of bearer tokens with read or write scope required by the JSON and control API (empty = no auth)")
+	fs.Float64Var(&o.apiRateLimit, "api-rate-limit", 0, "Requests per second each API client (token or address) may make (0 = unlimited)")
+	fs.IntVar(&o.apiRateBurst, "api-rate-burst", 20, "Requests an API client may make in a burst above --api-rate-limit")
+	fs.StringVar(&o.apiCORSOrigins, "api-cors-origins", "", "Comma-separated origins (e.g. https://dashboards.example.com, or *) allowed to call the JSON API from browsers")
+	fs.BoolVar(&o.enableDebugInject, "enable-debug-inject", false, "Enable /api/v1/debug/inject to force a deployment's state for alert pipeline tests")
+	fs.BoolVar(&o.enableSilences, "enable-silences", false, "Enable creating and deleting silences through /api/v1/silences (listing them is always allowed)")
+	fs.StringVar(&o.impersonateUser, "as", "", "User to impersonate for Kubernetes API requests")
+	fs.StringVar(&o.impersonateGroups, "as-group", "", "Comma-separated groups to impersonate for Kubernetes API requests")
+	fs.StringVar(&o.namespaceTokenDir, "namespace-token-dir", "", "Directory of per-namespace service-account token files (named after the namespace) used instead of the exporter's own credentials for the requests in each watched namespace")
+}
+
+// minScrapeInterval returns the lower bound of the adaptive scrape interval.
+func (o *options) minScrapeInterval() int {
+	if o.scrapeIntervalMin == 0 {
+		return o.scrapeInterval
+	}
+	return o.scrapeIntervalMin
+}
+
+// maxScrapeInterval returns the upper bound of the adaptive scrape interval.
+func (o *options) maxScrapeInterval() int {
+	if o.scrapeIntervalMax == 0 {
+		return o.scrapeInterval * 8
+	}
+	return o.scrapeIntervalMax
+}
+
+// blindSpotThreshold returns the heartbeat gap that counts as unmonitored.
+func (o *options) blindSpotThreshold() time.Duration {
+	if o.blindSpotSeconds == 0 {
+		return time.Duration(o.maxScrapeInterval()*3) * time.Second
+	}
+	return time.Duration(o.blindSpotSeconds) * time.Second
+}
+
+// memoryHistoryWindow returns how long memory usage samples are kept: long
+// enough for the forecasts if enabled, else for failure prediction.
+func (o *options) memoryHistoryWindow() time.Duration {
+	if o.memoryForecast && time.Duration(o.forecastWindow)*time.Second > memoryTrendWindow {
+		return time.Duration(o.forecastWindow) * time.Second
+	}
+	return memoryTrendWindow
+}
+
+// validate checks settings that parse fine but make no sense together.
+func (o *options) validate() error {
+	var errs []error
+	if len(splitList(o.metricsAddr)) == 0 {
+		errs = append(errs, errors.New("metrics-addr must not be empty"))
+	}
+	for _, addr := range splitList(o.metricsAddr) {
+		if err := validateListenAddr(addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.metricsMaxRequests < 0 {
+		errs = append(errs, fmt.Errorf("metrics-max-requests must not be negative, got %d", o.metricsMaxRequests))
+	}
+	if o.metricsCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("metrics-cache-ttl must not be negative, got %s", o.metricsCacheTTL))
+	}
+	if o.emitInterval < 1 {
+		errs = append(errs, fmt.Errorf("emit-interval must be at least 1 second, got %d", o.emitInterval))
+	}
+	if o.pvcUsage && !o.pvcMetrics {
+		errs = append(errs, errors.New("pvc-usage requires pvc-metrics"))
+	}
+	if o.zabbixAddr != "" && o.zabbixDeployments == "" {
+		errs = append(errs, fmt.Errorf("zabbix-addr requires zabbix-deployments"))
+	}
+	if err := validateZabbixDeployments(o.zabbixDeployments); err != nil {
+		errs = append(errs, fmt.Errorf("zabbix-deployments: %w", err))
+	}
+	if o.scrapeInterval < 1 {
+		errs = append(errs, fmt.Errorf("scrape-interval must be at least 1 second, got %d", o.scrapeInterval))
+	}
+	if o.scrapeIntervalMin < 0 || o.scrapeIntervalMax < 0 {
+		errs = append(errs, fmt.Errorf("scrape-interval-min and scrape-interval-max must not be negative"))
+	} else if o.scrapeInterval >= 1 && (o.minScrapeInterval() > o.scrapeInterval || o.scrapeInterval > o.maxScrapeInterval()) {
+		errs = append(errs, fmt.Errorf("scrape-interval=%d must be within [scrape-interval-min=%d, scrape-interval-max=%d]",
+			o.scrapeInterval, o.minScrapeInterval(), o.maxScrapeInterval()))
+	}
+	if o.resourceRefresh < 0 {
+		errs = append(errs, fmt.Errorf("resource-refresh-interval must not be negative, got %d", o.resourceRefresh))
+	}
+	if o.usageTTL < 0 {
+		errs = append(errs, fmt.Errorf("usage-ttl must not be negative, got %d", o.usageTTL))
+	}
+	if o.apiRateLimit < 0 {
+		errs = append(errs, fmt.Errorf("api-rate-limit must not be negative, got %g", o.apiRateLimit))
+	}
+	if o.apiRateBurst < 1 {
+		errs = append(errs, fmt.Errorf("api-rate-burst must be at least 1, got %d", o.apiRateBurst))
+	}
+	if o.traceSampleRatio < 0 || o.traceSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("trace-sample-ratio must be within [0, 1], got %g", o.traceSampleRatio))
+	}
+	if o.controllerLagThreshold < 1 {
+		errs = append(errs, fmt.Errorf("controller-lag-threshold must be at least 1 second, got %d", o.controllerLagThreshold))
+	}
+	if o.watchDelayThreshold < 1 {
+		errs = append(errs, fmt.Errorf("watch-delay-threshold must be at least 1 second, got %d", o.watchDelayThreshold))
+	}
+	if o.forecastWindow < 60 {
+		errs = append(errs, fmt.Errorf("forecast-window must be at least 60 seconds, got %d", o.forecastWindow))
+	}
+	if o.anomalySigmas < 0 {
+		errs = append(errs, fmt.Errorf("anomaly-sigmas must not be negative, got %g", o.anomalySigmas))
+	}
+	if o.previewNamespaceRegex != "" {
+		if _, err := regexp.Compile(o.previewNamespaceRegex); err != nil {
+			errs = append(errs, fmt.Errorf("invalid preview-namespace-regex: %w", err))
+		}
+	}
+	if o.previewSeriesTTL < 0 {
+		errs = append(errs, fmt.Errorf("preview-series-ttl must not be negative, got %d", o.previewSeriesTTL))
+	}
+	if o.labelSanitizeRegex != "" {
+		if _, err := regexp.Compile(o.labelSanitizeRegex); err != nil {
+			errs = append(errs, fmt.Errorf("label-sanitize-regex: %w", err))
+		}
+	}
+	if o.labelValueMaxLength != 0 && o.labelValueMaxLength < 2*labelHashSuffixLength {
+		errs = append(errs, fmt.Errorf("label-value-max-length must be 0 or at least %d, got %d", 2*labelHashSuffixLength, o.labelValueMaxLength))
+	}
+	if o.utf8LabelValues && o.labelSanitizeRegex == "" {
+		errs = append(errs, fmt.Errorf("utf8-label-values requires label-sanitize-regex, label values are otherwise passed through as they are"))
+	}
+	if o.blindSpotSeconds < 0 {
+		errs = append(errs, fmt.Errorf("blind-spot-threshold must not be negative, got %d", o.blindSpotSeconds))
+	}
+	if o.startupGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("startup-grace-period must not be negative, got %d", o.startupGracePeriod))
+	}
+	if o.metricsFailureThreshold < 1 {
+		errs = append(errs, fmt.Errorf("metrics-api-failure-threshold must be at least 1, got %d", o.metricsFailureThreshold))
+	}
+	if o.metricsCooldown < 0 {
+		errs = append(errs, fmt.Errorf("metrics-api-cooldown must not be negative, got %d", o.metricsCooldown))
+	}
+	if o.notifyBatchWindow < 1 {
+		errs = append(errs, fmt.Errorf("notify-batch-window must be at least 1 second, got %d", o.notifyBatchWindow))
+	}
+	if o.notifyBatchThreshold < 0 {
+		errs = append(errs, fmt.Errorf("notify-batch-threshold must not be negative, got %d", o.notifyBatchThreshold))
+	}
+	if o.incidentGroupWindow < 0 {
+		errs = append(errs, fmt.Errorf("incident-group-window must not be negative, got %d", o.incidentGroupWindow))
+	}
+	if o.restartStormThreshold < 1 {
+		errs = append(errs, fmt.Errorf("restart-storm-threshold must be at least 1, got %d", o.restartStormThreshold))
+	}
+	if o.restartStormWindow < 1 {
+		errs = append(errs, fmt.Errorf("restart-storm-window must be at least 1 second, got %d", o.restartStormWindow))
+	}
+	if _, err := time.LoadLocation(o.scheduleTimezone); err != nil {
+		errs = append(errs, fmt.Errorf("schedule-timezone: %w", err))
+	}
+	errs = append(errs, validateNotifiers(o)...)
+	if _, err := parseAlertLabels(o.alertmanagerAlertLabels); err != nil {
+		errs = append(errs, fmt.Errorf("alertmanager-alert-labels: %w", err))
+	}
+	if o.peerClusters != "" {
+		if _, err := parsePeerClusters(o.peerClusters); err != nil {
+			errs = append(errs, fmt.Errorf("peer-clusters: %w", err))
+		}
+		if o.clusterName == "" {
+			errs = append(errs, errors.New("cluster-name is required with peer-clusters"))
+		}
+		if o.peerSyncInterval < 1 {
+			errs = append(errs, fmt.Errorf("peer-sync-interval must be at least 1 second, got %d", o.peerSyncInterval))
+		}
+	}
+	if o.alertmanagerInterval < 1 {
+		errs = append(errs, fmt.Errorf("alertmanager-sync-interval must be at least 1 second, got %d", o.alertmanagerInterval))
+	}
+	if o.autoscalerMinReplicas < 0 {
+		errs = append(errs, fmt.Errorf("autoscaler-missing-replicas must not be negative, got %d", o.autoscalerMinReplicas))
+	}
+	if o.archiveBucket != "" {
+		if _, err := parseArchiveBucket(o.archiveBucket, o.archiveEndpoint, o.archiveRegion); err != nil {
+			errs = append(errs, fmt.Errorf("archive-bucket: %w", err))
+		}
+		if o.archiveInterval < 1 {
+			errs = append(errs, fmt.Errorf("archive-interval must be at least 1 second, got %d", o.archiveInterval))
+		}
+	}
+	if o.weeklyAvailabilityWeeks < 0 {
+		errs = append(errs, fmt.Errorf("weekly-availability-weeks must not be negative, got %d", o.weeklyAvailabilityWeeks))
+	}
+	if o.rollbackAfter < 0 {
+		errs = append(errs, fmt.Errorf("rollback-after must not be negative, got %d", o.rollbackAfter))
+	}
+	if o.rollbackWindow < 1 {
+		errs = append(errs, fmt.Errorf("rollback-window must be at least 1 second, got %d", o.rollbackWindow))
+	}
+	if o.permissionCheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("permission-check-interval must not be negative, got %d", o.permissionCheckInterval))
+	}
+	if o.staleRolloutDays < 0 {
+		errs = append(errs, fmt.Errorf("stale-rollout-days must not be negative, got %d", o.staleRolloutDays))
+	}
+	if o.instanceID != "" {
+		if err := validateInstanceID(o.instanceID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.totalShards < 1 || o.shard < 0 || o.shard >= o.totalShards {
+		errs = append(errs, fmt.Errorf("shard=%d must be in [0, total-shards=%d)", o.shard, o.totalShards))
+	}
+	switch o.shardBy {
+	case shardByDeployment:
+	case shardByNamespace:
+		if len(splitList(o.namespace)) == 0 {
+			errs = append(errs, errors.New("shard-by=namespace requires namespace to list the watched namespaces"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("shard-by must be %s or %s, got %q", shardByDeployment, shardByNamespace, o.shardBy))
+	}
+	if o.namespaceTokenDir != "" && len(splitList(o.namespace)) == 0 {
+		errs = append(errs, errors.New("namespace-token-dir requires namespace to list the watched namespaces"))
+	}
+	if o.impersonateGroups != "" && o.impersonateUser == "" {
+		errs = append(errs, errors.New("as-group requires as to be set"))
+	}
+	if _, err := parseLogLevel(o.logLevel); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// RBAC gaps of the enabled collectors, which otherwise only show as
+	// list calls failing forever
+	exporterMissingPermission = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "exporter_missing_permission",
+			Help: "Whether a permission the enabled collectors need is missing (1) in any tracked namespace or granted (0), by group-qualified resource and verb",
+		},
+		[]string{"resource", "verb"},
+	)
+)
+
+// permission is one resource and verb the exporter needs, in a namespace or
+// cluster-wide ("").
+type permission struct {
+	namespace   string
+	group       string
+	resource    string
+	subresource string
+	verb        string
+}
+
+// label returns the resource as kubectl names it, e.g. deployments.apps or
+// nodes/proxy.
+func (p permission) label() string {
+	resource := p.resource
+	if p.group != "" {
+		resource += "." + p.group
+	}
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	return resource
+}
+
+// permissionChecker checks with SelfSubjectAccessReviews that the exporter
+// has the permissions of the RBAC `generate rbac` prints for its flags.
+type permissionChecker struct {
+	clientset   kubernetes.Interface
+	tenants     tenantClients // namespaced permissions are checked with the namespace's token
+	permissions []permission
+	missing     map[permission]bool // as of the last check, to log changes only
+}
+
+func newPermissionChecker(clientset kubernetes.Interface, tenants tenantClients, opts *options) *permissionChecker {
+	var permissions []permission
+	add := func(namespace string, rules []rbacv1.PolicyRule) {
+		for _, rule := range rules {
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					resource, subresource, _ := strings.Cut(resource, "/")
+					for _, verb := range rule.Verbs {
+						permissions = append(permissions, permission{namespace, group, resource, subresource, verb})
+					}
+				}
+			}
+		}
+	}
+	for _, namespace := range opts.trackedNamespaces() {
+		add(namespace, namespacedRules(opts))
+	}
+	add("", clusterRules(opts))
+	if opts.instanceID != "" {
+		add(opts.coordinationNamespace, []rbacv1.PolicyRule{coordinationRule})
+	}
+	return &permissionChecker{clientset: clientset, tenants: tenants, permissions: permissions, missing: make(map[permission]bool)}
+}
+
+// check reviews every permission, updates exporter_missing_permission and
+// logs the permissions that went missing or were granted since the last
+// check.
+func (c *permissionChecker) check(ctx context.Context) {
+	allowed := make(map[permission]bool, len(c.permissions))
+	for _, p := range c.permissions {
+		review, err := c.tenants.clientsetFor(p.namespace, c.clientset).AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.namespace,
+					Verb:        p.verb,
+					Group:       p.group,
+					Resource:    p.resource,
+					Subresource: p.subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			log.Printf("Error checking permission to %s %s: %v", p.verb, p.label(), err)
+			return
+		}
+		allowed[p] = review.Status.Allowed
+	}
+
+	type series struct{ resource, verb string }
+	missing := make(map[series]bool)
+	var lost []string
+	for _, p := range c.permissions {
+		key := series{p.label(), p.verb}
+		missing[key] = missing[key] || !allowed[p]
+		where := "cluster-wide"
+		if p.namespace != "" {
+			where = "in namespace " + p.namespace
+		}
+		switch {
+		case !allowed[p] && !c.missing[p]:
+			c.missing[p] = true
+			lost = append(lost, p.verb+" "+p.label()+" "+where)
+		case allowed[p] && c.missing[p]:
+			delete(c.missing, p)
+			log.Printf("Permission to %s %s %s granted", p.verb, p.label(), where)
+		}
+	}
+	for key, isMissing := range missing {
+		value := float64(0)
+		if isMissing {
+			value = 1
+		}
+		exporterMissingPermission.WithLabelValues(key.resource, key.verb).Set(value)
+	}
+	if len(lost) > 0 {
+		sort.Strings(lost)
+		log.Printf("Warning: Missing permissions, the affected collectors fail until they are granted (see `k8s-deployment-exporter generate rbac`): %s",
+			strings.Join(lost, ", "))
+	}
+}
+
+// run re-checks every interval, so permissions revoked or granted later are
+// noticed.
+func (c *permissionChecker) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.check(context.Background())
+	}
+}
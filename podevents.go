@@ -0,0 +1,203 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	// Pod startup time from creation to Ready
+	deploymentPodStartupSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "k8s_deployment_pod_startup_seconds",
+			Help:    "Time in seconds from pod creation until the pod first became Ready",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600},
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Scheduling latency from creation to binding, which grows while the
+	// cluster-autoscaler provisions nodes for pending pods
+	deploymentPodSchedulingSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "k8s_deployment_pod_scheduling_seconds",
+			Help:    "Time in seconds from pod creation until the pod was scheduled to a node (PodScheduled condition)",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300, 600},
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Pod readiness flaps (Ready -> NotReady -> Ready)
+	deploymentPodReadinessFlaps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_deployment_pod_readiness_flaps_total",
+			Help: "Total number of times a pod of the deployment lost readiness and became Ready again",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// podEventHandler derives per-pod metrics from pod informer events. Informer
+// handlers are called sequentially, so its state needs no locking.
+type podEventHandler struct {
+	tracker   *DeploymentTracker
+	started   map[types.UID]bool
+	scheduled map[types.UID]bool
+	notReady  map[types.UID]bool
+}
+
+func (t *DeploymentTracker) podEventHandler() cache.ResourceEventHandler {
+	h := &podEventHandler{
+		tracker:   t,
+		started:   make(map[types.UID]bool),
+		scheduled: make(map[types.UID]bool),
+		notReady:  make(map[types.UID]bool),
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    h.onAdd,
+		UpdateFunc: h.onUpdate,
+		DeleteFunc: h.onDelete,
+	}
+}
+
+func (h *podEventHandler) onAdd(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	// Pods that are already Ready when first listed started before we were
+	// watching; later readiness changes must not count as startups
+	if ready, _ := podReady(pod); ready {
+		h.started[pod.UID] = true
+	}
+	if scheduled, _ := podScheduled(pod); scheduled {
+		h.scheduled[pod.UID] = true
+	}
+	if ns, name, ok := h.tracker.podDeployment(pod); ok {
+		h.tracker.refresh.podsChanged(ns, name)
+	}
+}
+
+func (h *podEventHandler) onUpdate(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	pod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	ns, name, ok := h.tracker.podDeployment(pod)
+	if !ok || !h.tracker.ownsDeployment(ns, name) {
+		return
+	}
+	h.tracker.refresh.podsChanged(ns, name)
+
+	wasReady, _ := podReady(oldPod)
+	isReady, readySince := podReady(pod)
+	if wasReady != isReady {
+		debugf("Pod %s/%s of deployment %s ready changed %t -> %t", pod.Namespace, pod.Name, name, wasReady, isReady)
+	}
+
+	// Only the first Ready transition seen for a pod counts as its startup
+	if isReady && !wasReady && !h.started[pod.UID] {
+		h.started[pod.UID] = true
+		startup := readySince.Sub(pod.CreationTimestamp.Time)
+		if startup >= 0 {
+			deploymentPodStartupSeconds.WithLabelValues(ns, name).Observe(startup.Seconds())
+		}
+	}
+
+	// Likewise the first binding seen for a pod
+	if isScheduled, scheduledAt := podScheduled(pod); isScheduled && !h.scheduled[pod.UID] {
+		h.scheduled[pod.UID] = true
+		latency := scheduledAt.Sub(pod.CreationTimestamp.Time)
+		if latency >= 0 {
+			deploymentPodSchedulingSeconds.WithLabelValues(ns, name).Observe(latency.Seconds())
+		}
+		h.tracker.capacity.podScheduled(pod, ns, name, scheduledAt)
+	}
+
+	// Container restarts count towards restart storms
+	if restarts := containerRestarts(pod) - containerRestarts(oldPod); restarts > 0 {
+		h.tracker.restarts.observe(ns+"/"+name, int(restarts), time.Now())
+		h.tracker.predictor.observeRestarts(ns+"/"+name, int(restarts), time.Now())
+	}
+
+	// A pod losing readiness and regaining it is one flap
+	if wasReady && !isReady {
+		h.notReady[pod.UID] = true
+	}
+	if isReady && !wasReady && h.notReady[pod.UID] {
+		delete(h.notReady, pod.UID)
+		deploymentPodReadinessFlaps.WithLabelValues(ns, name).Inc()
+		h.tracker.predictor.observeFlap(ns+"/"+name, time.Now())
+	}
+}
+
+func (h *podEventHandler) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	delete(h.started, pod.UID)
+	delete(h.scheduled, pod.UID)
+	h.tracker.capacity.podDeleted(pod)
+	delete(h.notReady, pod.UID)
+	if ns, name, ok := h.tracker.podDeployment(pod); ok {
+		h.tracker.refresh.podsChanged(ns, name)
+	}
+}
+
+// podReady returns whether the pod's Ready condition is true and when it
+// last transitioned.
+func podReady(pod *corev1.Pod) (bool, time.Time) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue, condition.LastTransitionTime.Time
+		}
+	}
+	return false, time.Time{}
+}
+
+// podScheduled returns whether the pod's PodScheduled condition is true and
+// when it last transitioned.
+func podScheduled(pod *corev1.Pod) (bool, time.Time) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled {
+			return condition.Status == corev1.ConditionTrue, condition.LastTransitionTime.Time
+		}
+	}
+	return false, time.Time{}
+}
+
+// podDeployment resolves the deployment owning a pod through its ReplicaSet.
+func (t *DeploymentTracker) podDeployment(pod *corev1.Pod) (string, string, bool) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind != "ReplicaSet" {
+		return "", "", false
+	}
+
+	obj, exists, err := t.cachesFor(pod.Namespace).replicaSets.GetIndexer().GetByKey(pod.Namespace + "/" + ref.Name)
+	if err != nil || !exists {
+		return "", "", false
+	}
+	rs := obj.(*appsv1.ReplicaSet)
+
+	rsRef := metav1.GetControllerOf(rs)
+	if rsRef == nil || rsRef.Kind != "Deployment" {
+		return "", "", false
+	}
+	return pod.Namespace, rsRef.Name, true
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Annotation overriding the label selector used to find a deployment's pods
+const podSelectorAnnotation = "deployment-exporter/pod-selector"
+
+// Informer index of objects by the UID of their controlling owner
+const controllerUIDIndex = "controllerUID"
+
+var (
+	// Pods matched by the deployment selector but owned by another controller
+	deploymentSelectorUnownedPods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_selector_unowned_pods",
+			Help: "Number of pods matched by the deployment selector but not owned by its ReplicaSets (selector collision)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Terminating pods
+	deploymentPodsTerminating = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_pods_terminating",
+			Help: "Number of pods of the deployment that are terminating",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentPodsStuckTerminating = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_pods_stuck_terminating",
+			Help: "Number of terminating pods of the deployment that outlived their grace period",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentOldestTerminatingPodAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_oldest_terminating_pod_age_seconds",
+			Help: "Seconds since deletion was requested for the oldest terminating pod of the deployment (0 if none)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+func controllerUIDIndexFunc(obj interface{}) ([]string, error) {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	if ref := metav1.GetControllerOf(object); ref != nil {
+		return []string{string(ref.UID)}, nil
+	}
+	return nil, nil
+}
+
+// startInformers starts the pod and ReplicaSet informers backing pod
+// attribution and the informers of the enabled collectors, one set per
+// watched namespace, and blocks until the former are synced. With several
+// namespaces it waits at most namespaceSyncTimeout, so one namespace the
+// exporter can't read doesn't keep it from tracking the others.
+func (t *DeploymentTracker) startInformers(stopCh <-chan struct{}) {
+	scaledObjects := (t.autoscaleReplicas > 0 || t.keda) && t.scaledObjectsInstalled()
+
+	t.caches = make(map[string]*namespaceCaches, len(t.namespaces))
+	for _, namespace := range t.namespaces {
+		t.caches[namespace] = t.startNamespaceInformers(namespace, scaledObjects, stopCh)
+		if t.capacity != nil {
+			t.startScaleUpInformer(namespace, stopCh)
+		}
+	}
+
+	log.Println("Waiting for pod and replicaset caches to sync...")
+	var timeout <-chan time.Time
+	if len(t.namespaces) > 1 {
+		timeout = time.After(namespaceSyncTimeout)
+	}
+wait:
+	for _, namespace := range t.namespaces {
+		select {
+		case <-t.caches[namespace].synced:
+		case <-timeout:
+			log.Printf("Warning: Caches of some namespaces not synced after %s, their deployments are tracked once they are", namespaceSyncTimeout)
+			break wait
+		}
+	}
+
+	// Nodes are cluster-scoped, so they need their own factory
+	if t.nodeOS {
+		nodeFactory := informers.NewSharedInformerFactory(t.clientset, 0)
+		t.nodeInformer = nodeFactory.Core().V1().Nodes().Informer()
+		nodeFactory.Start(stopCh)
+		log.Println("Waiting for node cache to sync...")
+		nodeFactory.WaitForCacheSync(stopCh)
+	}
+	if t.terminating != nil {
+		t.startNamespaceInformer(stopCh)
+	}
+}
+
+// podSelector returns the label selector used for pod and pod metrics
+// lookups. The deployment-exporter/pod-selector annotation wins over
+// spec.selector so deployments sharing selector labels with other workloads
+// can be narrowed down.
+func podSelector(deployment *appsv1.Deployment) string {
+	if override, ok := deployment.Annotations[podSelectorAnnotation]; ok && override != "" {
+		if _, err := labels.Parse(override); err != nil {
+			log.Printf("Invalid %s annotation on deployment %s/%s, using spec.selector: %v",
+				podSelectorAnnotation, deployment.Namespace, deployment.Name, err)
+		} else {
+			return override
+		}
+	}
+	return metav1.FormatLabelSelector(deployment.Spec.Selector)
+}
+
+// startNamespaceInformers starts the informers of one namespace ("" for
+// all namespaces); their synced channel is closed once the caches are
+// synced.
+func (t *DeploymentTracker) startNamespaceInformers(namespace string, scaledObjects bool, stopCh <-chan struct{}) *namespaceCaches {
+	clientset := t.clientsetFor(namespace)
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+
+	caches := &namespaceCaches{
+		pods:        factory.Core().V1().Pods().Informer(),
+		replicaSets: factory.Apps().V1().ReplicaSets().Informer(),
+		synced:      make(chan struct{}),
+	}
+
+	indexers := cache.Indexers{controllerUIDIndex: controllerUIDIndexFunc}
+	if err := caches.pods.AddIndexers(indexers); err != nil {
+		log.Fatalf("Error adding pod informer indexers: %v", err)
+	}
+	if err := caches.replicaSets.AddIndexers(indexers); err != nil {
+		log.Fatalf("Error adding replicaset informer indexers: %v", err)
+	}
+	if _, err := caches.pods.AddEventHandler(t.podEventHandler()); err != nil {
+		log.Fatalf("Error adding pod event handler: %v", err)
+	}
+	if t.autoscaleReplicas > 0 {
+		caches.hpas = factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer()
+		if err := caches.hpas.AddIndexers(cache.Indexers{scaleTargetIndex: hpaScaleTargetIndexFunc}); err != nil {
+			log.Fatalf("Error adding hpa informer indexers: %v", err)
+		}
+	}
+	// Optional informers get their own factory, so the sync gate below
+	// doesn't wait for them
+	optionalFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+	if t.pvcMetrics {
+		caches.pvcs = optionalFactory.Core().V1().PersistentVolumeClaims().Informer()
+	}
+	if t.watchServices {
+		caches.services = optionalFactory.Core().V1().Services().Informer()
+	}
+	var dynamicFactory dynamicinformer.DynamicSharedInformerFactory
+	if scaledObjects {
+		dynamicFactory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(t.dynamicClientFor(namespace), 0, namespace, nil)
+		caches.scaledObjects = dynamicFactory.ForResource(scaledObjectResource).Informer()
+		if err := caches.scaledObjects.AddIndexers(cache.Indexers{scaleTargetIndex: scaledObjectScaleTargetIndexFunc}); err != nil {
+			log.Fatalf("Error adding scaledobject informer indexers: %v", err)
+		}
+	}
+
+	synced := exporterNamespaceCacheSynced.WithLabelValues(namespace)
+	synced.Set(0)
+	factory.Start(stopCh)
+	optionalFactory.Start(stopCh)
+	if dynamicFactory != nil {
+		dynamicFactory.Start(stopCh)
+	}
+	go func() {
+		factory.WaitForCacheSync(stopCh)
+		if dynamicFactory != nil {
+			dynamicFactory.WaitForCacheSync(stopCh)
+		}
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		synced.Set(1)
+		close(caches.synced)
+		debugf("Caches of %s synced", namespaceName(namespace))
+	}()
+	return caches
+}
+
+// ownedPods walks Deployment -> ReplicaSet -> Pod controller references in
+// the informer cache, so every pod belongs to exactly one deployment.
+func (t *DeploymentTracker) ownedPods(deployment *appsv1.Deployment) ([]*corev1.Pod, error) {
+	caches := t.cachesFor(deployment.Namespace)
+	replicaSets, err := caches.replicaSets.GetIndexer().ByIndex(controllerUIDIndex, string(deployment.UID))
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []*corev1.Pod
+	for _, obj := range replicaSets {
+		rs := obj.(*appsv1.ReplicaSet)
+		owned, err := caches.pods.GetIndexer().ByIndex(controllerUIDIndex, string(rs.UID))
+		if err != nil {
+			return nil, err
+		}
+		for _, podObj := range owned {
+			pods = append(pods, podObj.(*corev1.Pod))
+		}
+	}
+	return pods, nil
+}
+
+// listDeploymentPods returns the pods attributed to the deployment: pods
+// matched by the selector that are also owned by the deployment, or every
+// matched pod when owner matching is disabled.
+func (t *DeploymentTracker) listDeploymentPods(deployment *appsv1.Deployment, selector string) ([]*corev1.Pod, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := corelisters.NewPodLister(t.cachesFor(deployment.Namespace).pods.GetIndexer()).Pods(deployment.Namespace).List(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := t.ownedPods(deployment)
+	if err != nil {
+		return nil, err
+	}
+	ownedUIDs := make(map[types.UID]bool, len(owned))
+	for _, pod := range owned {
+		ownedUIDs[pod.UID] = true
+	}
+
+	attributed := make([]*corev1.Pod, 0, len(selected))
+	unowned := 0
+	for _, pod := range selected {
+		if !ownedUIDs[pod.UID] {
+			unowned++
+			if t.matchByOwner {
+				continue
+			}
+		}
+		attributed = append(attributed, pod)
+	}
+	deploymentSelectorUnownedPods.WithLabelValues(deployment.Namespace, deployment.Name).Set(float64(unowned))
+
+	return attributed, nil
+}
+
+// collectTerminatingMetrics reports pods stuck in Terminating, which keep
+// holding volumes and slow rollouts down.
+func collectTerminatingMetrics(namespace, deploymentName string, pods []*corev1.Pod) {
+	now := time.Now()
+	terminating, stuck := 0, 0
+	var oldestAge time.Duration
+
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil {
+			continue
+		}
+		terminating++
+
+		// DeletionTimestamp is when the grace period ends, not when deletion
+		// was requested
+		deadline := pod.DeletionTimestamp.Time
+		requested := deadline
+		if pod.DeletionGracePeriodSeconds != nil {
+			requested = deadline.Add(-time.Duration(*pod.DeletionGracePeriodSeconds) * time.Second)
+		}
+		if age := now.Sub(requested); age > oldestAge {
+			oldestAge = age
+		}
+		if now.After(deadline) {
+			stuck++
+		}
+	}
+
+	deploymentPodsTerminating.WithLabelValues(namespace, deploymentName).Set(float64(terminating))
+	deploymentPodsStuckTerminating.WithLabelValues(namespace, deploymentName).Set(float64(stuck))
+	deploymentOldestTerminatingPodAge.WithLabelValues(namespace, deploymentName).Set(oldestAge.Seconds())
+}
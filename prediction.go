@@ -0,0 +1,256 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Memory samples the trend is fitted over, and how far ahead reaching
+	// the limit counts as a risk
+	memoryTrendWindow  = 30 * time.Minute
+	memoryRiskHorizon  = time.Hour
+	memoryTrendSamples = 5
+
+	// Restarts and readiness flaps are compared between two halves of this
+	// window, and only count as accelerating from this many in the recent
+	// half
+	eventTrendWindow = time.Hour
+	eventTrendMin    = 3
+)
+
+// Failure risk factors
+const (
+	riskFactorMemory   = "memory"
+	riskFactorRestarts = "restarts"
+	riskFactorFlaps    = "readiness_flaps"
+)
+
+var riskFactors = []string{riskFactorMemory, riskFactorRestarts, riskFactorFlaps}
+
+var (
+	// Experimental: deployments trending toward failure before they fail
+	deploymentFailureRiskScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_failure_risk_score",
+			Help: "Experimental: likelihood (0-1) that the deployment is trending toward failure, combining the k8s_deployment_failure_risk_factor values",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentFailureRiskFactor = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_failure_risk_factor",
+			Help: "Experimental: contribution (0-1) of each factor to the failure risk score: memory usage ramping toward the limit, restarts or readiness flaps accelerating",
+		},
+		[]string{"namespace", "deployment", "factor"},
+	)
+)
+
+// memorySample is the memory usage of a deployment's containers as a
+// fraction of their limits.
+type memorySample struct {
+	at    time.Time
+	ratio float64
+}
+
+// memoryHistory keeps the recent memory-to-limit ratios of each deployment.
+type memoryHistory struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	samples map[string][]memorySample // namespace/deployment -> samples, oldest first
+}
+
+func newMemoryHistory(window time.Duration) *memoryHistory {
+	return &memoryHistory{window: window, samples: make(map[string][]memorySample)}
+}
+
+// forget drops the memory samples of a deleted deployment.
+func (h *memoryHistory) forget(ns, name string) {
+	if h == nil {
+		return
+	}
+	key := ns + "/" + name
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.samples, key)
+}
+
+func (h *memoryHistory) record(key string, at time.Time, ratio float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := append(h.samples[key], memorySample{at: at, ratio: ratio})
+	i := 0
+	for i < len(samples) && at.Sub(samples[i].at) > h.window {
+		i++
+	}
+	h.samples[key] = samples[i:]
+}
+
+// get returns a copy of the deployment's samples.
+func (h *memoryHistory) get(key string) []memorySample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]memorySample(nil), h.samples[key]...)
+}
+
+// linearTrend fits a line to the samples by least squares and returns its
+// slope per second and its value at the last sample.
+func linearTrend(samples []memorySample) (slope, current float64) {
+	n := float64(len(samples))
+	origin := samples[0].at
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := s.at.Sub(origin).Seconds()
+		sumX += x
+		sumY += s.ratio
+		sumXY += x * s.ratio
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, samples[len(samples)-1].ratio
+	}
+	slope = (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+	return slope, intercept + slope*samples[len(samples)-1].at.Sub(origin).Seconds()
+}
+
+// failurePredictor scores deployments by how fast they approach failure,
+// from the exporter's own recent samples.
+type failurePredictor struct {
+	memory *memoryHistory
+
+	mu       sync.Mutex
+	restarts map[string][]time.Time // namespace/deployment -> container restarts, oldest first
+	flaps    map[string][]time.Time // namespace/deployment -> readiness flaps, oldest first
+}
+
+func newFailurePredictor(memory *memoryHistory) *failurePredictor {
+	return &failurePredictor{
+		memory:   memory,
+		restarts: make(map[string][]time.Time),
+		flaps:    make(map[string][]time.Time),
+	}
+}
+
+// forget drops the restarts and flaps of a deleted deployment.
+func (p *failurePredictor) forget(ns, name string) {
+	if p == nil {
+		return
+	}
+	key := ns + "/" + name
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.restarts, key)
+	delete(p.flaps, key)
+}
+
+func (p *failurePredictor) observeRestarts(key string, restarts int, at time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < restarts; i++ {
+		p.restarts[key] = append(p.restarts[key], at)
+	}
+}
+
+func (p *failurePredictor) observeFlap(key string, at time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.flaps[key] = append(p.flaps[key], at)
+}
+
+// acceleration drops events that left the window and scores how much more
+// frequent they became in its recent half than in the earlier one.
+func acceleration(events map[string][]time.Time, key string, now time.Time) float64 {
+	times := events[key]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > eventTrendWindow {
+		i++
+	}
+	times = times[i:]
+	if len(times) == 0 {
+		delete(events, key)
+		return 0
+	}
+	events[key] = times
+
+	var earlier, recent int
+	for _, at := range times {
+		if now.Sub(at) > eventTrendWindow/2 {
+			earlier++
+		} else {
+			recent++
+		}
+	}
+	if recent < eventTrendMin || recent <= earlier {
+		return 0
+	}
+	return float64(recent-earlier) / float64(recent)
+}
+
+// memoryRisk scores how soon the memory trend of the last
+// memoryTrendWindow reaches the limit within memoryRiskHorizon: 1 at or over
+// the limit, 0 when flat, falling or too far out.
+func (p *failurePredictor) memoryRisk(key string) float64 {
+	samples := p.memory.get(key)
+	// The history may be kept longer for forecasts
+	for len(samples) > 0 && samples[len(samples)-1].at.Sub(samples[0].at) > memoryTrendWindow {
+		samples = samples[1:]
+	}
+	if len(samples) < memoryTrendSamples {
+		return 0
+	}
+	slope, current := linearTrend(samples)
+	if current >= 1 {
+		return 1
+	}
+	if slope <= 0 {
+		return 0
+	}
+	eta := time.Duration((1 - current) / slope * float64(time.Second))
+	if eta >= memoryRiskHorizon {
+		return 0
+	}
+	return 1 - eta.Seconds()/memoryRiskHorizon.Seconds()
+}
+
+// factors returns the deployment's risk factors.
+func (p *failurePredictor) factors(key string, now time.Time) map[string]float64 {
+	memory := p.memoryRisk(key)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]float64{
+		riskFactorMemory:   memory,
+		riskFactorRestarts: acceleration(p.restarts, key, now),
+		riskFactorFlaps:    acceleration(p.flaps, key, now),
+	}
+}
+
+// collectRiskMetrics reports the deployment's failure risk. The factors are
+// combined like independent probabilities, so any one of them can raise the
+// score to 1 and several moderate ones add up.
+func (t *DeploymentTracker) collectRiskMetrics(ns, name string, gauges *deploymentGauges, now time.Time) {
+	if t.predictor == nil {
+		return
+	}
+	factors := t.predictor.factors(ns+"/"+name, now)
+	safe := 1.0
+	for _, factor := range riskFactors {
+		gauges.labelled(deploymentFailureRiskFactor, factor).Set(factors[factor])
+		safe *= 1 - factors[factor]
+	}
+	gauges.gauge(deploymentFailureRiskScore).Set(1 - safe)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+var (
+	// Preview environments summed up by branch, as their per-deployment
+	// series come and go with every CI run
+	previewDeployments = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_preview_deployments",
+			Help: "Number of deployments in the preview namespaces of the branch",
+		},
+		[]string{"branch"},
+	)
+
+	previewDeploymentsDown = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_preview_deployments_down",
+			Help: "Number of deployments in the preview namespaces of the branch that are down",
+		},
+		[]string{"branch"},
+	)
+
+	previewDowntimes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_preview_downtimes_total",
+			Help: "Downtimes of deployments in the preview namespaces of the branch",
+		},
+		[]string{"branch"},
+	)
+)
+
+// previewMode tunes the exporter for ephemeral preview namespaces: the
+// series of deleted preview deployments are removed after a short TTL
+// instead of staying, and each branch gets aggregated series that outlive
+// its namespaces' churn.
+type previewMode struct {
+	namespaces *regexp.Regexp
+	ttl        time.Duration
+
+	mu       sync.Mutex
+	expiring map[string]time.Time // namespace/deployment of deleted preview deployments -> series deletion
+	branches map[string]time.Time // branch -> last cycle it had deployments
+}
+
+func newPreviewMode(opts *options) *previewMode {
+	return &previewMode{
+		namespaces: regexp.MustCompile(opts.previewNamespaceRegex),
+		ttl:        time.Duration(opts.previewSeriesTTL) * time.Second,
+		expiring:   make(map[string]time.Time),
+		branches:   make(map[string]time.Time),
+	}
+}
+
+// branch returns the branch of a preview namespace: the regex's "branch"
+// group, else its first group, else the namespace itself. ok is false for
+// other namespaces.
+func (p *previewMode) branch(namespace string) (branch string, ok bool) {
+	if p == nil {
+		return "", false
+	}
+	match := p.namespaces.FindStringSubmatch(namespace)
+	switch {
+	case match == nil:
+		return "", false
+	case p.namespaces.SubexpIndex("branch") > 0:
+		return match[p.namespaces.SubexpIndex("branch")], true
+	case len(match) > 1:
+		return match[1], true
+	}
+	return namespace, true
+}
+
+// forgotten schedules the series of a deleted preview deployment for
+// deletion.
+func (p *previewMode) forgotten(ns, name string, now time.Time) {
+	if _, ok := p.branch(ns); !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expiring[ns+"/"+name] = now.Add(p.ttl)
+}
+
+// collectPreviewMetrics updates the branch aggregates from the periodic list
+// and deletes the series that outlived the TTL. Branches of namespaces that
+// failed to list keep their last values.
+func (t *DeploymentTracker) collectPreviewMetrics(deployments []appsv1.Deployment, failed map[string]error, now time.Time) {
+	p := t.preview
+	if p == nil {
+		return
+	}
+
+	total := make(map[string]int)
+	down := make(map[string]int)
+	listed := make(map[string]bool)
+	for _, deployment := range deployments {
+		branch, ok := p.branch(deployment.Namespace)
+		if !ok || !t.ownsDeployment(deployment.Namespace, deployment.Name) {
+			continue
+		}
+		listed[deployment.Namespace+"/"+deployment.Name] = true
+		total[branch]++
+		if _, isDown := t.downtimeStart.get(deployment.Namespace + "/" + deployment.Name); isDown {
+			down[branch]++
+		}
+	}
+	stale := make(map[string]bool)
+	for namespace := range failed {
+		if branch, ok := p.branch(namespace); ok {
+			stale[branch] = true
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for branch, count := range total {
+		if stale[branch] {
+			continue
+		}
+		p.branches[branch] = now
+		previewDeployments.WithLabelValues(branch).Set(float64(count))
+		previewDeploymentsDown.WithLabelValues(branch).Set(float64(down[branch]))
+	}
+	for branch, seen := range p.branches {
+		if _, ok := total[branch]; ok || stale[branch] {
+			continue
+		}
+		if now.Sub(seen) < p.ttl {
+			previewDeployments.WithLabelValues(branch).Set(0)
+			previewDeploymentsDown.WithLabelValues(branch).Set(0)
+			continue
+		}
+		delete(p.branches, branch)
+		previewDeployments.DeleteLabelValues(branch)
+		previewDeploymentsDown.DeleteLabelValues(branch)
+		previewDowntimes.DeleteLabelValues(branch)
+	}
+
+	for key, due := range p.expiring {
+		// Recreated under the same name before its series expired
+		if listed[key] {
+			delete(p.expiring, key)
+			continue
+		}
+		if now.Before(due) {
+			continue
+		}
+		delete(p.expiring, key)
+		ns, name, _ := strings.Cut(key, "/")
+		t.deleteDeploymentSeries(ns, name)
+	}
+}
+
+// deleteDeploymentSeries deletes all series of a deployment.
+func (t *DeploymentTracker) deleteDeploymentSeries(ns, name string) {
+	scrapeLock.Lock()
+	defer scrapeLock.Unlock()
+	for _, vec := range registeredVecs {
+		vec.DeletePartialMatch(prometheus.Labels{"namespace": ns, "deployment": name})
+	}
+	t.gauges.forget(ns, name)
+	debugf("Deleted the series of deployment %s/%s", ns, name)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Window of the precomputed rates
+const rateWindow = time.Hour
+
+var (
+	// Rates precomputed for consumers that can't run PromQL's rate(), e.g.
+	// Zabbix bridges or simple dashboards
+	deploymentDowntimeRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_downtime_seconds_rate_1h",
+			Help: "Seconds the deployment was down within the last hour per second, i.e. the fraction of the hour it was down (0-1)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentRestartsRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_restarts_rate_1h",
+			Help: "Recoveries of the deployment (k8s_deployment_restart_total) within the last hour, i.e. restarts per hour",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// downInterval is a closed downtime of a deployment.
+type downInterval struct {
+	start time.Time
+	end   time.Time
+}
+
+// rollingRates remembers the downtimes that ended within the rate window.
+type rollingRates struct {
+	mu        sync.Mutex
+	downtimes map[string][]downInterval // namespace/deployment -> downtimes, oldest first
+}
+
+func newRollingRates() *rollingRates {
+	return &rollingRates{downtimes: make(map[string][]downInterval)}
+}
+
+// forget drops the downtimes of a deleted deployment.
+func (r *rollingRates) forget(ns, name string) {
+	if r == nil {
+		return
+	}
+	key := ns + "/" + name
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.downtimes, key)
+}
+
+// history returns a copy of the deployment's downtimes.
+func (r *rollingRates) history(key string) []downInterval {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]downInterval(nil), r.downtimes[key]...)
+}
+
+// inherit prepends the downtimes of a predecessor to the deployment's.
+func (r *rollingRates) inherit(key string, downtimes []downInterval) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downtimes[key] = append(downtimes, r.downtimes[key]...)
+}
+
+// recovered records a downtime that just ended.
+func (r *rollingRates) recovered(key string, start, end time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downtimes[key] = append(r.downtimes[key], downInterval{start: start, end: end})
+}
+
+// window drops downtimes that ended before the window and returns the
+// remaining ones.
+func (r *rollingRates) window(key string, now time.Time) []downInterval {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	downtimes := r.downtimes[key]
+	i := 0
+	for i < len(downtimes) && now.Sub(downtimes[i].end) > rateWindow {
+		i++
+	}
+	downtimes = downtimes[i:]
+	if len(downtimes) == 0 {
+		delete(r.downtimes, key)
+		return nil
+	}
+	r.downtimes[key] = downtimes
+	return append([]downInterval(nil), downtimes...)
+}
+
+// collectRateMetrics reports the deployment's downtime and restarts within
+// the last hour, including an ongoing downtime. Like weekly availability,
+// time the exporter was blind for doesn't count as downtime.
+func (t *DeploymentTracker) collectRateMetrics(key string, gauges *deploymentGauges, now time.Time) {
+	downtimes := t.rates.window(key, now)
+	restarts := len(downtimes)
+	if start, ok := t.downtimeStart.get(key); ok {
+		downtimes = append(downtimes, downInterval{start: start, end: now})
+	}
+
+	windowStart := now.Add(-rateWindow)
+	var down time.Duration
+	for _, d := range downtimes {
+		if overlap := overlapDuration(d.start, d.end, windowStart, now); overlap > 0 {
+			down += overlap - t.blindSpots.overlap(maxTime(d.start, windowStart), minTime(d.end, now))
+		}
+	}
+	gauges.gauge(deploymentDowntimeRate).Set(down.Seconds() / rateWindow.Seconds())
+	gauges.gauge(deploymentRestartsRate).Set(float64(restarts))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// Permission on the coordination ConfigMap of --instance-id
+var coordinationRule = rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "update"}}
+
+// namespacedRules returns the permissions the enabled collectors need on
+// namespaced resources of the tracked namespaces.
+func namespacedRules(opts *options) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments", "replicasets"}, Verbs: []string{"get", "list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch"}},
+		{APIGroups: []string{"metrics.k8s.io"}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+	}
+	if opts.watchServices {
+		rules = append(rules,
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"get", "list", "watch"}},
+			rbacv1.PolicyRule{APIGroups: []string{"networking.k8s.io"}, Resources: []string{"ingresses"}, Verbs: []string{"list"}})
+	}
+	if opts.pvcMetrics {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims"}, Verbs: []string{"get", "list", "watch"}})
+	}
+	if opts.autoscalerMinReplicas > 0 {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{"autoscaling"}, Resources: []string{"horizontalpodautoscalers"}, Verbs: []string{"get", "list", "watch"}})
+	}
+	if opts.autoscalerMinReplicas > 0 || opts.keda {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{"keda.sh"}, Resources: []string{"scaledobjects"}, Verbs: []string{"get", "list", "watch"}})
+	}
+	if opts.clusterAutoscaler {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"list", "watch"}})
+	}
+	if opts.rollbackAfter > 0 && opts.rollbackWebhookURL == "" && !opts.rollbackDryRun {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"patch"}})
+	}
+	return rules
+}
+
+// clusterRules returns the permissions on cluster-scoped resources, which
+// always need a ClusterRole.
+func clusterRules(opts *options) []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	if opts.pvcUsage || opts.cpuThrottling {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"nodes/proxy"}, Verbs: []string{"get"}})
+	}
+	if opts.nodeOS {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list", "watch"}})
+	}
+	if opts.namespaceLifecycle {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"get", "list", "watch"}})
+	}
+	return rules
+}
+
+// runGenerate implements `generate rbac`, printing the minimal RBAC
+// manifests for the collectors enabled by the given flags or config file.
+func runGenerate(args []string) int {
+	if len(args) == 0 || args[0] != "rbac" {
+		fmt.Fprintln(os.Stderr, "usage: k8s-deployment-exporter generate rbac [--namespaces=a,b] [exporter flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("generate rbac", flag.ContinueOnError)
+	opts := &options{}
+	opts.bindFlags(fs)
+	namespaces := fs.String("namespaces", "", "Comma-separated namespaces to grant access to with Roles (default --namespace, empty = cluster-wide ClusterRole)")
+	name := fs.String("name", "k8s-deployment-exporter", "Name of the generated roles and bindings")
+	serviceAccount := fs.String("service-account", "k8s-deployment-exporter", "Service account the exporter runs as")
+	serviceAccountNamespace := fs.String("service-account-namespace", "monitoring", "Namespace of the service account")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if opts.configFile != "" {
+		if err := loadConfigFile(fs, opts.configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	subject := rbacv1.Subject{Kind: "ServiceAccount", Name: *serviceAccount, Namespace: *serviceAccountNamespace}
+	var objects []runtime.Object
+
+	// Default to the namespace the exporter is configured to monitor
+	if *namespaces == "" {
+		*namespaces = opts.namespace
+	}
+	scoped := splitList(*namespaces)
+
+	cluster := clusterRules(opts)
+	if len(scoped) == 0 {
+		cluster = append(namespacedRules(opts), cluster...)
+	}
+	if len(cluster) > 0 {
+		objects = append(objects,
+			&rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+				ObjectMeta: metav1.ObjectMeta{Name: *name},
+				Rules:      cluster,
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: *name},
+				RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: *name},
+				Subjects:   []rbacv1.Subject{subject},
+			},
+		)
+	}
+
+	roles := make(map[string][]rbacv1.PolicyRule)
+	for _, ns := range scoped {
+		roles[ns] = append(roles[ns], namespacedRules(opts)...)
+	}
+	if opts.instanceID != "" {
+		roles[opts.coordinationNamespace] = append(roles[opts.coordinationNamespace], coordinationRule)
+	}
+	for _, ns := range append(scoped, opts.coordinationNamespace) {
+		rules, ok := roles[ns]
+		if !ok {
+			continue
+		}
+		delete(roles, ns)
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: *name, Namespace: ns},
+				Rules:      rules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: *name, Namespace: ns},
+				RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: *name},
+				Subjects:   []rbacv1.Subject{subject},
+			},
+		)
+	}
+
+	for _, object := range objects {
+		out, err := manifestYAML(object)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("---\n%s", out)
+	}
+	return 0
+}
+
+// manifestYAML renders an object as a manifest without the empty
+// creationTimestamp that typed objects always serialize.
+func manifestYAML(object runtime.Object) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	if metadata, ok := content["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	return yaml.Marshal(content)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Resource collections skipped because the deployment's pods didn't
+	// change since the last one
+	exporterResourceCollectionsSkipped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "exporter_resource_collections_skipped_total",
+			Help: "Number of times resource metrics of a deployment were not re-collected because its pods didn't change",
+		},
+	)
+)
+
+// resourceRefresh decides when a deployment's resource metrics (pod
+// listing, metrics-server, kubelet calls) are re-collected: once pod
+// informer events changed its pods, and otherwise only after the fallback
+// interval, so steady-state deployments don't cost API calls every cycle.
+type resourceRefresh struct {
+	interval time.Duration
+
+	mu        sync.Mutex
+	changed   map[string]bool      // namespace/deployment -> pods changed since the last collection
+	collected map[string]time.Time // namespace/deployment -> last collection
+}
+
+func newResourceRefresh(interval time.Duration) *resourceRefresh {
+	return &resourceRefresh{
+		interval:  interval,
+		changed:   make(map[string]bool),
+		collected: make(map[string]time.Time),
+	}
+}
+
+// forget drops the collection times of a deleted deployment.
+func (r *resourceRefresh) forget(ns, name string) {
+	if r == nil {
+		return
+	}
+	key := ns + "/" + name
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.changed, key)
+	delete(r.collected, key)
+}
+
+// podsChanged marks the deployment's resource metrics as stale.
+func (r *resourceRefresh) podsChanged(ns, name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changed[ns+"/"+name] = true
+}
+
+// due reports whether the deployment's resource metrics are to be collected
+// now, and if so records the collection. Without a refresh interval they
+// are collected on every cycle.
+func (r *resourceRefresh) due(key string, now time.Time) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.changed[key] && now.Sub(r.collected[key]) < r.interval {
+		exporterResourceCollectionsSkipped.Inc()
+		return false
+	}
+	delete(r.changed, key)
+	r.collected[key] = now
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	// Burstability of the pod template
+	deploymentCPULimitToRequestRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_cpu_limit_to_request_ratio",
+			Help: "Ratio of total CPU limits to total CPU requests of the deployment's pod template",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentMemoryLimitToRequestRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_memory_limit_to_request_ratio",
+			Help: "Ratio of total memory limits to total memory requests of the deployment's pod template",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentContainersWithoutRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_containers_without_requests",
+			Help: "Number of containers in the deployment's pod template without a CPU or memory request",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentQoSGuaranteed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_qos_guaranteed",
+			Help: "Whether the deployment's pods get the Guaranteed QoS class, i.e. limits equal requests for CPU and memory in every container (1 = guaranteed)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// collectRequestPolicyMetrics reports how the pod template's requests and
+// limits relate, for fleet-wide burstability policies. The ratios are only
+// set when both totals are non-zero.
+func collectRequestPolicyMetrics(deployment *appsv1.Deployment) {
+	ns := deployment.Namespace
+	name := deployment.Name
+	template := deployment.Spec.Template.Spec
+
+	var cpuRequest, cpuLimit, memoryRequest, memoryLimit resource.Quantity
+	withoutRequests := 0
+	for _, container := range template.Containers {
+		requests := container.Resources.Requests
+		limits := container.Resources.Limits
+		if requests.Cpu().IsZero() || requests.Memory().IsZero() {
+			withoutRequests++
+		}
+		cpuRequest.Add(*requests.Cpu())
+		cpuLimit.Add(*limits.Cpu())
+		memoryRequest.Add(*requests.Memory())
+		memoryLimit.Add(*limits.Memory())
+	}
+	deploymentContainersWithoutRequests.WithLabelValues(ns, name).Set(float64(withoutRequests))
+
+	if cpuRequest.MilliValue() > 0 && cpuLimit.MilliValue() > 0 {
+		deploymentCPULimitToRequestRatio.WithLabelValues(ns, name).Set(float64(cpuLimit.MilliValue()) / float64(cpuRequest.MilliValue()))
+	} else {
+		deploymentCPULimitToRequestRatio.DeleteLabelValues(ns, name)
+	}
+	if memoryRequest.Value() > 0 && memoryLimit.Value() > 0 {
+		deploymentMemoryLimitToRequestRatio.WithLabelValues(ns, name).Set(float64(memoryLimit.Value()) / float64(memoryRequest.Value()))
+	} else {
+		deploymentMemoryLimitToRequestRatio.DeleteLabelValues(ns, name)
+	}
+
+	guaranteed := float64(1)
+	for _, containers := range [][]corev1.Container{template.InitContainers, template.Containers} {
+		for _, container := range containers {
+			if !guaranteedResources(container.Resources) {
+				guaranteed = 0
+			}
+		}
+	}
+	deploymentQoSGuaranteed.WithLabelValues(ns, name).Set(guaranteed)
+}
+
+// guaranteedResources reports whether a container sets CPU and memory limits
+// with requests equal to them. Unset requests default to the limits.
+func guaranteedResources(resources corev1.ResourceRequirements) bool {
+	for _, resourceName := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		limit, ok := resources.Limits[resourceName]
+		if !ok || limit.IsZero() {
+			return false
+		}
+		if request, ok := resources.Requests[resourceName]; ok && request.Cmp(limit) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// Crash loops that don't take the deployment down
+	deploymentRestartStorm = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_restart_storm",
+			Help: "Whether the deployment's containers restarted more than the restart storm threshold within the window (1 = storm)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentRecentRestarts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_container_restarts_in_window",
+			Help: "Number of container restarts of the deployment's pods within the restart storm window",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// restartTracker remembers when containers of each deployment restarted, as
+// seen in pod informer updates.
+type restartTracker struct {
+	window    time.Duration
+	threshold int
+
+	mu       sync.Mutex
+	restarts map[string][]time.Time // namespace/deployment -> restart times, oldest first
+	storming map[string]bool
+}
+
+func newRestartTracker(window time.Duration, threshold int) *restartTracker {
+	return &restartTracker{
+		window:    window,
+		threshold: threshold,
+		restarts:  make(map[string][]time.Time),
+		storming:  make(map[string]bool),
+	}
+}
+
+// forget drops the restart history of a deleted deployment.
+func (r *restartTracker) forget(ns, name string) {
+	if r == nil {
+		return
+	}
+	key := ns + "/" + name
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.restarts, key)
+	delete(r.storming, key)
+}
+
+// containerRestarts sums the restart counts of a pod's containers.
+func containerRestarts(pod *corev1.Pod) int32 {
+	var restarts int32
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			restarts += status.RestartCount
+		}
+	}
+	return restarts
+}
+
+func (r *restartTracker) observe(key string, restarts int, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < restarts; i++ {
+		r.restarts[key] = append(r.restarts[key], at)
+	}
+}
+
+// update drops restarts that left the window and returns the number of
+// remaining ones, whether they form a storm and whether that changed.
+func (r *restartTracker) update(key string, now time.Time) (count int, storm, changed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	times := r.restarts[key]
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > r.window {
+		i++
+	}
+	times = times[i:]
+	if len(times) == 0 {
+		delete(r.restarts, key)
+	} else {
+		r.restarts[key] = times
+	}
+
+	storm = len(times) > r.threshold
+	changed = storm != r.storming[key]
+	if storm {
+		r.storming[key] = true
+	} else {
+		delete(r.storming, key)
+	}
+	return len(times), storm, changed
+}
+
+// collectRestartStormMetrics reports restart storms and notifies when one
+// starts or ends, independently of the deployment's ready state.
+func (t *DeploymentTracker) collectRestartStormMetrics(namespace, deploymentName string, now time.Time) {
+	count, storm, changed := t.restarts.update(namespace+"/"+deploymentName, now)
+
+	value := float64(0)
+	if storm {
+		value = 1
+	}
+	deploymentRestartStorm.WithLabelValues(namespace, deploymentName).Set(value)
+	deploymentRecentRestarts.WithLabelValues(namespace, deploymentName).Set(float64(count))
+
+	if !changed || t.silenced(namespace, deploymentName, now) {
+		return
+	}
+	ev := event{
+		Type:       eventRestartStorm,
+		Namespace:  namespace,
+		Deployment: deploymentName,
+		Time:       now,
+		Message: fmt.Sprintf("Deployment %s/%s is in a restart storm: %d container restarts within %s",
+			namespace, deploymentName, count, t.restarts.window),
+	}
+	if !storm {
+		ev.Type = eventRestartStormEnded
+		ev.Message = fmt.Sprintf("Deployment %s/%s restart storm ended", namespace, deploymentName)
+	}
+	t.events.add(ev)
+}
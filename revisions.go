@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Label the deployment controller tells the ReplicaSets' pods apart with
+const podTemplateHashLabel = "pod-template-hash"
+
+// Values of the revision label: the current pod template, and all previous
+// ones still running during a rollout
+const (
+	revisionNew = "new"
+	revisionOld = "old"
+)
+
+var revisions = []string{revisionOld, revisionNew}
+
+var (
+	// Capacity of the old and new pod templates, crossing over during a
+	// rollout; one that stops crossing over is stuck
+	deploymentRevisionReplicas = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_revision_replicas",
+			Help: "Replicas of the deployment's new (current) or old (previous) pod templates; old is 0 outside of rollouts",
+		},
+		[]string{"namespace", "deployment", "revision"},
+	)
+
+	deploymentRevisionReplicasReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_revision_replicas_ready",
+			Help: "Ready replicas of the deployment's new (current) or old (previous) pod templates",
+		},
+		[]string{"namespace", "deployment", "revision"},
+	)
+
+	deploymentRevisionCPUUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_revision_cpu_usage_millicores",
+			Help: "CPU usage in millicores of the pods of the deployment's new (current) or old (previous) pod templates",
+		},
+		[]string{"namespace", "deployment", "revision"},
+	)
+
+	deploymentRevisionMemoryUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_revision_memory_usage_mebibytes",
+			Help: "Memory usage in MiB of the pods of the deployment's new (current) or old (previous) pod templates",
+		},
+		[]string{"namespace", "deployment", "revision"},
+	)
+)
+
+// newReplicaSet returns the deployment's ReplicaSet of the current pod
+// template, nil if the controller hasn't created it yet.
+func newReplicaSet(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) *appsv1.ReplicaSet {
+	current := deployment.Annotations[revisionAnnotation]
+	var newest *appsv1.ReplicaSet
+	for _, rs := range replicaSets {
+		if current != "" && rs.Annotations[revisionAnnotation] == current {
+			return rs
+		}
+		if newest == nil || replicaSetRevision(rs) > replicaSetRevision(newest) {
+			newest = rs
+		}
+	}
+	if current != "" {
+		return nil
+	}
+	return newest
+}
+
+// collectRevisionMetrics splits the deployment's replicas into the new and
+// the old pod templates.
+func collectRevisionMetrics(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) {
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	current := newReplicaSet(deployment, replicaSets)
+	replicas := make(map[string]int32)
+	ready := make(map[string]int32)
+	for _, rs := range replicaSets {
+		revision := revisionOld
+		if rs == current {
+			revision = revisionNew
+		}
+		replicas[revision] += rs.Status.Replicas
+		ready[revision] += rs.Status.ReadyReplicas
+	}
+	for _, revision := range revisions {
+		deploymentRevisionReplicas.WithLabelValues(ns, name, revision).Set(float64(replicas[revision]))
+		deploymentRevisionReplicasReady.WithLabelValues(ns, name, revision).Set(float64(ready[revision]))
+	}
+}
+
+// collectRevisionUsageMetrics splits the usage of the deployment's pods into
+// the new and the old pod templates. Usage of pods whose ReplicaSet isn't
+// known counts as old.
+func (t *DeploymentTracker) collectRevisionUsageMetrics(deployment *appsv1.Deployment, pods []*corev1.Pod, podMetrics []metricsv1beta1.PodMetrics) {
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	replicaSets, err := t.deploymentReplicaSets(deployment)
+	if err != nil {
+		log.Printf("Error listing replicasets for deployment %s/%s: %v", ns, name, err)
+		return
+	}
+	var hash string
+	if current := newReplicaSet(deployment, replicaSets); current != nil {
+		hash = current.Labels[podTemplateHashLabel]
+	}
+	podRevisions := make(map[string]string, len(pods))
+	for _, pod := range pods {
+		podRevisions[pod.Name] = revisionOld
+		if hash != "" && pod.Labels[podTemplateHashLabel] == hash {
+			podRevisions[pod.Name] = revisionNew
+		}
+	}
+
+	cpu := make(map[string]int64)
+	memory := make(map[string]int64)
+	for _, pm := range podMetrics {
+		revision, ok := podRevisions[pm.Name]
+		if !ok {
+			revision = revisionOld
+		}
+		for _, container := range pm.Containers {
+			cpuUsage := container.Usage[corev1.ResourceCPU]
+			memUsage := container.Usage[corev1.ResourceMemory]
+			cpu[revision] += cpuUsage.MilliValue()
+			memory[revision] += memUsage.Value()
+		}
+	}
+	for _, revision := range revisions {
+		deploymentRevisionCPUUsage.WithLabelValues(ns, name, revision).Set(float64(cpu[revision]))
+		deploymentRevisionMemoryUsage.WithLabelValues(ns, name, revision).Set(float64(memory[revision]) / 1024 / 1024)
+	}
+}
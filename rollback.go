@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotation opting a deployment into automatic rollback
+const autoRollbackAnnotation = "deployment-exporter/auto-rollback"
+
+// Annotation the deployment controller numbers ReplicaSets with
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+var (
+	deploymentAutoRollbacks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_deployment_auto_rollbacks_total",
+			Help: "Number of automatic rollbacks of the deployment after a failed rollout (the patch or webhook call succeeded)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Failed patches or webhook calls, retried with the next scrape
+	deploymentAutoRollbackFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_deployment_auto_rollback_failures_total",
+			Help: "Number of automatic rollbacks of the deployment whose patch or webhook call failed",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// rollbackHook rolls back opted-in deployments that stay down for longer than
+// after, if the downtime started within window of their last rollout. It
+// either patches the deployment back to the previous revision, like
+// `kubectl rollout undo`, or calls a webhook to do so.
+type rollbackHook struct {
+	clientsetFor func(namespace string) kubernetes.Interface
+	after        time.Duration
+	window       time.Duration
+	webhookURL   string
+	dryRun       bool
+	client       *http.Client
+
+	mu       sync.Mutex
+	done     map[string]int64 // namespace/deployment -> revision rolled back from
+	inFlight map[string]bool  // namespace/deployment -> rollback running
+}
+
+func newRollbackHook(clientsetFor func(namespace string) kubernetes.Interface, opts *options) *rollbackHook {
+	return &rollbackHook{
+		clientsetFor: clientsetFor,
+		after:        time.Duration(opts.rollbackAfter) * time.Second,
+		window:       time.Duration(opts.rollbackWindow) * time.Second,
+		webhookURL:   opts.rollbackWebhookURL,
+		dryRun:       opts.rollbackDryRun || opts.dryRun,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		done:         make(map[string]int64),
+		inFlight:     make(map[string]bool),
+	}
+}
+
+// forget drops the rollback record of a deleted deployment.
+func (h *rollbackHook) forget(ns, name string) {
+	if h == nil {
+		return
+	}
+	key := ns + "/" + name
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.done, key)
+}
+
+// replicaSetRevision parses the revision annotation of a ReplicaSet, 0 if it
+// is missing.
+func replicaSetRevision(rs *appsv1.ReplicaSet) int64 {
+	revision, _ := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+	return revision
+}
+
+// check triggers a rollback of a down deployment if it qualifies. The
+// rollback itself runs in the background; the revision only counts as rolled
+// back once it succeeded, a failed one is tried again by the next check.
+func (h *rollbackHook) check(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet, downSince, now time.Time) {
+	if deployment.Annotations[autoRollbackAnnotation] != "true" || now.Sub(downSince) < h.after {
+		return
+	}
+
+	// Current and previous revision
+	var current, previous *appsv1.ReplicaSet
+	for _, rs := range replicaSets {
+		if current == nil || replicaSetRevision(rs) > replicaSetRevision(current) {
+			current = rs
+		}
+	}
+	if current == nil {
+		return
+	}
+	for _, rs := range replicaSets {
+		revision := replicaSetRevision(rs)
+		if revision < replicaSetRevision(current) && (previous == nil || revision > replicaSetRevision(previous)) {
+			previous = rs
+		}
+	}
+
+	// Only downtimes caused by the rollout, not ones that started before it
+	// or long after it
+	sinceRollout := downSince.Sub(current.CreationTimestamp.Time)
+	if previous == nil || sinceRollout < 0 || sinceRollout > h.window {
+		return
+	}
+
+	key := deployment.Namespace + "/" + deployment.Name
+	revision := replicaSetRevision(current)
+	h.mu.Lock()
+	if h.done[key] == revision || h.inFlight[key] {
+		h.mu.Unlock()
+		return
+	}
+	if h.dryRun {
+		h.done[key] = revision
+		h.mu.Unlock()
+		log.Printf("[dry-run] Deployment %s/%s down for %s after rollout of revision %d, would roll back to revision %d",
+			deployment.Namespace, deployment.Name, now.Sub(downSince).Round(time.Second), revision, replicaSetRevision(previous))
+		return
+	}
+	h.inFlight[key] = true
+	h.mu.Unlock()
+
+	log.Printf("Deployment %s/%s down for %s after rollout of revision %d, rolling back to revision %d",
+		deployment.Namespace, deployment.Name, now.Sub(downSince).Round(time.Second), revision, replicaSetRevision(previous))
+	go func() {
+		err := h.rollback(deployment, current, previous)
+
+		h.mu.Lock()
+		delete(h.inFlight, key)
+		if err == nil {
+			h.done[key] = revision
+		}
+		h.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Error rolling back deployment %s/%s, retrying with the next scrape: %v", deployment.Namespace, deployment.Name, err)
+			deploymentAutoRollbackFailures.WithLabelValues(deployment.Namespace, deployment.Name).Inc()
+			return
+		}
+		deploymentAutoRollbacks.WithLabelValues(deployment.Namespace, deployment.Name).Inc()
+	}()
+}
+
+func (h *rollbackHook) rollback(deployment *appsv1.Deployment, current, previous *appsv1.ReplicaSet) error {
+	if h.webhookURL != "" {
+		body, err := json.Marshal(map[string]interface{}{
+			"namespace":    deployment.Namespace,
+			"deployment":   deployment.Name,
+			"fromRevision": replicaSetRevision(current),
+			"toRevision":   replicaSetRevision(previous),
+		})
+		if err != nil {
+			return err
+		}
+		resp, err := h.client.Post(h.webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("rollback webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+
+	// Restore the previous pod template without the label the controller
+	// adds to tell ReplicaSets apart
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.clientsetFor(deployment.Namespace).AppsV1().Deployments(deployment.Namespace).Patch(context.Background(), deployment.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+	return err
+}
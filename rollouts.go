@@ -0,0 +1,138 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+var (
+	// Last time the pod template changed
+	deploymentLastRolloutTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_last_rollout_timestamp_seconds",
+			Help: "Unix timestamp of the last pod template change (creation of the newest ReplicaSet)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Time since the last rollout
+	deploymentRolloutAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_rollout_age_seconds",
+			Help: "Seconds since the last pod template change",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Deployments not rolled out for longer than --stale-rollout-days
+	deploymentRolloutStale = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_rollout_stale",
+			Help: "Whether the deployment has not been rolled out for longer than the staleness threshold (1 = stale)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// ReplicaSets kept for rollback
+	deploymentReplicaSetCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_replicaset_count",
+			Help: "Number of ReplicaSets owned by the deployment",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentRevisionHistoryLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_revision_history_limit",
+			Help: "Configured spec.revisionHistoryLimit of the deployment",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Old ReplicaSets the controller failed to clean up
+	deploymentReplicaSetsOverLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_replicasets_over_history_limit",
+			Help: "Number of old, scaled-down ReplicaSets exceeding the revision history limit (0 = within limit)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// Kubernetes' default spec.revisionHistoryLimit
+const defaultRevisionHistoryLimit = 10
+
+// deploymentReplicaSets returns the ReplicaSets controlled by the deployment
+// from the informer cache.
+func (t *DeploymentTracker) deploymentReplicaSets(deployment *appsv1.Deployment) ([]*appsv1.ReplicaSet, error) {
+	objs, err := t.cachesFor(deployment.Namespace).replicaSets.GetIndexer().ByIndex(controllerUIDIndex, string(deployment.UID))
+	if err != nil {
+		return nil, err
+	}
+	replicaSets := make([]*appsv1.ReplicaSet, 0, len(objs))
+	for _, obj := range objs {
+		replicaSets = append(replicaSets, obj.(*appsv1.ReplicaSet))
+	}
+	return replicaSets, nil
+}
+
+// collectRolloutMetrics exposes when the deployment was last rolled out so
+// long-unpatched services can be found.
+func (t *DeploymentTracker) collectRolloutMetrics(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet, now time.Time) {
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	// Every template change creates a ReplicaSet. A rollback re-activates
+	// an older one instead, so the newest creation is a lower bound then
+	var lastRollout time.Time
+	for _, rs := range replicaSets {
+		if rs.CreationTimestamp.Time.After(lastRollout) {
+			lastRollout = rs.CreationTimestamp.Time
+		}
+	}
+	if lastRollout.IsZero() {
+		debugf("No ReplicaSet found for deployment %s/%s, skipping rollout metrics", ns, name)
+		return
+	}
+
+	age := now.Sub(lastRollout)
+	deploymentLastRolloutTimestamp.WithLabelValues(ns, name).Set(float64(lastRollout.Unix()))
+	deploymentRolloutAge.WithLabelValues(ns, name).Set(age.Seconds())
+
+	stale := float64(0)
+	if t.staleRolloutAge > 0 && age > t.staleRolloutAge {
+		stale = 1
+	}
+	deploymentRolloutStale.WithLabelValues(ns, name).Set(stale)
+}
+
+// collectReplicaSetMetrics reports ReplicaSet bloat: old, scaled-down
+// ReplicaSets beyond revisionHistoryLimit should have been garbage collected
+// by the deployment controller and slow down its reconciliation.
+func collectReplicaSetMetrics(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) {
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	limit := int32(defaultRevisionHistoryLimit)
+	if deployment.Spec.RevisionHistoryLimit != nil {
+		limit = *deployment.Spec.RevisionHistoryLimit
+	}
+
+	old := 0
+	for _, rs := range replicaSets {
+		if rs.Spec.Replicas != nil && *rs.Spec.Replicas == 0 && rs.Status.Replicas == 0 {
+			old++
+		}
+	}
+	overLimit := old - int(limit)
+	if overLimit < 0 {
+		overLimit = 0
+	}
+
+	deploymentReplicaSetCount.WithLabelValues(ns, name).Set(float64(len(replicaSets)))
+	deploymentRevisionHistoryLimit.WithLabelValues(ns, name).Set(float64(limit))
+	deploymentReplicaSetsOverLimit.WithLabelValues(ns, name).Set(float64(overLimit))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	// Different label values that became the same after sanitization
+	exporterLabelValueCollisions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "exporter_label_value_collisions_total",
+			Help: "Number of times two distinct label values exposed together were sanitized to the same value",
+		},
+	)
+)
+
+// labelSanitizer rewrites label values on their way out of the registry,
+// so every metric (and every exposition: /metrics, dry run and
+// Graphite/StatsD pushes) uses the same sanitized values. Runes matching
+// disallowed are replaced with _, and values longer than maxLength runes are
+// truncated with a hash of the original value appended, keeping them unique.
+//
+// Its state only covers the values of the last Gather, so the values of
+// deleted deployments, old revisions and the like don't pile up.
+type labelSanitizer struct {
+	disallowed *regexp.Regexp
+	maxLength  int
+	keepUTF8   bool            // non-ASCII runes are never replaced
+	utf8       *labelSanitizer // variant for scrapers negotiating UTF-8
+
+	mu         sync.Mutex
+	previous   map[string]string // original -> sanitized, of the last Gather
+	collisions map[string]bool   // collisions of the last Gather, already reported
+}
+
+// sanitizePass is the state of one Gather.
+type sanitizePass struct {
+	sanitized  map[string]string // original -> sanitized
+	originals  map[string]string // sanitized -> first original
+	collisions map[string]bool   // colliding originals, see collisionKey
+}
+
+func newSanitizePass() *sanitizePass {
+	return &sanitizePass{
+		sanitized:  make(map[string]string),
+		originals:  make(map[string]string),
+		collisions: make(map[string]bool),
+	}
+}
+
+// collisionKey identifies a collision independently of which of the two
+// values was seen first.
+func collisionKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "\xff" + b
+}
+
+// newLabelSanitizer returns nil when no sanitization is configured.
+func newLabelSanitizer(opts *options) *labelSanitizer {
+	if opts.labelSanitizeRegex == "" && opts.labelValueMaxLength == 0 {
+		return nil
+	}
+	s := &labelSanitizer{maxLength: opts.labelValueMaxLength}
+	if opts.labelSanitizeRegex != "" {
+		s.disallowed = regexp.MustCompile(opts.labelSanitizeRegex)
+	}
+	if opts.utf8LabelValues {
+		s.utf8 = &labelSanitizer{
+			disallowed: s.disallowed,
+			maxLength:  s.maxLength,
+			keepUTF8:   true,
+		}
+	}
+	return s
+}
+
+// Length of the hash suffix of truncated values, including the separator
+const labelHashSuffixLength = 9
+
+// sanitize returns the sanitized form of a label value within a Gather, and
+// reports collisions with other values of the same Gather that weren't
+// already reported by the previous one. The caller holds s.mu.
+func (s *labelSanitizer) sanitize(pass *sanitizePass, value string) string {
+	if sanitized, ok := pass.sanitized[value]; ok {
+		return sanitized
+	}
+	sanitized, ok := s.previous[value]
+	if !ok {
+		sanitized = s.rewrite(value)
+	}
+
+	if original, ok := pass.originals[sanitized]; ok && original != value {
+		key := collisionKey(original, value)
+		pass.collisions[key] = true
+		if !s.collisions[key] {
+			exporterLabelValueCollisions.Inc()
+			log.Printf("Warning: Label values %q and %q are both sanitized to %q", original, value, sanitized)
+		}
+	} else if !ok {
+		pass.originals[sanitized] = value
+	}
+	pass.sanitized[value] = sanitized
+	return sanitized
+}
+
+// rewrite replaces the disallowed runes of a value and truncates it.
+func (s *labelSanitizer) rewrite(value string) string {
+	sanitized := value
+	if s.disallowed != nil && s.keepUTF8 {
+		sanitized = s.disallowed.ReplaceAllStringFunc(sanitized, replaceASCII)
+	} else if s.disallowed != nil {
+		sanitized = s.disallowed.ReplaceAllString(sanitized, "_")
+	}
+	if s.maxLength > 0 && utf8.RuneCountInString(sanitized) > s.maxLength {
+		h := fnv.New32a()
+		h.Write([]byte(value))
+		runes := []rune(sanitized)
+		sanitized = fmt.Sprintf("%s-%08x", string(runes[:s.maxLength-labelHashSuffixLength]), h.Sum32())
+	}
+	return sanitized
+}
+
+// replaceASCII replaces each run of ASCII runes in a disallowed match with
+// _, keeping the non-ASCII ones.
+func replaceASCII(match string) string {
+	var b strings.Builder
+	ascii := false
+	for _, r := range match {
+		if r >= utf8.RuneSelf {
+			b.WriteRune(r)
+			ascii = false
+		} else if !ascii {
+			b.WriteByte('_')
+			ascii = true
+		}
+	}
+	return b.String()
+}
+
+// wrap returns a gatherer exposing g's metrics with sanitized label values,
+// or g itself without a sanitizer.
+func (s *labelSanitizer) wrap(g prometheus.Gatherer) prometheus.Gatherer {
+	if s == nil {
+		return g
+	}
+	return sanitizingGatherer{Gatherer: g, sanitizer: s}
+}
+
+// wrapUTF8 is wrap for scrapers that negotiated UTF-8, or nil when their
+// label values are sanitized like everyone else's.
+func (s *labelSanitizer) wrapUTF8(g prometheus.Gatherer) prometheus.Gatherer {
+	if s == nil || s.utf8 == nil {
+		return nil
+	}
+	return s.utf8.wrap(g)
+}
+
+type sanitizingGatherer struct {
+	prometheus.Gatherer
+	sanitizer *labelSanitizer
+}
+
+func (g sanitizingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	s := g.sanitizer
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pass := newSanitizePass()
+	defer func() {
+		s.previous = pass.sanitized
+		s.collisions = pass.collisions
+	}()
+
+	for _, family := range families {
+		seen := make(map[string]bool, len(family.Metric))
+		metrics := family.Metric[:0]
+		for _, metric := range family.Metric {
+			// Label pairs are shared with the registry's metrics, so they
+			// are replaced rather than modified
+			pairs := make([]*dto.LabelPair, len(metric.Label))
+			names := make([]string, len(metric.Label))
+			for i, pair := range metric.Label {
+				value := s.sanitize(pass, pair.GetValue())
+				pairs[i] = &dto.LabelPair{Name: pair.Name, Value: &value}
+				names[i] = pair.GetName() + "=" + value
+			}
+			sort.Strings(names)
+			// Colliding values can turn two series into one; only the
+			// first is kept, the collision was counted
+			key := strings.Join(names, "\xff")
+			if seen[key] {
+				debugf("Dropping duplicate series %s{%s} after label sanitization", family.GetName(), key)
+				continue
+			}
+			seen[key] = true
+			metric.Label = pairs
+			metrics = append(metrics, metric)
+		}
+		family.Metric = metrics
+	}
+	return families, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelSanitizerRewrite(t *testing.T) {
+	long := strings.Repeat("a", 40)
+
+	tests := []struct {
+		name     string
+		opts     options
+		keepUTF8 bool
+		value    string
+		want     string
+	}{
+		{
+			name:  "disallowed runes",
+			opts:  options{labelSanitizeRegex: `[^a-zA-Z0-9_-]`},
+			value: "team/a.b",
+			want:  "team_a_b",
+		},
+		{
+			name:  "disallowed non-ASCII runes",
+			opts:  options{labelSanitizeRegex: `[^a-zA-Z0-9_-]`},
+			value: "café",
+			want:  "caf_",
+		},
+		{
+			name:     "UTF-8 keeps non-ASCII runes",
+			opts:     options{labelSanitizeRegex: `[^a-zA-Z0-9_-]`},
+			keepUTF8: true,
+			value:    "café/ñ.x",
+			want:     "café_ñ_x",
+		},
+		{
+			name:     "UTF-8 replaces a run of ASCII runes once",
+			opts:     options{labelSanitizeRegex: `[^a-z]+`},
+			keepUTF8: true,
+			value:    "a./é.:b",
+			want:     "a_é_b",
+		},
+		{
+			name:  "short value is kept",
+			opts:  options{labelValueMaxLength: 40},
+			value: long,
+			want:  long,
+		},
+		{
+			name:  "long value is truncated with a hash suffix",
+			opts:  options{labelValueMaxLength: 20},
+			value: long + "x",
+			want:  strings.Repeat("a", 20-labelHashSuffixLength) + "-f561476f", // FNV-1a of the original value,
+		},
+		{
+			name:  "length is counted in runes",
+			opts:  options{labelValueMaxLength: 20},
+			value: strings.Repeat("é", 20),
+			want:  strings.Repeat("é", 20),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newLabelSanitizer(&tt.opts)
+			s.keepUTF8 = tt.keepUTF8
+			got := s.rewrite(tt.value)
+			if got != tt.want {
+				t.Errorf("rewrite(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+			if tt.opts.labelValueMaxLength > 0 && utf8.RuneCountInString(got) > tt.opts.labelValueMaxLength {
+				t.Errorf("rewrite(%q) = %q, longer than %d runes", tt.value, got, tt.opts.labelValueMaxLength)
+			}
+		})
+	}
+}
+
+func TestLabelSanitizerTruncationKeepsValuesUnique(t *testing.T) {
+	s := newLabelSanitizer(&options{labelValueMaxLength: 20})
+	prefix := strings.Repeat("a", 30)
+	a, b := s.rewrite(prefix+"-one"), s.rewrite(prefix+"-two")
+	if a == b {
+		t.Errorf("values with the same prefix both truncated to %q", a)
+	}
+	if a != s.rewrite(prefix+"-one") {
+		t.Errorf("truncation isn't deterministic")
+	}
+}
+
+func TestSanitizingGathererCollisions(t *testing.T) {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_sanitized", Help: "Test gauge"}, []string{"deployment"})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(gauge)
+	s := newLabelSanitizer(&options{labelSanitizeRegex: `[^a-z_]`})
+	g := s.wrap(reg)
+
+	gather := func() (series int) {
+		t.Helper()
+		families, err := g.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, family := range families {
+			series += len(family.Metric)
+		}
+		return series
+	}
+	collisions := func() float64 { return testutil.ToFloat64(exporterLabelValueCollisions) }
+
+	gauge.WithLabelValues("team/a").Set(1)
+	gauge.WithLabelValues("team_a").Set(2)
+	start := collisions()
+	if series := gather(); series != 1 {
+		t.Errorf("gathered %d series, want the colliding ones merged into 1", series)
+	}
+	if got := collisions() - start; got != 1 {
+		t.Errorf("first gather counted %v collisions, want 1", got)
+	}
+
+	// A collision still exposed isn't counted again
+	gather()
+	if got := collisions() - start; got != 1 {
+		t.Errorf("second gather counted %v collisions in total, want 1", got)
+	}
+
+	// Once one of the values is gone, the sanitizer forgets both the value
+	// and the collision
+	gauge.DeleteLabelValues("team/a")
+	if series := gather(); series != 1 {
+		t.Errorf("gathered %d series, want 1", series)
+	}
+	if _, ok := s.previous["team/a"]; ok {
+		t.Errorf("value no longer exposed is still cached")
+	}
+	if len(s.collisions) != 0 {
+		t.Errorf("collisions %v kept after a value went away", s.collisions)
+	}
+
+	// and counts it again if it comes back
+	gauge.WithLabelValues("team:a").Set(3)
+	gather()
+	if got := collisions() - start; got != 2 {
+		t.Errorf("counted %v collisions in total, want 2 after a new collision", got)
+	}
+}
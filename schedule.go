@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Annotation describing the expected replica counts by time window, e.g.
+// "Mon-Fri 08:00-20:00=6; *=1"
+const replicaScheduleAnnotation = "deployment-exporter/replica-schedule"
+
+var (
+	// Replica count the schedule expects right now
+	deploymentScheduledReplicas = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_scheduled_replicas",
+			Help: "Number of replicas the deployment's replica schedule expects at the current time",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentOffSchedule = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_off_schedule",
+			Help: "Whether the deployment's desired or ready replicas deviate from its replica schedule (1 = off schedule)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// scheduleWindow is one "days from-to=replicas" entry of a replica schedule.
+// A window whose end is before its start wraps past midnight: its days are
+// the days it starts on, so "Fri 22:00-06:00" runs into Saturday morning.
+type scheduleWindow struct {
+	days     [7]bool
+	allDay   bool
+	from, to int // minutes since midnight
+	replicas int
+}
+
+func (w scheduleWindow) matches(now time.Time) bool {
+	if w.allDay {
+		return w.days[now.Weekday()]
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if w.from <= w.to {
+		return w.days[now.Weekday()] && minute >= w.from && minute < w.to
+	}
+	if minute >= w.from {
+		return w.days[now.Weekday()]
+	}
+	// After midnight, in the part of the window started the day before
+	return minute < w.to && w.days[(now.Weekday()+6)%7]
+}
+
+// replicaSchedule is a list of windows, the first matching one wins.
+type replicaSchedule []scheduleWindow
+
+// expected returns the replica count the schedule expects at now, or false
+// if no window matches.
+func (s replicaSchedule) expected(now time.Time) (int, bool) {
+	for _, w := range s {
+		if w.matches(now) {
+			return w.replicas, true
+		}
+	}
+	return 0, false
+}
+
+// parseReplicaSchedule parses ";"-separated windows of the form
+// "[days] [HH:MM-HH:MM]=replicas", where days are names or ranges like
+// "Mon-Fri,Sun" and "*" matches any time, e.g. "Mon-Fri 08:00-20:00=6; *=1".
+func parseReplicaSchedule(value string) (replicaSchedule, error) {
+	var schedule replicaSchedule
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("window %q: missing =replicas", entry)
+		}
+		replicas, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+		if err != nil || replicas < 0 {
+			return nil, fmt.Errorf("window %q: replicas must be a non-negative integer", entry)
+		}
+
+		w := scheduleWindow{allDay: true, replicas: replicas}
+		daysSet := false
+		for _, field := range strings.Fields(entry[:i]) {
+			switch {
+			case field == "*":
+			case strings.Contains(field, ":"):
+				if w.from, w.to, err = parseTimeRange(field); err != nil {
+					return nil, fmt.Errorf("window %q: %w", entry, err)
+				}
+				w.allDay = false
+			default:
+				if w.days, err = parseDays(field); err != nil {
+					return nil, fmt.Errorf("window %q: %w", entry, err)
+				}
+				daysSet = true
+			}
+		}
+		if !daysSet {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		schedule = append(schedule, w)
+	}
+	if len(schedule) == 0 {
+		return nil, fmt.Errorf("no windows in schedule %q", value)
+	}
+	return schedule, nil
+}
+
+// parseDays parses "Mon-Fri,Sun"; ranges may wrap, e.g. "Fri-Mon".
+func parseDays(value string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(value, ",") {
+		from, to, isRange := strings.Cut(strings.ToLower(part), "-")
+		if !isRange {
+			to = from
+		}
+		start, ok := weekdays[from]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q", from)
+		}
+		end, ok := weekdays[to]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q", to)
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseTimeRange parses "HH:MM-HH:MM" into minutes since midnight.
+func parseTimeRange(value string) (int, int, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("time range %q must be HH:MM-HH:MM", value)
+	}
+	start, err := time.Parse("15:04", from)
+	if err != nil {
+		return 0, 0, fmt.Errorf("time range %q must be HH:MM-HH:MM", value)
+	}
+	end, err := time.Parse("15:04", to)
+	if err != nil {
+		return 0, 0, fmt.Errorf("time range %q must be HH:MM-HH:MM", value)
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+func validateReplicaSchedule(value string) error {
+	_, err := parseReplicaSchedule(value)
+	return err
+}
+
+// replicaSchedules holds the schedules from --replica-schedule-file, a YAML
+// map of "namespace/deployment" to schedule, and the time zone all schedules
+// are evaluated in.
+type replicaSchedules struct {
+	path     string
+	location *time.Location
+
+	mu       sync.RWMutex
+	declared map[string]replicaSchedule
+}
+
+func newReplicaSchedules(path string, location *time.Location) *replicaSchedules {
+	return &replicaSchedules{path: path, location: location}
+}
+
+// reload re-reads the schedule file. On error the previously loaded
+// schedules are kept.
+func (s *replicaSchedules) reload() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+	declared := make(map[string]replicaSchedule, len(raw))
+	for key, value := range raw {
+		schedule, err := parseReplicaSchedule(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", s.path, key, err)
+		}
+		declared[key] = schedule
+	}
+
+	s.mu.Lock()
+	s.declared = declared
+	s.mu.Unlock()
+	debugf("Loaded %d replica schedules from %s", len(declared), s.path)
+	return nil
+}
+
+// schedule returns the deployment's replica schedule; the annotation wins
+// over the schedule file.
+func (s *replicaSchedules) schedule(deployment *appsv1.Deployment) (replicaSchedule, bool) {
+	if value, ok := deployment.Annotations[replicaScheduleAnnotation]; ok {
+		schedule, err := parseReplicaSchedule(value)
+		if err != nil {
+			log.Printf("Invalid %s annotation on deployment %s/%s: %v",
+				replicaScheduleAnnotation, deployment.Namespace, deployment.Name, err)
+			return nil, false
+		}
+		return schedule, true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedule, ok := s.declared[deployment.Namespace+"/"+deployment.Name]
+	return schedule, ok
+}
+
+// collectScheduleMetrics compares the deployment with its replica schedule
+// and returns the replica count expected now, if it has a schedule.
+func (t *DeploymentTracker) collectScheduleMetrics(deployment *appsv1.Deployment, now time.Time) (int, bool) {
+	ns := deployment.Namespace
+	name := deployment.Name
+	schedule, ok := t.schedules.schedule(deployment)
+	if !ok {
+		deploymentScheduledReplicas.DeleteLabelValues(ns, name)
+		deploymentOffSchedule.DeleteLabelValues(ns, name)
+		return 0, false
+	}
+	expected, ok := schedule.expected(now.In(t.schedules.location))
+	if !ok {
+		deploymentScheduledReplicas.DeleteLabelValues(ns, name)
+		deploymentOffSchedule.DeleteLabelValues(ns, name)
+		return 0, false
+	}
+
+	desired := 0
+	if deployment.Spec.Replicas != nil {
+		desired = int(*deployment.Spec.Replicas)
+	}
+	off := float64(0)
+	if desired != expected || int(deployment.Status.ReadyReplicas) < expected {
+		off = 1
+	}
+	deploymentScheduledReplicas.WithLabelValues(ns, name).Set(float64(expected))
+	deploymentOffSchedule.WithLabelValues(ns, name).Set(off)
+	return expected, true
+}
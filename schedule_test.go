@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		value   string
+		days    []time.Weekday
+		wantErr bool
+	}{
+		{value: "Mon", days: []time.Weekday{time.Monday}},
+		{value: "mon-fri", days: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}},
+		{value: "Fri-Mon", days: []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday}},
+		{value: "Sat-Sun,Wed", days: []time.Weekday{time.Saturday, time.Sunday, time.Wednesday}},
+		{value: "Sun-Sat", days: []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}},
+		{value: "Tue-Tue", days: []time.Weekday{time.Tuesday}},
+		{value: "Monday", wantErr: true},
+		{value: "Mon-Funday", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			days, err := parseDays(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDays(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var want [7]bool
+			for _, d := range tt.days {
+				want[d] = true
+			}
+			if days != want {
+				t.Errorf("parseDays(%q) = %v, want %v", tt.value, days, want)
+			}
+		})
+	}
+}
+
+func TestParseReplicaSchedule(t *testing.T) {
+	everyDay := [7]bool{true, true, true, true, true, true, true}
+	weekdays := [7]bool{false, true, true, true, true, true, false}
+
+	tests := []struct {
+		value   string
+		want    replicaSchedule
+		wantErr bool
+	}{
+		{
+			value: "Mon-Fri 08:00-20:00=6; *=1",
+			want: replicaSchedule{
+				{days: weekdays, from: 8 * 60, to: 20 * 60, replicas: 6},
+				{days: everyDay, allDay: true, replicas: 1},
+			},
+		},
+		{
+			value: "22:00-06:00=4;",
+			want:  replicaSchedule{{days: everyDay, from: 22 * 60, to: 6 * 60, replicas: 4}},
+		},
+		{
+			value: " Sat,Sun = 0 ",
+			want:  replicaSchedule{{days: [7]bool{true, false, false, false, false, false, true}, allDay: true, replicas: 0}},
+		},
+		{value: "", wantErr: true},
+		{value: "Mon-Fri 08:00-20:00", wantErr: true},
+		{value: "*=-1", wantErr: true},
+		{value: "*=two", wantErr: true},
+		{value: "Mon 8-20=2", wantErr: true},
+		{value: "Mon 08:00=2", wantErr: true},
+		{value: "Mon 25:00-26:00=2", wantErr: true},
+		{value: "Someday=2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			schedule, err := parseReplicaSchedule(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReplicaSchedule(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(schedule) != len(tt.want) {
+				t.Fatalf("parseReplicaSchedule(%q) = %+v, want %+v", tt.value, schedule, tt.want)
+			}
+			for i := range schedule {
+				if schedule[i] != tt.want[i] {
+					t.Errorf("window %d = %+v, want %+v", i, schedule[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleWindowMatches(t *testing.T) {
+	// 2024-01-05 is a Friday
+	at := func(day int, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	friday := [7]bool{time.Friday: true}
+	fridayToMonday := [7]bool{time.Friday: true, time.Saturday: true, time.Sunday: true, time.Monday: true}
+
+	tests := []struct {
+		name   string
+		window scheduleWindow
+		now    time.Time
+		want   bool
+	}{
+		{"all day on its day", scheduleWindow{days: friday, allDay: true}, at(5, 0, 0), true},
+		{"all day on another day", scheduleWindow{days: friday, allDay: true}, at(6, 12, 0), false},
+		{"within a day window", scheduleWindow{days: friday, from: 8 * 60, to: 20 * 60}, at(5, 8, 0), true},
+		{"end of a day window is excluded", scheduleWindow{days: friday, from: 8 * 60, to: 20 * 60}, at(5, 20, 0), false},
+		{"day window on another day", scheduleWindow{days: friday, from: 8 * 60, to: 20 * 60}, at(6, 12, 0), false},
+		{"wrapping window before midnight", scheduleWindow{days: friday, from: 22 * 60, to: 6 * 60}, at(5, 23, 30), true},
+		{"wrapping window after midnight of its day", scheduleWindow{days: friday, from: 22 * 60, to: 6 * 60}, at(6, 5, 59), true},
+		{"wrapping window ends after midnight", scheduleWindow{days: friday, from: 22 * 60, to: 6 * 60}, at(6, 6, 0), false},
+		{"wrapping window not in the morning of its day", scheduleWindow{days: friday, from: 22 * 60, to: 6 * 60}, at(5, 3, 0), false},
+		{"wrapping window between its ends", scheduleWindow{days: friday, from: 22 * 60, to: 6 * 60}, at(5, 12, 0), false},
+		{"wrapping days and window into Tuesday", scheduleWindow{days: fridayToMonday, from: 22 * 60, to: 6 * 60}, at(9, 1, 0), true},
+		{"wrapping days and window not into Wednesday", scheduleWindow{days: fridayToMonday, from: 22 * 60, to: 6 * 60}, at(10, 1, 0), false},
+		{"wrapping window from Saturday into Sunday", scheduleWindow{days: [7]bool{time.Saturday: true}, from: 22 * 60, to: 6 * 60}, at(7, 1, 0), true},
+		{"wrapping window from Saturday not into Saturday", scheduleWindow{days: [7]bool{time.Saturday: true}, from: 22 * 60, to: 6 * 60}, at(6, 1, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.matches(tt.now); got != tt.want {
+				t.Errorf("matches(%s) = %v, want %v", tt.now.Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
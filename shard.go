@@ -0,0 +1,68 @@
+package main
+
+import (
+	"hash/fnv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values of --shard-by. Sharding by deployment balances the shards, but
+// every replica still lists and watches all watched namespaces; sharding by
+// namespace has each replica only list and watch the namespaces of its shard
+const (
+	shardByDeployment = "deployment"
+	shardByNamespace  = "namespace"
+)
+
+var (
+	// Deployments tracked by this exporter replica
+	exporterShardDeployments = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "exporter_shard_deployments",
+			Help: "Number of deployments tracked by this exporter shard in the last periodic scrape",
+		},
+	)
+)
+
+// shardOf deterministically maps a deployment to one of totalShards shards.
+func shardOf(namespace, name string, totalShards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(namespace + "/" + name))
+	return int(h.Sum32() % uint32(totalShards))
+}
+
+// shardOfNamespace deterministically maps a namespace to one of totalShards
+// shards.
+func shardOfNamespace(namespace string, totalShards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	return int(h.Sum32() % uint32(totalShards))
+}
+
+// trackedNamespaces returns the namespaces this exporter replica watches
+// ("" for all): the --namespace ones, with --shard-by=namespace only those
+// of its shard.
+func (o *options) trackedNamespaces() []string {
+	namespaces := watchedNamespaces(o.namespace)
+	if o.shardBy != shardByNamespace || o.totalShards <= 1 {
+		return namespaces
+	}
+	var owned []string
+	for _, namespace := range namespaces {
+		if shardOfNamespace(namespace, o.totalShards) == o.shard {
+			owned = append(owned, namespace)
+		}
+	}
+	return owned
+}
+
+// ownsDeployment reports whether this exporter replica tracks the deployment.
+func (t *DeploymentTracker) ownsDeployment(namespace, name string) bool {
+	if t.totalShards <= 1 {
+		return true
+	}
+	if t.shardByNamespace {
+		return shardOfNamespace(namespace, t.totalShards) == t.shard
+	}
+	return shardOf(namespace, name, t.totalShards) == t.shard
+}
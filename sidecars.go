@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Container classes used to split resource metrics
+const (
+	containerClassApp     = "app"
+	containerClassSidecar = "sidecar"
+)
+
+var containerClasses = []string{containerClassApp, containerClassSidecar}
+
+// Default names of injected sidecar containers (service mesh, secret agents)
+const defaultSidecarContainers = "istio-proxy,linkerd-proxy,vault-agent"
+
+var (
+	// Resource metrics split by container class
+	deploymentClassCPUUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_container_class_cpu_usage_millicores",
+			Help: "CPU usage in millicores for all pods in the deployment, split into app and sidecar containers",
+		},
+		[]string{"namespace", "deployment", "container_class"},
+	)
+
+	deploymentClassMemoryUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_container_class_memory_usage_mebibytes",
+			Help: "Memory usage in MiB for all pods in the deployment, split into app and sidecar containers",
+		},
+		[]string{"namespace", "deployment", "container_class"},
+	)
+
+	deploymentClassCPURequest = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_container_class_cpu_request_millicores",
+			Help: "CPU requests in millicores for all pods in the deployment, split into app and sidecar containers",
+		},
+		[]string{"namespace", "deployment", "container_class"},
+	)
+
+	deploymentClassMemoryRequest = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_container_class_memory_request_mebibytes",
+			Help: "Memory requests in MiB for all pods in the deployment, split into app and sidecar containers",
+		},
+		[]string{"namespace", "deployment", "container_class"},
+	)
+)
+
+// parseSidecarContainers turns a comma-separated list of container names
+// into a lookup set.
+func parseSidecarContainers(list string) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range splitList(list) {
+		names[name] = true
+	}
+	return names
+}
+
+// containerClass reports whether a container is an injected sidecar or part
+// of the application.
+func (t *DeploymentTracker) containerClass(containerName string) string {
+	if t.sidecarContainers[containerName] {
+		return containerClassSidecar
+	}
+	return containerClassApp
+}
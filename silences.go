@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Deployments whose incidents and notifications are suspended
+	deploymentSilenced = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_silenced",
+			Help: "Whether an active silence suspends incidents and notifications for the deployment (1 = silenced)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// silence suspends incident creation and notifications for a deployment, or
+// for a whole namespace when Deployment is empty, until it expires.
+type silence struct {
+	ID         uint64    `json:"id"`
+	Namespace  string    `json:"namespace"`
+	Deployment string    `json:"deployment,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (s *silence) matches(namespace, deployment string) bool {
+	return s.Namespace == namespace && (s.Deployment == "" || s.Deployment == deployment)
+}
+
+// silenceStore keeps the silences created through the silences API.
+type silenceStore struct {
+	mu       sync.Mutex
+	nextID   uint64
+	silences map[uint64]*silence
+}
+
+func newSilenceStore() *silenceStore {
+	return &silenceStore{silences: make(map[uint64]*silence)}
+}
+
+func (s *silenceStore) add(sil silence) silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	sil.ID = s.nextID
+	s.silences[sil.ID] = &sil
+	return sil
+}
+
+// restore adds the silences of a state snapshot, keeping their IDs.
+func (s *silenceStore) restore(silences []silence) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range silences {
+		sil := silences[i]
+		s.silences[sil.ID] = &sil
+		if sil.ID > s.nextID {
+			s.nextID = sil.ID
+		}
+	}
+}
+
+func (s *silenceStore) remove(id uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.silences[id]; !ok {
+		return false
+	}
+	delete(s.silences, id)
+	return true
+}
+
+// pruneExpired drops expired silences. The caller holds s.mu.
+func (s *silenceStore) pruneExpired(now time.Time) {
+	for id, sil := range s.silences {
+		if !now.Before(sil.ExpiresAt) {
+			delete(s.silences, id)
+		}
+	}
+}
+
+// active returns the active silences, oldest first.
+func (s *silenceStore) active(now time.Time) []silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneExpired(now)
+
+	result := make([]silence, 0, len(s.silences))
+	for _, sil := range s.silences {
+		result = append(result, *sil)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// silenced reports whether an active silence covers the deployment.
+func (s *silenceStore) silenced(namespace, deployment string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneExpired(now)
+
+	for _, sil := range s.silences {
+		if sil.matches(namespace, deployment) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSilences serves GET (list active silences) and POST (create a
+// silence from {namespace, deployment, duration, reason}, duration like
+// "2h") on /api/v1/silences.
+func (s *silenceStore) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.active(time.Now()))
+	case http.MethodPost:
+		var req struct {
+			Namespace  string `json:"namespace"`
+			Deployment string `json:"deployment"`
+			Duration   string `json:"duration"`
+			Reason     string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid silence: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Namespace == "" {
+			http.Error(w, "invalid silence: namespace is required", http.StatusBadRequest)
+			return
+		}
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, fmt.Sprintf("invalid silence: duration must be positive, e.g. 2h, got %q", req.Duration), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		sil := s.add(silence{
+			Namespace:  req.Namespace,
+			Deployment: req.Deployment,
+			Reason:     req.Reason,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(duration),
+		})
+		log.Printf("Silence %d created for %s/%s until %s: %s", sil.ID, sil.Namespace, sil.Deployment,
+			sil.ExpiresAt.Format(time.RFC3339), sil.Reason)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sil)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSilence serves DELETE /api/v1/silences/{id}.
+func (s *silenceStore) handleSilence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api/v1/silences/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid silence id", http.StatusBadRequest)
+		return
+	}
+	if !s.remove(id) {
+		http.Error(w, "silence not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Silence %d deleted", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// silenced reports whether a silence created through the API or, with
+// --alertmanager-url, an Alertmanager silence covers the deployment.
+func (t *DeploymentTracker) silenced(namespace, deploymentName string, now time.Time) bool {
+	if t.silences.silenced(namespace, deploymentName, now) {
+		return true
+	}
+	return t.alertmanager != nil && t.alertmanager.silenced(namespace, deploymentName)
+}
+
+// collectSilenceMetrics reports whether the deployment is silenced.
+func (t *DeploymentTracker) collectSilenceMetrics(namespace, deploymentName string, now time.Time) {
+	silenced := float64(0)
+	if t.silences.silenced(namespace, deploymentName, now) {
+		silenced = 1
+	}
+	deploymentSilenced.WithLabelValues(namespace, deploymentName).Set(silenced)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// deploymentState is the exporter's view of one tracked deployment.
+type deploymentState struct {
+	Namespace  string     `json:"namespace"`
+	Deployment string     `json:"deployment"`
+	Ready      bool       `json:"ready"`
+	DownSince  *time.Time `json:"downSince,omitempty"`
+	// Down since as back-dated to the Available condition transition
+	CorrectedDownSince *time.Time `json:"correctedDownSince,omitempty"`
+	// Last value of every series of the deployment, keyed by metric name and
+	// the labels besides namespace and deployment
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// trackerState is the complete internal state served on /api/v1/state.
+type trackerState struct {
+	Time          time.Time         `json:"time"`
+	Ready         bool              `json:"ready"`
+	Deployments   []deploymentState `json:"deployments"`
+	OpenIncidents []incident        `json:"openIncidents"`
+	Silences      []silence         `json:"silences"`
+}
+
+// state collects the tracker state while no deployment update is in
+// progress.
+func (t *DeploymentTracker) state() (*trackerState, error) {
+	scrapeLock.Lock()
+	defer scrapeLock.Unlock()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := make(map[string]*deploymentState)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var namespace, name string
+			var others []*dto.LabelPair
+			for _, label := range metric.GetLabel() {
+				switch label.GetName() {
+				case "namespace":
+					namespace = label.GetValue()
+				case "deployment":
+					name = label.GetValue()
+				default:
+					others = append(others, label)
+				}
+			}
+			if namespace == "" || name == "" {
+				continue
+			}
+			key := namespace + "/" + name
+			d, ok := deployments[key]
+			if !ok {
+				d = &deploymentState{Namespace: namespace, Deployment: name, Metrics: make(map[string]float64)}
+				deployments[key] = d
+			}
+			d.Metrics[seriesName(family.GetName(), others)] = sampleValue(family.GetType(), metric)
+		}
+	}
+
+	result := &trackerState{
+		Time:          time.Now(),
+		Ready:         t.ready.Load(),
+		Deployments:   make([]deploymentState, 0, len(deployments)),
+		OpenIncidents: t.incidents.list("", "open"),
+		Silences:      t.silences.active(time.Now()),
+	}
+	for key, d := range deployments {
+		d.Ready = d.Metrics["k8s_deployment_status"] == 1
+		if start, ok := t.downtimeStart.get(key); ok {
+			d.DownSince = &start
+		}
+		if start, ok := t.correctedStart.get(key); ok {
+			d.CorrectedDownSince = &start
+		}
+		result.Deployments = append(result.Deployments, *d)
+	}
+	sort.Slice(result.Deployments, func(i, j int) bool {
+		a, b := result.Deployments[i], result.Deployments[j]
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Deployment < b.Deployment
+	})
+	return result, nil
+}
+
+// handleState serves GET /api/v1/state.
+func (t *DeploymentTracker) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state, err := t.state()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// Version of the state snapshot format written by /api/v1/state/export
+const stateSnapshotVersion = 1
+
+// Counters carried over by state snapshots. Histograms can't be restored and
+// start empty.
+var snapshotCounters = map[string]*prometheus.CounterVec{
+	"k8s_deployment_restart_total":             deploymentRestartCount,
+	"k8s_deployment_pod_readiness_flaps_total": deploymentPodReadinessFlaps,
+	"k8s_deployment_auto_rollbacks_total":      deploymentAutoRollbacks,
+	"k8s_deployment_unmonitored_seconds_total": deploymentUnmonitoredSeconds,
+}
+
+// stateSnapshot is what a new exporter instance needs to continue where an
+// old one stopped: open downtimes, incidents, silences and counters.
+type stateSnapshot struct {
+	Version        int                        `json:"version"`
+	Time           time.Time                  `json:"time"`
+	DowntimeStart  map[string]time.Time       `json:"downtimeStart"`
+	CorrectedStart map[string]time.Time       `json:"correctedStart"`
+	LastRecovery   map[string]time.Time       `json:"lastRecovery"`
+	Incidents      []incident                 `json:"incidents"`
+	NextIncidentID uint64                     `json:"nextIncidentId"`
+	Silences       []silence                  `json:"silences"`
+	Counters       map[string][]counterSample `json:"counters"`
+	BlindSpots     []blindSpot                `json:"blindSpots,omitempty"`
+}
+
+type counterSample struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// snapshot captures the state while no deployment update is in progress.
+func (t *DeploymentTracker) snapshot() (*stateSnapshot, error) {
+	scrapeLock.Lock()
+	defer scrapeLock.Unlock()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string][]counterSample)
+	for _, family := range families {
+		if _, ok := snapshotCounters[family.GetName()]; !ok {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string, len(metric.GetLabel()))
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			counters[family.GetName()] = append(counters[family.GetName()],
+				counterSample{Labels: labels, Value: metric.GetCounter().GetValue()})
+		}
+	}
+
+	incidents, nextID := t.incidents.all()
+	return &stateSnapshot{
+		Version:        stateSnapshotVersion,
+		Time:           time.Now(),
+		DowntimeStart:  t.downtimeStart.all(),
+		CorrectedStart: t.correctedStart.all(),
+		LastRecovery:   t.lastRecovery.all(),
+		Incidents:      incidents,
+		NextIncidentID: nextID,
+		Silences:       t.silences.active(time.Now()),
+		Counters:       counters,
+		BlindSpots:     t.blindSpots.list(),
+	}, nil
+}
+
+// importState restores a snapshot written by /api/v1/state/export. It must
+// run before the tracker starts processing deployments.
+func (t *DeploymentTracker) importState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if snapshot.Version < 1 || snapshot.Version > stateSnapshotVersion {
+		return fmt.Errorf("%s: unsupported state snapshot version %d", path, snapshot.Version)
+	}
+
+	for key, start := range snapshot.DowntimeStart {
+		t.downtimeStart.set(key, start)
+	}
+	for key, start := range snapshot.CorrectedStart {
+		t.correctedStart.set(key, start)
+	}
+	for key, recovered := range snapshot.LastRecovery {
+		t.lastRecovery.set(key, recovered)
+	}
+	t.incidents.restore(snapshot.Incidents, snapshot.NextIncidentID)
+	t.silences.restore(snapshot.Silences)
+	// The time until the first cycle of this instance is a blind spot
+	if t.blindSpots != nil {
+		t.blindSpots.seed(snapshot.Time, snapshot.BlindSpots)
+	}
+
+	for name, samples := range snapshot.Counters {
+		vec, ok := snapshotCounters[name]
+		if !ok {
+			continue
+		}
+		for _, sample := range samples {
+			// Label sets of another version may not fit anymore
+			counter, err := vec.GetMetricWith(sample.Labels)
+			if err != nil {
+				log.Printf("Warning: Could not restore %s%v: %v", name, sample.Labels, err)
+				continue
+			}
+			counter.Add(sample.Value)
+		}
+	}
+	log.Printf("Imported state from %s (taken %s): %d open downtimes, %d incidents, %d silences",
+		path, snapshot.Time.Format(time.RFC3339), len(snapshot.DowntimeStart), len(snapshot.Incidents), len(snapshot.Silences))
+	return nil
+}
+
+// handleStateExport serves GET /api/v1/state/export, a snapshot for
+// --import-state.
+func (t *DeploymentTracker) handleStateExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snapshot, err := t.snapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="exporter-state.json"`)
+	json.NewEncoder(w).Encode(snapshot)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Annotations setting the usage (in percent of requests) above which the
+// deployment is flagged, for teams that want ready-made alert booleans
+const (
+	memoryAlertThresholdAnnotation = "deployment-exporter/memory-alert-threshold"
+	cpuAlertThresholdAnnotation    = "deployment-exporter/cpu-alert-threshold"
+)
+
+var (
+	// Usage above the deployment's own alert thresholds
+	deploymentMemoryOverThreshold = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_memory_over_threshold",
+			Help: "Whether the deployment's memory usage in percent of requests is above its deployment-exporter/memory-alert-threshold annotation (1 = above)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	deploymentCPUOverThreshold = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_cpu_over_threshold",
+			Help: "Whether the deployment's CPU usage in percent of requests is above its deployment-exporter/cpu-alert-threshold annotation (1 = above)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+func validateAlertThreshold(value string) error {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("must be a positive percentage, got %q", value)
+	}
+	return nil
+}
+
+// collectThresholdMetric compares a usage percentage against the threshold
+// annotation. The series is removed when the annotation is missing or
+// invalid, or the percentage is unknown (no requests set).
+func collectThresholdMetric(deployment *appsv1.Deployment, vec *prometheus.GaugeVec, annotation string, percent float64, known bool) {
+	ns := deployment.Namespace
+	name := deployment.Name
+	value, ok := deployment.Annotations[annotation]
+	if !ok || !known {
+		vec.DeleteLabelValues(ns, name)
+		return
+	}
+	if err := validateAlertThreshold(value); err != nil {
+		log.Printf("Invalid %s annotation on deployment %s/%s: %v", annotation, ns, name, err)
+		vec.DeleteLabelValues(ns, name)
+		return
+	}
+	threshold, _ := strconv.ParseFloat(value, 64)
+
+	over := float64(0)
+	if percent > threshold {
+		over = 1
+	}
+	vec.WithLabelValues(ns, name).Set(over)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// Share of CFS periods in which the deployment's containers were
+	// throttled, from kubelet cAdvisor counters
+	deploymentCPUThrottledRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_cpu_throttled_ratio",
+			Help: "Ratio of CPU CFS periods in which the deployment's containers were throttled since the previous scrape (from kubelet cAdvisor)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// cfsCounters are the cAdvisor CPU CFS period counters of one or more
+// containers.
+type cfsCounters struct {
+	periods   float64
+	throttled float64
+}
+
+// Deadline of a kubelet proxy call (cAdvisor metrics, stats summary); a
+// node's cAdvisor output can be several MB
+const kubeletProxyTimeout = 30 * time.Second
+
+// cadvisorCache keeps CFS counters per node so a node's cAdvisor metrics are
+// fetched at most once per TTL regardless of how many deployments run there.
+// It also remembers each deployment's previous totals to compute ratios.
+type cadvisorCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	fetched  map[string]time.Time
+	fetching map[string]chan struct{}          // node -> closed once its fetch finished
+	counters map[string]map[string]cfsCounters // node -> namespace/pod -> counters
+	previous map[string]cfsCounters            // namespace/deployment -> counters
+}
+
+func newCadvisorCache(ttl time.Duration) *cadvisorCache {
+	return &cadvisorCache{
+		ttl:      ttl,
+		fetched:  make(map[string]time.Time),
+		fetching: make(map[string]chan struct{}),
+		counters: make(map[string]map[string]cfsCounters),
+		previous: make(map[string]cfsCounters),
+	}
+}
+
+// forget drops the previous CFS counters of a deleted deployment.
+func (c *cadvisorCache) forget(ns, name string) {
+	if c == nil {
+		return
+	}
+	key := ns + "/" + name
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.previous, key)
+}
+
+// nodeCFSCounters returns the CFS counters per pod reported by the kubelet's
+// cAdvisor endpoint on a node. The fetch runs without c.mu held, so a slow
+// node only holds up the deployments with pods on it; they wait for the one
+// fetch in flight rather than each fetching the node. A failed fetch keeps
+// the previous counters until the TTL passed.
+func (t *DeploymentTracker) nodeCFSCounters(nodeName string) map[string]cfsCounters {
+	c := t.cadvisor
+	c.mu.Lock()
+	if done, ok := c.fetching[nodeName]; ok {
+		c.mu.Unlock()
+		<-done
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.counters[nodeName]
+	}
+	if fetched, ok := c.fetched[nodeName]; ok && time.Since(fetched) < c.ttl {
+		defer c.mu.Unlock()
+		return c.counters[nodeName]
+	}
+	done := make(chan struct{})
+	c.fetching[nodeName] = done
+	c.fetched[nodeName] = time.Now()
+	c.mu.Unlock()
+
+	counters, err := t.fetchCFSCounters(nodeName)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fetching, nodeName)
+	close(done)
+	if err != nil {
+		log.Printf("Error fetching cAdvisor metrics from node %s: %v", nodeName, err)
+		return c.counters[nodeName]
+	}
+	c.counters[nodeName] = counters
+	return counters
+}
+
+// fetchCFSCounters fetches and parses a node's cAdvisor metrics.
+func (t *DeploymentTracker) fetchCFSCounters(nodeName string) (map[string]cfsCounters, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kubeletProxyTimeout)
+	defer cancel()
+	raw, err := t.clientset.CoreV1().RESTClient().Get().
+		Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("metrics/cadvisor").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	counters := make(map[string]cfsCounters)
+	for name, family := range families {
+		if name != "container_cpu_cfs_periods_total" && name != "container_cpu_cfs_throttled_periods_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			// Skip the pod-level cgroup and the pause container, which
+			// would double count
+			if labels["container"] == "" || labels["container"] == "POD" {
+				continue
+			}
+			key := labels["namespace"] + "/" + labels["pod"]
+			pod := counters[key]
+			if name == "container_cpu_cfs_periods_total" {
+				pod.periods += m.GetCounter().GetValue()
+			} else {
+				pod.throttled += m.GetCounter().GetValue()
+			}
+			counters[key] = pod
+		}
+	}
+	return counters, nil
+}
+
+// deploymentCFSCounters sums the CFS counters of the deployment's pods.
+// Containers without a CPU limit have no CFS quota and don't count.
+func (t *DeploymentTracker) deploymentCFSCounters(pods []*corev1.Pod) cfsCounters {
+	var total cfsCounters
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+		counters := t.nodeCFSCounters(pod.Spec.NodeName)[pod.Namespace+"/"+pod.Name]
+		total.periods += counters.periods
+		total.throttled += counters.throttled
+	}
+	return total
+}
+
+// collectThrottlingMetrics sets the throttled ratio of the deployment's
+// containers since the previous scrape from the summed counters. Nothing is
+// set when pods were replaced in between and the counters went backwards.
+func (t *DeploymentTracker) collectThrottlingMetrics(namespace, deploymentName string, total cfsCounters) {
+	c := t.cadvisor
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := namespace + "/" + deploymentName
+	previous, seen := c.previous[key]
+	c.previous[key] = total
+	if !seen {
+		return
+	}
+	periods := total.periods - previous.periods
+	throttled := total.throttled - previous.throttled
+	if periods <= 0 || throttled < 0 {
+		return
+	}
+	deploymentCPUThrottledRatio.WithLabelValues(namespace, deploymentName).Set(throttled / periods)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Annotation listing the deployments a deployment depends on
+const dependsOnAnnotation = "deployment-exporter/depends-on"
+
+// topologyNode is a deployment, service or ingress of the topology graph.
+type topologyNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Health of deployments: up or down as tracked by the exporter
+	Health        string `json:"health,omitempty"`
+	ReadyReplicas *int32 `json:"readyReplicas,omitempty"`
+	Replicas      *int32 `json:"replicas,omitempty"`
+}
+
+// topologyEdge is a relationship: a service selecting a deployment's pods,
+// an ingress routing to a service or a declared dependency.
+type topologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"`
+}
+
+// topology is the graph served on /api/v1/topology.
+type topology struct {
+	Nodes []topologyNode `json:"nodes"`
+	Edges []topologyEdge `json:"edges"`
+}
+
+func topologyID(kind, namespace, name string) string {
+	return kind + ":" + namespace + "/" + name
+}
+
+// parseDependsOn parses the depends-on annotation: comma-separated
+// deployment names, qualified with namespace/ outside the deployment's own
+// namespace.
+func parseDependsOn(value, namespace string) ([]string, error) {
+	var keys []string
+	for _, dependency := range splitList(value) {
+		parts := strings.Split(dependency, "/")
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			keys = append(keys, namespace+"/"+parts[0])
+		case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+			keys = append(keys, dependency)
+		default:
+			return nil, fmt.Errorf("invalid dependency %q, must be name or namespace/name", dependency)
+		}
+	}
+	return keys, nil
+}
+
+func validateDependsOn(value string) error {
+	_, err := parseDependsOn(value, "default")
+	return err
+}
+
+// topology builds the graph of the tracked deployments, the services
+// selecting their pods, the ingresses routing to those services and the
+// dependencies declared in annotations. Services and ingresses are only
+// known with --watch-services.
+func (t *DeploymentTracker) topology() (*topology, error) {
+	deployments, err := t.trackedDeployments()
+	if err != nil {
+		return nil, err
+	}
+
+	// Ingresses are optional: without RBAC access they are left out, and
+	// without services they route to nothing known
+	var ingresses []networkingv1.Ingress
+	if t.watchServices {
+		for _, namespace := range t.namespaces {
+			if ingressList, err := t.clientsetFor(namespace).NetworkingV1().Ingresses(namespace).List(context.Background(), metav1.ListOptions{}); err != nil {
+				debugf("Leaving ingresses of %s out of the topology: %v", namespaceName(namespace), err)
+			} else {
+				ingresses = append(ingresses, ingressList.Items...)
+			}
+		}
+	}
+
+	down := t.downDeployments()
+	result := &topology{Nodes: []topologyNode{}, Edges: []topologyEdge{}}
+	services := make(map[string]bool)
+	for _, deployment := range deployments {
+		key := deployment.Namespace + "/" + deployment.Name
+		id := topologyID("deployment", deployment.Namespace, deployment.Name)
+		health := "up"
+		if down[key] {
+			health = "down"
+		}
+		ready := deployment.Status.ReadyReplicas
+		result.Nodes = append(result.Nodes, topologyNode{
+			ID:            id,
+			Kind:          "deployment",
+			Namespace:     deployment.Namespace,
+			Name:          deployment.Name,
+			Health:        health,
+			ReadyReplicas: &ready,
+			Replicas:      deployment.Spec.Replicas,
+		})
+
+		if !t.watchServices {
+			continue
+		}
+		podLabels := labels.Set(deployment.Spec.Template.Labels)
+		for _, obj := range t.cachesFor(deployment.Namespace).services.GetStore().List() {
+			service := obj.(*corev1.Service)
+			if service.Namespace != deployment.Namespace || len(service.Spec.Selector) == 0 {
+				continue
+			}
+			if !labels.SelectorFromSet(service.Spec.Selector).Matches(podLabels) {
+				continue
+			}
+			serviceID := topologyID("service", service.Namespace, service.Name)
+			if !services[serviceID] {
+				services[serviceID] = true
+				result.Nodes = append(result.Nodes, topologyNode{ID: serviceID, Kind: "service", Namespace: service.Namespace, Name: service.Name})
+			}
+			result.Edges = append(result.Edges, topologyEdge{From: serviceID, To: id, Type: "selects"})
+		}
+
+		if value, ok := deployment.Annotations[dependsOnAnnotation]; ok {
+			dependencies, err := parseDependsOn(value, deployment.Namespace)
+			if err != nil {
+				debugf("Invalid %s annotation on deployment %s: %v", dependsOnAnnotation, key, err)
+			}
+			for _, dependency := range dependencies {
+				namespace, name, _ := strings.Cut(dependency, "/")
+				result.Edges = append(result.Edges, topologyEdge{From: id, To: topologyID("deployment", namespace, name), Type: "depends-on"})
+			}
+		}
+	}
+
+	for _, ingress := range ingresses {
+		ingressID := topologyID("ingress", ingress.Namespace, ingress.Name)
+		routed := make(map[string]bool)
+		for _, backend := range ingressBackends(&ingress) {
+			serviceID := topologyID("service", ingress.Namespace, backend)
+			if !services[serviceID] || routed[serviceID] {
+				continue
+			}
+			routed[serviceID] = true
+			result.Edges = append(result.Edges, topologyEdge{From: ingressID, To: serviceID, Type: "routes"})
+		}
+		if len(routed) > 0 {
+			result.Nodes = append(result.Nodes, topologyNode{ID: ingressID, Kind: "ingress", Namespace: ingress.Namespace, Name: ingress.Name})
+		}
+	}
+
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].ID < result.Nodes[j].ID })
+	sort.Slice(result.Edges, func(i, j int) bool {
+		a, b := result.Edges[i], result.Edges[j]
+		return a.From < b.From || a.From == b.From && a.To < b.To
+	})
+	return result, nil
+}
+
+// trackedDeployments lists the deployments this exporter replica tracks.
+func (t *DeploymentTracker) trackedDeployments() ([]appsv1.Deployment, error) {
+	list, failed := listDeployments(context.Background(), t.clientsetFor, t.namespaces)
+	if err := listError(failed); err != nil {
+		return nil, err
+	}
+	var deployments []appsv1.Deployment
+	for _, deployment := range list {
+		if t.ownsDeployment(deployment.Namespace, deployment.Name) {
+			deployments = append(deployments, deployment)
+		}
+	}
+	return deployments, nil
+}
+
+// downDeployments returns the namespace/name keys of the deployments in an
+// open downtime.
+func (t *DeploymentTracker) downDeployments() map[string]bool {
+	scrapeLock.Lock()
+	defer scrapeLock.Unlock()
+	starts := t.downtimeStart.all()
+	down := make(map[string]bool, len(starts))
+	for key := range starts {
+		down[key] = true
+	}
+	return down
+}
+
+// ingressBackends returns the names of the services an ingress routes to.
+func ingressBackends(ingress *networkingv1.Ingress) []string {
+	var services []string
+	if backend := ingress.Spec.DefaultBackend; backend != nil && backend.Service != nil {
+		services = append(services, backend.Service.Name)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				services = append(services, path.Backend.Service.Name)
+			}
+		}
+	}
+	return services
+}
+
+// dot renders the topology in GraphViz format, deployments colored by
+// health.
+func (g *topology) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph topology {\n")
+	for _, node := range g.Nodes {
+		attrs := fmt.Sprintf("label=%q", node.Kind+"\n"+node.Namespace+"/"+node.Name)
+		switch {
+		case node.Health == "down":
+			attrs += ", color=red"
+		case node.Health == "up":
+			attrs += ", color=green"
+		default:
+			attrs += ", shape=box"
+		}
+		fmt.Fprintf(&b, "  %q [%s];\n", node.ID, attrs)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", edge.From, edge.To, edge.Type)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// handleTopology serves GET /api/v1/topology as JSON, or GraphViz with
+// ?format=dot.
+func (t *DeploymentTracker) handleTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	graph, err := t.topology()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(graph)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		fmt.Fprint(w, graph.dot())
+	default:
+		http.Error(w, fmt.Sprintf("invalid format %q, must be json or dot", format), http.StatusBadRequest)
+	}
+}
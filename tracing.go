@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer of collection cycles. Without --otlp-endpoint the global provider
+// is a no-op and spans cost next to nothing.
+var tracer = otel.Tracer("k8s-deployment-exporter")
+
+// setupTracing exports spans to the OTLP/HTTP collector at --otlp-endpoint
+// and returns the function flushing them on shutdown.
+func setupTracing(opts *options) (func(context.Context) error, error) {
+	if opts.otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	clientOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.otlpEndpoint)}
+	if opts.otlpInsecure {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), clientOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceName("k8s-deployment-exporter")}
+	if opts.instanceID != "" {
+		attrs = append(attrs, semconv.ServiceInstanceID(opts.instanceID))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.traceSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	log.Printf("Exporting traces to %s (sample ratio %g)", opts.otlpEndpoint, opts.traceSampleRatio)
+	return provider.Shutdown, nil
+}
+
+// traceID returns the ID of the sampled trace in ctx, or "" when tracing is
+// disabled or the trace isn't sampled (and so can't be looked up).
+func traceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// deploymentAttributes identifies a deployment on a span.
+func deploymentAttributes(namespace, name string) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.deployment.name", name),
+	)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// metrics-server's default resolution, assumed for samples without a window
+const defaultMetricsResolution = 15 * time.Second
+
+// Deadline of a PodMetrics list; a hung metrics-server counts as failing
+// rather than stalling the scrape cycle
+const metricsAPITimeout = 10 * time.Second
+
+var (
+	// Newest metrics-server sample the usage series are based on; stops
+	// advancing while metrics-server is down or only serves stale samples
+	deploymentUsageSampleTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_usage_sample_timestamp_seconds",
+			Help: "Timestamp of the newest metrics-server sample the deployment's usage metrics are based on (Unix epoch)",
+		},
+		[]string{"namespace", "deployment"},
+	)
+
+	// Running pods left out of the usage sums
+	deploymentUsageStalePods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_usage_stale_pods",
+			Help: "Number of running pods of the deployment without a metrics-server sample newer than twice its resolution in the last usage collection",
+		},
+		[]string{"namespace", "deployment"},
+	)
+)
+
+// listPodMetrics lists the PodMetrics matching the selector, retrying once
+// since metrics-server errors are often transient (e.g. while it scrapes a
+// restarting kubelet). A list that timed out isn't retried; the error goes
+// to the circuit breaker like any other.
+func (t *DeploymentTracker) listPodMetrics(namespace, selector string) (*metricsv1beta1.PodMetricsList, error) {
+	opts := metav1.ListOptions{LabelSelector: selector}
+	metricsClient := t.metricsClientFor(namespace)
+	list := func() (*metricsv1beta1.PodMetricsList, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsAPITimeout)
+		defer cancel()
+		return metricsClient.MetricsV1beta1().PodMetricses(namespace).List(ctx, opts)
+	}
+	podMetrics, err := list()
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) && !apierrors.IsTimeout(err) {
+		debugf("Retrying pod metrics list in namespace %s after error: %v", namespace, err)
+		podMetrics, err = list()
+	}
+	return podMetrics, err
+}
+
+// freshPodMetrics returns the samples of the deployment's pods that are at
+// most twice metrics-server's resolution old, the number of running pods
+// without such a sample and the newest sample time.
+func freshPodMetrics(items []metricsv1beta1.PodMetrics, pods []*corev1.Pod, now time.Time) ([]metricsv1beta1.PodMetrics, int, time.Time) {
+	podNames := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		podNames[pod.Name] = true
+	}
+
+	var fresh []metricsv1beta1.PodMetrics
+	var newest time.Time
+	sampled := make(map[string]bool, len(items))
+	for _, pm := range items {
+		// Only count pods attributed to this deployment
+		if !podNames[pm.Name] {
+			continue
+		}
+		resolution := pm.Window.Duration
+		if resolution <= 0 {
+			resolution = defaultMetricsResolution
+		}
+		if now.Sub(pm.Timestamp.Time) > 2*resolution {
+			continue
+		}
+		fresh = append(fresh, pm)
+		sampled[pm.Name] = true
+		if pm.Timestamp.After(newest) {
+			newest = pm.Timestamp.Time
+		}
+	}
+
+	stale := 0
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning && !sampled[pod.Name] {
+			stale++
+		}
+	}
+	return fresh, stale, newest
+}
+
+// usageTTL drops the usage series of deployments whose newest
+// metrics-server sample is older than the TTL, so Prometheus' staleness
+// handling marks them stale instead of graphing hours-old usage as current.
+type usageTTL struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	sampled map[string]time.Time // namespace/deployment -> newest sample
+}
+
+func newUsageTTL(ttl time.Duration) *usageTTL {
+	return &usageTTL{ttl: ttl, sampled: make(map[string]time.Time)}
+}
+
+// forget drops the last usage sample time of a deleted deployment.
+func (u *usageTTL) forget(ns, name string) {
+	if u == nil {
+		return
+	}
+	key := ns + "/" + name
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.sampled, key)
+}
+
+func (u *usageTTL) record(key string, sampled time.Time) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sampled[key] = sampled
+}
+
+// expired reports once that the deployment's usage outlived the TTL.
+func (u *usageTTL) expired(key string, now time.Time) bool {
+	if u == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	sampled, ok := u.sampled[key]
+	if !ok || now.Sub(sampled) <= u.ttl {
+		return false
+	}
+	delete(u.sampled, key)
+	return true
+}
+
+// expireUsageMetrics deletes the deployment's usage series once its newest
+// sample is older than --usage-ttl. They reappear with the next fresh
+// sample.
+func (t *DeploymentTracker) expireUsageMetrics(ns, name string, now time.Time) {
+	if !t.usage.expired(ns+"/"+name, now) {
+		return
+	}
+	log.Printf("Usage samples of deployment %s/%s are older than %s, dropping usage metrics", ns, name, t.usage.ttl)
+	deploymentCPUUsage.DeleteLabelValues(ns, name)
+	deploymentMemoryUsage.DeleteLabelValues(ns, name)
+	deploymentCPUUsagePercent.DeleteLabelValues(ns, name)
+	deploymentMemoryUsagePercent.DeleteLabelValues(ns, name)
+	deploymentCPUOverThreshold.DeleteLabelValues(ns, name)
+	deploymentMemoryOverThreshold.DeleteLabelValues(ns, name)
+	deploymentCPUUsageAnomaly.DeleteLabelValues(ns, name)
+	deploymentMemoryUsageAnomaly.DeleteLabelValues(ns, name)
+	labels := prometheus.Labels{"namespace": ns, "deployment": name}
+	deploymentClassCPUUsage.DeletePartialMatch(labels)
+	deploymentClassMemoryUsage.DeletePartialMatch(labels)
+	t.gauges.forget(ns, name)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	// PersistentVolumeClaim health for volumes mounted by the deployment
+	deploymentPVCBound = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_pvc_bound",
+			Help: "Whether a PersistentVolumeClaim mounted by the deployment is bound (1=bound, 0=pending/lost/missing)",
+		},
+		[]string{"namespace", "deployment", "persistentvolumeclaim"},
+	)
+
+	deploymentPVCCapacity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_pvc_capacity_bytes",
+			Help: "Capacity in bytes of a PersistentVolumeClaim mounted by the deployment",
+		},
+		[]string{"namespace", "deployment", "persistentvolumeclaim"},
+	)
+
+	deploymentPVCUsagePercent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_pvc_usage_percent",
+			Help: "Used space as percentage of capacity of a PersistentVolumeClaim mounted by the deployment (from kubelet volume stats)",
+		},
+		[]string{"namespace", "deployment", "persistentvolumeclaim"},
+	)
+)
+
+// kubeletSummary is the subset of the kubelet stats/summary response needed
+// for volume usage.
+type kubeletSummary struct {
+	Pods []struct {
+		Volume []struct {
+			PVCRef *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+			UsedBytes     *uint64 `json:"usedBytes"`
+			CapacityBytes *uint64 `json:"capacityBytes"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+type volumeUsage struct {
+	usedBytes     uint64
+	capacityBytes uint64
+}
+
+// volumeStatsCache keeps kubelet volume stats per node so a node's summary is
+// fetched at most once per TTL regardless of how many deployments run there.
+type volumeStatsCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	fetched  map[string]time.Time
+	fetching map[string]chan struct{}          // node -> closed once its fetch finished
+	usage    map[string]map[string]volumeUsage // node -> namespace/pvc -> usage
+}
+
+func newVolumeStatsCache(ttl time.Duration) *volumeStatsCache {
+	return &volumeStatsCache{
+		ttl:      ttl,
+		fetched:  make(map[string]time.Time),
+		fetching: make(map[string]chan struct{}),
+		usage:    make(map[string]map[string]volumeUsage),
+	}
+}
+
+// nodeVolumeUsage returns PVC usage reported by the kubelet on a node. Like
+// the cAdvisor counters, the summary is fetched without c.mu held and only
+// once per node at a time.
+func (t *DeploymentTracker) nodeVolumeUsage(nodeName string) map[string]volumeUsage {
+	c := t.volumeStats
+	c.mu.Lock()
+	if done, ok := c.fetching[nodeName]; ok {
+		c.mu.Unlock()
+		<-done
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.usage[nodeName]
+	}
+	if fetched, ok := c.fetched[nodeName]; ok && time.Since(fetched) < c.ttl {
+		defer c.mu.Unlock()
+		return c.usage[nodeName]
+	}
+	done := make(chan struct{})
+	c.fetching[nodeName] = done
+	c.fetched[nodeName] = time.Now()
+	c.mu.Unlock()
+
+	usage, err := t.fetchVolumeUsage(nodeName)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fetching, nodeName)
+	close(done)
+	if err != nil {
+		log.Printf("Error fetching kubelet stats summary from node %s: %v", nodeName, err)
+		return c.usage[nodeName]
+	}
+	c.usage[nodeName] = usage
+	return usage
+}
+
+// fetchVolumeUsage fetches and decodes a node's kubelet stats summary.
+func (t *DeploymentTracker) fetchVolumeUsage(nodeName string) (map[string]volumeUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kubeletProxyTimeout)
+	defer cancel()
+	raw, err := t.clientset.CoreV1().RESTClient().Get().
+		Resource("nodes").Name(nodeName).SubResource("proxy").Suffix("stats/summary").
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var summary kubeletSummary
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	usage := make(map[string]volumeUsage)
+	for _, pod := range summary.Pods {
+		for _, volume := range pod.Volume {
+			if volume.PVCRef == nil || volume.UsedBytes == nil || volume.CapacityBytes == nil {
+				continue
+			}
+			usage[volume.PVCRef.Namespace+"/"+volume.PVCRef.Name] = volumeUsage{
+				usedBytes:     *volume.UsedBytes,
+				capacityBytes: *volume.CapacityBytes,
+			}
+		}
+	}
+	return usage, nil
+}
+
+// deploymentVolumeUsage returns the kubelet volume stats of the
+// PersistentVolumeClaims referenced by the pod template by claim name, from
+// the nodes the deployment's pods run on. It returns nil without
+// --pvc-usage.
+func (t *DeploymentTracker) deploymentVolumeUsage(deployment *appsv1.Deployment, pods []*corev1.Pod) map[string]volumeUsage {
+	if t.volumeStats == nil {
+		return nil
+	}
+	usage := make(map[string]volumeUsage)
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claimName := volume.PersistentVolumeClaim.ClaimName
+		for _, pod := range pods {
+			if pod.Spec.NodeName == "" {
+				continue
+			}
+			if u, ok := t.nodeVolumeUsage(pod.Spec.NodeName)[deployment.Namespace+"/"+claimName]; ok && u.capacityBytes > 0 {
+				usage[claimName] = u
+				break
+			}
+		}
+	}
+	return usage
+}
+
+// collectVolumeMetrics reports binding state, capacity and (with the usage
+// from deploymentVolumeUsage) usage for every PersistentVolumeClaim
+// referenced by the pod template. It waits for the PVC cache to sync, as
+// claims missing from it would be reported unbound.
+func (t *DeploymentTracker) collectVolumeMetrics(deployment *appsv1.Deployment, usage map[string]volumeUsage) {
+	ns := deployment.Namespace
+	name := deployment.Name
+
+	pvcs := t.cachesFor(ns).pvcs
+	if pvcs == nil || !pvcs.HasSynced() {
+		return
+	}
+
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claimName := volume.PersistentVolumeClaim.ClaimName
+
+		obj, exists, err := pvcs.GetIndexer().GetByKey(ns + "/" + claimName)
+		if err != nil || !exists {
+			// A missing claim keeps pods pending just like an unbound one
+			deploymentPVCBound.WithLabelValues(ns, name, claimName).Set(0)
+			continue
+		}
+		pvc := obj.(*corev1.PersistentVolumeClaim)
+
+		bound := float64(0)
+		if pvc.Status.Phase == corev1.ClaimBound {
+			bound = 1
+		}
+		deploymentPVCBound.WithLabelValues(ns, name, claimName).Set(bound)
+
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			deploymentPVCCapacity.WithLabelValues(ns, name, claimName).Set(float64(capacity.Value()))
+		}
+
+		if u, ok := usage[claimName]; ok {
+			percent := float64(u.usedBytes) / float64(u.capacityBytes) * 100
+			deploymentPVCUsagePercent.WithLabelValues(ns, name, claimName).Set(percent)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	// SLA evidence per closed week, kept beyond Prometheus retention by
+	// re-exposing it on every scrape
+	deploymentWeeklyAvailability = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_deployment_weekly_availability",
+			Help: "Availability in percent of the deployment during the closed ISO week (UTC), computed from its incidents",
+		},
+		[]string{"namespace", "deployment", "week"},
+	)
+)
+
+// weeklyAvailability publishes the availability of every deployment for the
+// last closed weeks and rolls them over at each week boundary.
+type weeklyAvailability struct {
+	tracker *DeploymentTracker
+	weeks   int
+
+	published map[string]bool // week labels currently exposed
+}
+
+func newWeeklyAvailability(tracker *DeploymentTracker, weeks int) *weeklyAvailability {
+	return &weeklyAvailability{tracker: tracker, weeks: weeks, published: make(map[string]bool)}
+}
+
+// weekStart returns the start of the ISO week (Monday 00:00 UTC) containing
+// t.
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+}
+
+// weekLabel formats the ISO week of t, e.g. 2024-W22.
+func weekLabel(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func (w *weeklyAvailability) run() {
+	for {
+		w.update(time.Now())
+		// Leave the incidents of the closing week a moment to resolve
+		next := weekStart(time.Now()).AddDate(0, 0, 7).Add(time.Minute)
+		time.Sleep(time.Until(next))
+	}
+}
+
+// update computes the availability of the last closed weeks and drops the
+// series of weeks that fell out of the window.
+func (w *weeklyAvailability) update(now time.Time) {
+	created := make(map[string]time.Time)
+	deployments, err := w.tracker.trackedDeployments()
+	if err != nil {
+		// Deployments with incidents are still reported
+		log.Printf("Error computing weekly availability: %v", err)
+	}
+	for _, d := range deployments {
+		created[d.Namespace+"/"+d.Name] = d.CreationTimestamp.Time
+	}
+	incidents := w.tracker.incidents.list("", "")
+
+	current := make(map[string]bool, w.weeks)
+	end := weekStart(now)
+	for i := 0; i < w.weeks; i++ {
+		start := end.AddDate(0, 0, -7)
+		week := weekLabel(start)
+		current[week] = true
+
+		downtime := make(map[string]time.Duration)
+		names := make(map[string]types.NamespacedName)
+		for _, d := range deployments {
+			names[d.Namespace+"/"+d.Name] = types.NamespacedName{Namespace: d.Namespace, Name: d.Name}
+		}
+		for _, inc := range incidents {
+			key := inc.Namespace + "/" + inc.Deployment
+			incEnd := now
+			if inc.End != nil {
+				incEnd = *inc.End
+			}
+			if overlap := overlapDuration(inc.Start, incEnd, start, end); overlap > 0 {
+				// Blind spots within the downtime aren't known to be down
+				downtime[key] += overlap - w.tracker.blindSpots.overlap(maxTime(inc.Start, start), minTime(incEnd, end))
+				names[key] = types.NamespacedName{Namespace: inc.Namespace, Name: inc.Deployment}
+			}
+		}
+
+		for key, name := range names {
+			// Deployments created during the week count from their creation,
+			// later ones have no availability for it
+			from := start
+			if c, ok := created[key]; ok && c.After(start) {
+				from = c
+			}
+			if !from.Before(end) {
+				deploymentWeeklyAvailability.DeleteLabelValues(name.Namespace, name.Name, week)
+				continue
+			}
+			// Unmonitored time counts as neither uptime nor downtime
+			span := end.Sub(from) - w.tracker.blindSpots.overlap(from, end)
+			if span <= 0 {
+				deploymentWeeklyAvailability.DeleteLabelValues(name.Namespace, name.Name, week)
+				continue
+			}
+			availability := 100 * (1 - downtime[key].Seconds()/span.Seconds())
+			if availability < 0 {
+				availability = 0
+			}
+			deploymentWeeklyAvailability.WithLabelValues(name.Namespace, name.Name, week).Set(availability)
+		}
+		end = start
+	}
+
+	for week := range w.published {
+		if !current[week] {
+			deploymentWeeklyAvailability.DeletePartialMatch(prometheus.Labels{"week": week})
+		}
+	}
+	w.published = current
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// overlapDuration returns how much of [start, end) falls into
+// [windowStart, windowEnd).
+func overlapDuration(start, end, windowStart, windowEnd time.Time) time.Duration {
+	if start.Before(windowStart) {
+		start = windowStart
+	}
+	if end.After(windowEnd) {
+		end = windowEnd
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metric families pushed to Zabbix and the item each one maps to
+var zabbixItems = map[string]string{
+	"k8s_deployment_status":                   "status",
+	"k8s_deployment_replicas_desired":         "replicas.desired",
+	"k8s_deployment_replicas_ready":           "replicas.ready",
+	"k8s_deployment_replicas_unavailable":     "replicas.unavailable",
+	"k8s_deployment_downtime_seconds_rate_1h": "downtime.rate1h",
+	"k8s_deployment_restarts_rate_1h":         "restarts.rate1h",
+}
+
+// zabbixSender pushes the key health values of a subset of deployments to a
+// Zabbix server or proxy with the sender protocol, for NOC tooling that
+// can't consume Prometheus. Values go to trapper items named
+// k8s.deployment.<item>[<namespace>,<deployment>] on one Zabbix host.
+type zabbixSender struct {
+	gatherer    prometheus.Gatherer
+	addr        string
+	host        string
+	deployments []string // namespace/deployment glob patterns
+}
+
+func newZabbixSender(opts *options, gatherer prometheus.Gatherer) *zabbixSender {
+	return &zabbixSender{
+		gatherer:    gatherer,
+		addr:        opts.zabbixAddr,
+		host:        opts.zabbixHost,
+		deployments: splitList(opts.zabbixDeployments),
+	}
+}
+
+// validateZabbixDeployments checks a --zabbix-deployments pattern list.
+func validateZabbixDeployments(list string) error {
+	for _, pattern := range splitList(list) {
+		if !strings.Contains(pattern, "/") {
+			return fmt.Errorf("invalid pattern %q, must be namespace/deployment", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// selected reports whether the deployment's values are pushed.
+func (z *zabbixSender) selected(namespace, deployment string) bool {
+	for _, pattern := range z.deployments {
+		if ok, _ := path.Match(pattern, namespace+"/"+deployment); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// zabbixValue is one item value of a sender data request.
+type zabbixValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+// values gathers the item values of the selected deployments.
+func (z *zabbixSender) values(now time.Time) ([]zabbixValue, error) {
+	families, err := z.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var values []zabbixValue
+	for _, family := range families {
+		item, ok := zabbixItems[family.GetName()]
+		if !ok {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			var namespace, deployment string
+			for _, label := range metric.GetLabel() {
+				switch label.GetName() {
+				case "namespace":
+					namespace = label.GetValue()
+				case "deployment":
+					deployment = label.GetValue()
+				}
+			}
+			value := sampleValue(family.GetType(), metric)
+			if !z.selected(namespace, deployment) || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			values = append(values, zabbixValue{
+				Host:  z.host,
+				Key:   fmt.Sprintf("k8s.deployment.%s[%s,%s]", item, namespace, deployment),
+				Value: fmt.Sprintf("%g", value),
+				Clock: now.Unix(),
+			})
+		}
+	}
+	return values, nil
+}
+
+// send pushes the values in one sender data request and returns the
+// server's info, e.g. "processed: 5; failed: 0; total: 5; ...".
+func (z *zabbixSender) send(values []zabbixValue, now time.Time) (string, error) {
+	payload, err := json.Marshal(struct {
+		Request string        `json:"request"`
+		Data    []zabbixValue `json:"data"`
+		Clock   int64         `json:"clock"`
+	}{Request: "sender data", Data: values, Clock: now.Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.DialTimeout("tcp", z.addr, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(now.Add(30 * time.Second))
+
+	// ZBXD, protocol flags, then the little-endian data length
+	var request bytes.Buffer
+	request.WriteString("ZBXD\x01")
+	binary.Write(&request, binary.LittleEndian, uint64(len(payload)))
+	request.Write(payload)
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return "", err
+	}
+
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	if string(header[:4]) != "ZBXD" {
+		return "", fmt.Errorf("unexpected response header %q", header[:4])
+	}
+	length := binary.LittleEndian.Uint64(header[5:])
+	if length > 1<<20 {
+		return "", fmt.Errorf("response of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	var response struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("decoding response: %w", err)
+	}
+	if response.Response != "success" {
+		return "", fmt.Errorf("server responded %q: %s", response.Response, response.Info)
+	}
+	return response.Info, nil
+}
+
+// run pushes every interval.
+func (z *zabbixSender) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		values, err := z.values(now)
+		if err != nil {
+			log.Printf("Error gathering metrics for Zabbix: %v", err)
+			continue
+		}
+		if len(values) == 0 {
+			continue
+		}
+		info, err := z.send(values, now)
+		if err != nil {
+			log.Printf("Error sending values to Zabbix %s: %v", z.addr, err)
+			continue
+		}
+		// Values of items that don't exist on the host count as failed
+		if !strings.Contains(info, "failed: 0;") {
+			log.Printf("Warning: Zabbix %s didn't accept all values (%s), check the trapper items of host %s", z.addr, info, z.host)
+		}
+		debugf("Sent %d values to Zabbix: %s", len(values), info)
+	}
+}